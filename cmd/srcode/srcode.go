@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/creekorful/srcode/internal/cmd"
 	"github.com/creekorful/srcode/internal/codebase"
 	"github.com/creekorful/srcode/internal/manifest"
+	"github.com/creekorful/srcode/internal/repository"
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
@@ -12,11 +15,15 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 var (
@@ -24,30 +31,70 @@ var (
 	// https://goreleaser.com/environment/
 	version = "dev"
 
-	errWrongInitUsage       = errors.New("correct usage: srcode init <path>")
-	errWrongCloneUsage      = errors.New("correct usage: srcode clone <remote> [<path>]")
-	errWrongAddProjectUsage = errors.New("correct usage: srcode add <remote> [<path>]")
-	errWrongRunUsage        = errors.New("correct usage: srcode run <script>")
-	errWrongBulkGitUsage    = errors.New("correct usage: srcode bulk-git <args>")
-	errWrongMvUsage         = errors.New("correct usage: srcode mv <src> <dst>")
-	errWrongRmUsage         = errors.New("correct usage: srcode rm <path>")
-	errWrongHookUsage       = errors.New("correct usage: srcode hook <script>")
+	errWrongInitUsage            = errors.New("correct usage: srcode init <path>")
+	errWrongCloneUsage           = errors.New("correct usage: srcode clone <remote> [<path>]")
+	errWrongBackupUsage          = errors.New("correct usage: srcode backup <dir>")
+	errWrongRestoreUsage         = errors.New("correct usage: srcode restore <dir> [<path>]")
+	errWrongAddProjectUsage      = errors.New("correct usage: srcode add <remote> [<path>]")
+	errWrongRunUsage             = errors.New("correct usage: srcode run <script>")
+	errWrongBulkGitUsage         = errors.New("correct usage: srcode bulk-git <args>")
+	errWrongMvUsage              = errors.New("correct usage: srcode mv <src> <dst>")
+	errWrongRmUsage              = errors.New("correct usage: srcode rm <path>")
+	errWrongHookUsage            = errors.New("correct usage: srcode hook <type> <script...>")
+	errWrongHookRmUsage          = errors.New("correct usage: srcode hook rm <type>")
+	errWrongHookExecUsage        = errors.New("correct usage: srcode hook exec <type> [<args...>]")
+	errWrongScriptShowUsage      = errors.New("correct usage: srcode script show <name>")
+	errWrongScriptRmUsage        = errors.New("correct usage: srcode script rm <name>")
+	errWrongScriptMvUsage        = errors.New("correct usage: srcode script mv <old-name> <new-name>")
+	errWrongScriptPromoteUsage   = errors.New("correct usage: srcode script promote <name> [<global-name>]")
+	errWrongSecretSetUsage       = errors.New("correct usage: srcode secret set <name> [<value>]")
+	errWrongSecretGetUsage       = errors.New("correct usage: srcode secret get <name>")
+	errWrongSecretRmUsage        = errors.New("correct usage: srcode secret rm <name>")
+	errWrongLogsUsage            = errors.New("correct usage: srcode logs [<script>]")
+	errWrongRemoteSetUsage       = errors.New("correct usage: srcode remote set <path> <name> <url>")
+	errWrongRemoteRewriteUsage   = errors.New("correct usage: srcode remote rewrite <from> <to>")
+	errWrongSnapshotSaveUsage    = errors.New("correct usage: srcode snapshot save <name>")
+	errWrongSnapshotRestoreUsage = errors.New("correct usage: srcode snapshot restore <name>")
+	errWrongSnapshotDiffUsage    = errors.New("correct usage: srcode snapshot diff <from> <to>")
+	errWrongTopicStartUsage      = errors.New("correct usage: srcode topic start <name> [<projects...>]")
+	errWrongTopicStatusUsage     = errors.New("correct usage: srcode topic status [<name>]")
+	errWrongTopicSwitchUsage     = errors.New("correct usage: srcode topic switch <name>")
+	errWrongTopicFinishUsage     = errors.New("correct usage: srcode topic finish [<name>]")
+	errWrongWorktreeAddUsage     = errors.New("correct usage: srcode worktree add <project> <branch>")
+	errWrongWorktreeLsUsage      = errors.New("correct usage: srcode worktree ls [<project>]")
+	errWrongWorktreeRmUsage      = errors.New("correct usage: srcode worktree rm [--force] <project> <branch>")
+	errWrongManifestMergeUsage   = errors.New("correct usage: srcode manifest merge <base> <ours> <theirs> <path>")
+	errWrongManifestResolveUsage = errors.New("correct usage: srcode manifest resolve --local|--remote|--abort [<conflicts...>]")
+
+	errManifestConflict = errors.New("conflicting changes")
 )
 
 func main() {
 	app := app{
 		codebaseProvider: codebase.DefaultProvider,
+		reader:           os.Stdin,
 		writer:           os.Stdout,
 	}
 
 	if err := app.getCliApp().Run(os.Args); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+		// a failing script has already reported its error: only forward the exit code
+		var exitErr *exec.ExitError
+		var gitErr *repository.GitError
+		if errors.As(err, &gitErr) || !errors.As(err, &exitErr) {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+
+			if hint := getErrorHint(err); hint != "" {
+				_, _ = fmt.Fprintf(os.Stderr, "Tips: %s\n", hint)
+			}
+		}
+
+		os.Exit(getExitCode(err))
 	}
 }
 
 type app struct {
 	codebaseProvider codebase.Provider
+	reader           io.Reader
 	writer           io.Writer
 }
 
@@ -103,6 +150,36 @@ Examples
 
 - Clone a codebase into specific directory:
   $ srcode clone git@github.com:creekorful/dot-srcode.git /path/to/custom/directory`,
+			},
+			{
+				Name:      "backup",
+				Usage:     "Backup the codebase into a directory",
+				Action:    app.backupCodebase,
+				ArgsUsage: "<dir>",
+				Description: `
+Write a git bundle of the codebase and of every project (all branches, including the unpushed ones) into the directory.
+The next backups in the same directory only bundle the new commits.
+
+Only the committed changes are backed up.
+
+Examples
+
+- Backup the codebase on an USB drive:
+  $ srcode backup /media/usb/codebase-backup`,
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore a codebase from a backup",
+				Action:    app.restoreCodebase,
+				ArgsUsage: "<dir> [<path>]",
+				Description: `
+Recreate the codebase from the backup located in the directory, without network access.
+The projects origin remote are set back to their real remote.
+
+Examples
+
+- Restore the codebase from an USB drive:
+  $ srcode restore /media/usb/codebase-backup ~/Projects`,
 			},
 			{
 				Name:      "add",
@@ -110,18 +187,74 @@ Examples
 				Action:    app.addProject,
 				ArgsUsage: "<remote> [<path>]",
 				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "source",
+						Usage: "The type of the project source (git, archive or symlink)",
+						Value: manifest.SourceGit,
+					},
+					&cli.StringFlag{
+						Name:  "checksum",
+						Usage: "The sha256 checksum of the archive (required by the archive source)",
+					},
 					&cli.StringSliceFlag{
 						Name:  "git-config",
 						Usage: "Git configuration to apply (format key=value)",
 					},
+					&cli.IntFlag{
+						Name:  "depth",
+						Usage: "Create a shallow clone with a history truncated to the number of commits",
+					},
+					&cli.StringFlag{
+						Name:  "filter",
+						Usage: "Create a partial clone using the filter (e.g blob:none)",
+					},
+					&cli.StringFlag{
+						Name:  "upstream",
+						Usage: "The url of the upstream remote (when the project is a fork)",
+					},
+					&cli.StringFlag{
+						Name:  "branch",
+						Usage: "The branch the project should be on (default to the remote HEAD)",
+					},
+					&cli.BoolFlag{
+						Name:  "single-branch",
+						Usage: "Clone only the history of the checked out branch",
+					},
+					&cli.BoolFlag{
+						Name:  "recurse-submodules",
+						Usage: "Initialize and clone the submodules",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-lfs",
+						Usage: "Do not download the LFS files (use git lfs pull to fetch them)",
+					},
 				},
 				Description: `
 Add a project (git repository) to the current codebase.
 
+The clone options (--depth, --filter, ...) are saved in the manifest
+and used each time the project is cloned (srcode clone, srcode sync).
+
+A project may also be a vendored archive (tar, tar.gz or zip, verified
+using its sha256 checksum) extracted in the project directory, or a
+symbolic link to a local directory (--source archive|symlink).
+
 Examples
 
 - Add a project with custom git configuration:
-  $ srcode add --git-config user.email=alois@micard.lu --git-config commit.gpgsign=true git@github.com:darkspot-org/bathyscaphe.git Darkspot/bathyscaphe`,
+  $ srcode add --git-config user.email=alois@micard.lu --git-config commit.gpgsign=true git@github.com:darkspot-org/bathyscaphe.git Darkspot/bathyscaphe
+
+- Add a big repository without downloading the files history:
+  $ srcode add --filter blob:none git@github.com:torvalds/linux.git Kernel/linux
+
+- Add a fork alongside its upstream repository:
+  $ srcode add --upstream git@github.com:darkspot-org/bathyscaphe.git git@github.com:creekorful/bathyscaphe.git Darkspot/bathyscaphe
+
+- Add a vendored SDK archive:
+  $ srcode add --source archive --checksum 2f1c...e9a0 https://example.org/sdk-1.2.0.tar.gz Vendor/sdk
+
+- Add a link to a directory living outside of the codebase:
+  $ srcode add --source symlink ~/Documents/notes Personal/notes`,
 			},
 			{
 				Name:   "sync",
@@ -137,6 +270,10 @@ Examples
 Synchronize the codebase with the linked remote - i.e install & configure new project and remove removed ones,
 while pushing the changes.
 
+The manifest changes are merged per project and per script (see srcode manifest merge): the synchronization
+only stops on true conflicts (e.g the same script changed on both sides). The conflicting values are then listed,
+and have to be resolved using srcode manifest resolve before synchronizing again.
+
 Examples
 
 - Synchronize with remote and delete removed projects:
@@ -154,21 +291,69 @@ Print the codebase working directory - i.e the working directory relative to the
 				Usage:     "Run a codebase script",
 				Action:    app.runScript,
 				ArgsUsage: "<script>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "If true re-run the script each time the project files are changed",
+					},
+					&cli.StringSliceFlag{
+						Name:  "project",
+						Usage: "The project(s) to watch (default to the current one)",
+					},
+				},
 				Description: `
 Run a script inside a codebase project.
 
+The script is attached to the current terminal, and the signals received by srcode
+are forwarded to it. srcode exit with the same code as the script, so it can be used
+from other scripts.
+
+A timeout can be set per script using the timeouts section of the manifest,
+either at global or project level (e.g "timeouts": {"test": "5m"}).
+Once the timeout is reached, the script and all its child processes are killed.
+
+When --watch is provided, the script is re-run each time a file of the project is changed.
+The files ignored by Git are not watched, and an in-flight execution is cancelled when
+a new change is detected. Several projects can be watched using --project: only the project
+that has changed is re-run, from its root directory.
+
 Examples
 
 - Execute a script named lint:
   $ srcode run lint
-  $ srcode lint`,
+  $ srcode lint
+
+- Execute the tests each time a file is changed:
+  $ srcode run --watch test
+
+- Execute the tests of both api and web projects on change:
+  $ srcode run --watch --project Work/api --project Work/web test`,
 			},
 			{
 				Name:   "ls",
 				Usage:  "Display the codebase projects",
 				Action: app.lsProjects,
 				Description: `
-Display the codebase projects with their details.`,
+Display the codebase projects with their details.
+
+The projects not on their tracked branch are highlighted in red,
+the ones having uncommitted changes in yellow.`,
+			},
+			{
+				Name:   "checkout-default",
+				Usage:  "Switch the projects back to their tracked branch",
+				Action: app.checkoutDefault,
+				Description: `
+Switch the projects not on their tracked branch back to it.
+The projects having uncommitted changes are left untouched.
+
+Examples
+
+- Set the tracked branch of a project when adding it:
+  $ srcode add --branch develop git@github.com:darkspot-org/bathyscaphe.git Darkspot/bathyscaphe
+
+- Switch all the projects back to their tracked branch:
+  $ srcode checkout-default`,
 			},
 			{
 				Name:      "bulk-git",
@@ -214,6 +399,80 @@ Examples
 
 Now you can use 'srcode run test' or 'srcode test' to execute the script
 from project directory.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "show",
+						Usage:     "Display a script",
+						Action:    app.showScript,
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true display the global script",
+							},
+						},
+						Description: `
+Display the content of a script. Project aliases are resolved to the global script content.
+
+Examples
+
+- Display the test script of current project:
+  $ srcode script show test`,
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove a script",
+						Action:    app.rmScript,
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true remove the global script",
+							},
+						},
+						Description: `
+Remove a script. A global script cannot be removed while project aliases still reference it,
+and a project script cannot be removed while used as hook.
+
+Examples
+
+- Remove the global go-test script:
+  $ srcode script rm --global go-test`,
+					},
+					{
+						Name:      "mv",
+						Usage:     "Rename a script",
+						Action:    app.mvScript,
+						ArgsUsage: "<old-name> <new-name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true rename the global script",
+							},
+						},
+						Description: `
+Rename a script. When renaming a global script, the project aliases are updated too.
+
+Examples
+
+- Rename the global go-test script to go-test-race:
+  $ srcode script mv --global go-test go-test-race`,
+					},
+					{
+						Name:      "promote",
+						Usage:     "Promote a project script to global",
+						Action:    app.promoteScript,
+						ArgsUsage: "<name> [<global-name>]",
+						Description: `
+Promote a project script to a global script, and replace the project script
+by an alias to the newly created global script.
+
+Examples
+
+- Promote the lint script of current project to a global script named go-lint:
+  $ srcode script promote lint go-lint`,
+					},
+				},
 			},
 			{
 				Name:      "mv",
@@ -251,17 +510,523 @@ Examples
 			},
 			{
 				Name:      "hook",
-				Usage:     "Set project Git pre-push hook",
+				Usage:     "Set project Git hook",
 				Action:    app.hook,
-				ArgsUsage: "<script>",
+				ArgsUsage: "<type> <script...>",
 				Description: `
-Set the Git pre-push hook for current project. This will lookup for the script with
-given name, and copy the content to the .git/hooks folder.
+Set a Git hook (pre-commit, commit-msg, pre-push, post-checkout, ...) for current project.
+This will install a small shim in the .git/hooks folder, delegating the hook execution to srcode:
+the scripts are therefore always up-to-date. If several scripts are provided, they are executed in order,
+with the hook arguments and input, and the hook stops at the first failure.
+
+If a hook not installed by srcode (husky, pre-commit framework, ...) is already present, it is backed up
+as <type>.srcode-chained, and executed before the scripts.
+
+If only a script is provided, it's used as pre-push hook. A global script can be used directly using @<name>.
+
+Default hooks can be assigned to every project matching a path glob and/or having a tag,
+using the defaultHooks section of the manifest, e.g:
+
+  "defaultHooks": [{"path": "Work/**", "hooks": {"commit-msg": ["@conventional-commits"]}}]
+
+They are installed automatically on add, clone and sync. A project can opt-out using
+"noDefaultHooks": true, or by removing the hook (srcode hook rm <type>).
 
 Examples
 
 - Make Git run lint script before pushing the commit:
-  $ srcode hook lint`,
+  $ srcode hook pre-push lint
+
+- Make Git run fmt then test scripts before committing:
+  $ srcode hook pre-commit fmt test`,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "ls",
+						Usage:  "Display the project hooks",
+						Action: app.lsHooks,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "If true display the hooks of every project",
+							},
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove a project hook",
+						Action:    app.rmHook,
+						ArgsUsage: "<type>",
+						Description: `
+Remove a Git hook from current project. The backed up hook (if any) is restored.`,
+					},
+					{
+						Name:      "exec",
+						Usage:     "Execute a project hook",
+						Action:    app.execHook,
+						ArgsUsage: "<type> [<args...>]",
+						Hidden:    true, // used by the hook shims
+					},
+				},
+			},
+			{
+				Name:   "env",
+				Usage:  "Display the project environment",
+				Action: app.env,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "export",
+						Usage: "If true display the variables as shell export statements",
+					},
+					&cli.BoolFlag{
+						Name:  "secrets",
+						Usage: "If true include the (decrypted) secrets",
+					},
+				},
+				Description: `
+Display the environment variables injected in the scripts and hooks of current project.
+
+The variables are defined using the env section of the manifest, at global, directory
+(directories section) or project level, and by the .env file located in the project directory.
+They are merged in the following order (last one wins): global, directories (outermost first),
+project, secrets, .env file. srcode also defines SRCODE_ROOT, SRCODE_PROJECT and SRCODE_REMOTE.
+The secrets are only displayed if --secrets is provided.
+
+Examples
+
+- Load the project environment in current shell:
+  $ eval "$(srcode env --export)"`,
+			},
+			{
+				Name:  "secret",
+				Usage: "Manage the codebase secrets",
+				Description: `
+Manage the secrets (API tokens, passwords, ...) used by the codebase scripts.
+
+The secrets are stored encrypted in the manifest, using a codebase key (age X25519) generated
+on first use. The private key never leaves the machine: it's stored in the user configuration
+directory (or in $SRCODE_KEYS_DIR if set) and must be copied manually to the other machines.
+
+The secrets are decrypted only into the environment of the scripts and hooks.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set",
+						Usage:     "Set a secret",
+						Action:    app.setSecret,
+						ArgsUsage: "<name> [<value>]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true use the global secret",
+							},
+						},
+						Description: `
+Set a secret at global level (--global) or at project level.
+If value is not provided, it's read from stdin.
+
+Examples
+
+- Set a global GITHUB_TOKEN secret:
+  $ srcode secret set --global GITHUB_TOKEN < token.txt`,
+					},
+					{
+						Name:      "get",
+						Usage:     "Display a secret",
+						Action:    app.getSecret,
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true use the global secret",
+							},
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove a secret",
+						Action:    app.rmSecret,
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "global",
+								Usage: "If true use the global secret",
+							},
+						},
+					},
+					{
+						Name:   "ls",
+						Usage:  "Display the available secrets",
+						Action: app.lsSecrets,
+					},
+				},
+			},
+			{
+				Name:      "logs",
+				Usage:     "Display the script executions",
+				Action:    app.logs,
+				ArgsUsage: "[<script>]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "failed",
+						Usage: "If true display the output of the last failed execution",
+					},
+					&cli.StringFlag{
+						Name:  "id",
+						Usage: "Display the output of the execution with given id",
+					},
+				},
+				Description: `
+Display the recent script executions of current project (or of the whole codebase
+if executed outside a project).
+
+Every execution of srcode run is recorded (output, arguments, exit code, duration and HEAD commit)
+in the .srcode/runs directory. This directory is never committed, and only the last 100 executions are kept.
+
+Examples
+
+- Display the recent executions of the test script:
+  $ srcode logs test
+
+- Display the output of the last failed execution:
+  $ srcode logs --failed`,
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the mirrors cache",
+				Description: `
+Manage the cache of bare mirrors used to speed up the clones.
+
+When a project is cloned (srcode add, clone or sync), its mirror is created (or refreshed)
+in the cache, and the project is cloned using the mirror objects. The clone does not depend
+on the mirror afterward. The shallow and partial clones are not cached.
+
+The cache is located in the user cache directory (or in $SRCODE_CACHE_DIR if set)
+and is shared by the codebases of the machine.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "update",
+						Usage:  "Create or refresh the mirrors",
+						Action: app.updateCache,
+						Description: `
+Refresh the existing mirrors, and create the missing mirrors of the codebase projects.`,
+					},
+					{
+						Name:   "gc",
+						Usage:  "Remove the unused mirrors",
+						Action: app.gcCache,
+						Description: `
+Remove the mirrors whose remote is not used by any codebase of the machine anymore.`,
+					},
+				},
+			},
+			{
+				Name:  "remote",
+				Usage: "Manage the projects remotes",
+				Description: `
+Manage the projects remotes. The origin remote is the project primary remote,
+the others (e.g upstream) are saved in the manifest and added to the project
+each time it is cloned or synchronized.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set",
+						Usage:     "Set the url of a project remote",
+						Action:    app.setRemote,
+						ArgsUsage: "<path> <name> <url>",
+						Description: `
+Set the url of a project remote, adding the remote if it does not exist.
+
+Examples
+
+- Add the upstream remote to the current project:
+  $ srcode remote set . upstream git@github.com:darkspot-org/bathyscaphe.git`,
+					},
+					{
+						Name:      "rewrite",
+						Usage:     "Rewrite the projects remotes url",
+						Action:    app.rewriteRemotes,
+						ArgsUsage: "<from> <to>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Only display the remotes that would be rewritten",
+							},
+						},
+						Description: `
+Replace the <from> prefix of the projects remotes url by <to>, in the manifest
+as well as in the local repositories. The other machines are updated on sync.
+
+Examples
+
+- Preview the migration of the projects to a new git host:
+  $ srcode remote rewrite --dry-run git@old.example.org:team/ ssh://git@new.example.org/team/
+
+- Switch the GitHub projects from SSH to HTTPS:
+  $ srcode remote rewrite git@github.com: https://github.com/`,
+					},
+				},
+			},
+			{
+				Name:  "manifest",
+				Usage: "Manage the codebase manifest",
+				Description: `
+Manage the codebase manifest. The manifest is either .srcode/manifest.json
+or .srcode/manifest.yaml, the format being chosen using the file extension.
+
+In the yaml manifest, a script may be written as a block scalar (one command per line).
+
+With the split layout, each project is stored in .srcode/projects/<path>.<ext>
+and each global script in .srcode/scripts/<name>.<ext>, reducing the merge conflicts.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "convert",
+						Usage:  "Convert the manifest to another format",
+						Action: app.convertManifest,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "to",
+								Usage:    "The format of the manifest (json or yaml)",
+								Required: true,
+							},
+						},
+						Description: `
+Rewrite the manifest using the given format, and commit the change.
+The other machines are using the new manifest once synchronized.
+
+Examples
+
+- Switch to the yaml manifest:
+  $ srcode manifest convert --to yaml`,
+					},
+					{
+						Name:   "split",
+						Usage:  "Store each project & each global script in its own file",
+						Action: app.splitManifest,
+						Description: `
+Migrate the manifest to the split layout, and commit the change: each project
+is moved into .srcode/projects/<path>.<ext> and each global script into
+.srcode/scripts/<name>.<ext>. The manifest is then assembled transparently.
+
+Since the machines edit distinct files when adding different projects,
+the synchronization no longer conflicts on the manifest.
+
+Examples
+
+- Split the manifest:
+  $ srcode manifest split`,
+					},
+					{
+						Name:      "merge",
+						Usage:     "Merge the versions of a manifest file (git merge driver)",
+						ArgsUsage: "<base> <ours> <theirs> <path>",
+						Action:    app.mergeManifest,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "report",
+								Usage: "Append the conflicts to the report file (used by sync to display them)",
+							},
+						},
+						Description: `
+Three-way merge of the versions of a manifest file (the manifest itself, or a
+project / script file of the split layout), used by sync as git merge driver.
+
+The changes are merged per project and per script: only the values changed
+differently on both sides (e.g the same script) are conflicting. The value of
+ours is then kept, and the conflicts are displayed.
+
+The result is written to the <ours> file, <path> is the path of the file
+in the meta repository (used to detect its format).
+
+Examples
+
+- Configured by sync in .srcode/.git/config:
+  driver = srcode manifest merge --report .srcode/.git/srcode-conflicts %O %A %B %P`,
+					},
+					{
+						Name:      "resolve",
+						Usage:     "Resolve the manifest conflicts which have stopped the synchronization",
+						ArgsUsage: "--local|--remote|--abort [<conflicts...>]",
+						Action:    app.resolveManifest,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "local",
+								Usage: "Keep the local values",
+							},
+							&cli.BoolFlag{
+								Name:  "remote",
+								Usage: "Keep the remote values",
+							},
+							&cli.BoolFlag{
+								Name:  "abort",
+								Usage: "Cancel the synchronization, the local changes being kept",
+							},
+						},
+						Description: `
+Resolve the true conflicts of the manifest (e.g the same script changed on both
+sides) which have stopped the synchronization, by keeping the local or the remote values.
+
+The conflicts are named as listed by sync (e.g "manifest.json: scripts > test",
+or simply "scripts > test"), all the conflicts are resolved when none is given.
+Once all the conflicts are resolved, the synchronization is finished.
+
+Examples
+
+- Keep the local test script, and the remote values of the other conflicts:
+  $ srcode manifest resolve --local "scripts > test"
+  $ srcode manifest resolve --remote
+
+- Cancel the synchronization:
+  $ srcode manifest resolve --abort`,
+					},
+				},
+			},
+			{
+				Name:  "snapshot",
+				Usage: "Manage the codebase snapshots",
+				Description: `
+Manage the snapshots of the codebase. A snapshot records the HEAD commit
+(and branch) of every project, allowing to recreate the exact state of the codebase.
+
+The snapshots are stored in the codebase repository and shared by the machines.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "save",
+						Usage:     "Save the state of the projects",
+						Action:    app.saveSnapshot,
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Overwrite the snapshot if it already exist",
+							},
+						},
+						Description: `
+Record the HEAD commit and branch of every project.
+
+Examples
+
+- Record the state of the services deployed in production:
+  $ srcode snapshot save release-2021-03-01`,
+					},
+					{
+						Name:   "ls",
+						Usage:  "Display the snapshots",
+						Action: app.lsSnapshots,
+					},
+					{
+						Name:      "restore",
+						Usage:     "Checkout the projects at the snapshot commits",
+						Action:    app.restoreSnapshot,
+						ArgsUsage: "<name>",
+						Description: `
+Checkout every project at the commit recorded in the snapshot (HEAD is detached),
+cloning the missing projects. Nothing is done if a project has uncommitted changes.
+
+Use srcode checkout-default to switch the projects back to their tracked branch.`,
+					},
+					{
+						Name:      "diff",
+						Usage:     "Display the commits changed between two snapshots",
+						Action:    app.diffSnapshots,
+						ArgsUsage: "<from> <to>",
+						Description: `
+Display, for every project, the commits added (+) and removed (-) between two snapshots.`,
+					},
+				},
+			},
+			{
+				Name:  "topic",
+				Usage: "Manage the topics (branches spanning multiple projects)",
+				Description: `
+Manage the topics. A topic is a branch with the same name created in several projects,
+used to work on a change touching multiple projects.
+
+The topics are local to the machine, and are not shared through the codebase repository.`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "start",
+						Usage:     "Create the topic branch in the projects",
+						Action:    app.startTopic,
+						ArgsUsage: "<name> [<projects...>]",
+						Description: `
+Create the topic branch (starting at HEAD) in the projects and switch to it.
+Default to the current project. Starting an existing topic adds the projects to it.
+
+Examples
+
+- Start working on a feature touching the api and web projects:
+  $ srcode topic start feature/sso Work/api Work/web`,
+					},
+					{
+						Name:      "status",
+						Usage:     "Display the topic projects status",
+						Action:    app.topicStatus,
+						ArgsUsage: "[<name>]",
+						Description: `
+Display, for every project of the topic (default to the current topic),
+the commits of the topic branch and whether it has uncommitted changes (*).`,
+					},
+					{
+						Name:      "switch",
+						Usage:     "Switch the projects to the topic branch",
+						Action:    app.switchTopic,
+						ArgsUsage: "<name>",
+						Description: `
+Switch the topic projects to the topic branch, and make it the current topic.
+The projects having uncommitted changes are left untouched.`,
+					},
+					{
+						Name:      "finish",
+						Usage:     "Switch the projects back to their branch",
+						Action:    app.finishTopic,
+						ArgsUsage: "[<name>]",
+						Description: `
+Switch the topic projects (default to the current topic) back to their tracked branch,
+or to the branch they were on when the topic was started. The topic branches are kept.
+
+The topic is removed once every project has been switched back.`,
+					},
+				},
+			},
+			{
+				Name:  "worktree",
+				Usage: "Manage the projects worktrees",
+				Description: `
+Manage the worktrees of the projects, allowing to work on multiple branches of a project at the same time.
+
+The worktrees are created next to their project (e.g /Work/api@feature-x), the layout can be changed
+using the worktreeLayout field of the manifest (e.g {project}.worktrees/{branch}).`,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "add",
+						Usage:     "Create a worktree of the project with the branch checked out",
+						Action:    app.addWorktree,
+						ArgsUsage: "<project> <branch>",
+						Description: `
+Create a worktree of the project with the branch checked out.
+
+Examples
+
+- Review a pull request without touching the working copy:
+  $ srcode worktree add Work/api feature/sso`,
+					},
+					{
+						Name:      "ls",
+						Usage:     "Display the worktrees of the projects",
+						Action:    app.lsWorktrees,
+						ArgsUsage: "[<project>]",
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove the worktree of the project",
+						Action:    app.rmWorktree,
+						ArgsUsage: "<project> <branch>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Remove the worktree even if it has uncommitted changes",
+							},
+						},
+					},
+				},
 			},
 		},
 		Authors: []*cli.Author{{
@@ -269,6 +1034,8 @@ Examples
 			Email: "alois@micard.lu",
 		}},
 		Action: app.runScript, // shortcut: use srcode <script> to execute a codebase script easily
+		// errors (and exit code) are handled by main
+		ExitErrHandler: func(c *cli.Context, err error) {},
 	}
 }
 
@@ -329,7 +1096,7 @@ func (app *app) cloneCodebase(c *cli.Context) error {
 	wg.Add(1)
 	go func() {
 		for entry := range ch {
-			_, _ = fmt.Fprintf(app.writer, "Cloned %s -> /%s\n", entry.Project.Remote, entry.Path)
+			_, _ = fmt.Fprintf(app.writer, "Cloned %s -> /%s\n", entry.Project.Location(), entry.Path)
 		}
 		wg.Done()
 	}()
@@ -347,9 +1114,9 @@ func (app *app) cloneCodebase(c *cli.Context) error {
 	return nil
 }
 
-func (app *app) addProject(c *cli.Context) error {
-	if c.NArg() < 1 {
-		return errWrongAddProjectUsage
+func (app *app) backupCodebase(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongBackupUsage
 	}
 
 	cb, err := app.openCodebase()
@@ -357,50 +1124,62 @@ func (app *app) addProject(c *cli.Context) error {
 		return err
 	}
 
-	path := ""
-	if arg := c.Args().Get(1); arg != "" {
-		path = arg
+	entries, err := cb.Backup(c.Args().First())
+	if err != nil {
+		return err
 	}
 
-	if _, err := cb.Add(c.Args().First(), path, parseGitConfig(c.StringSlice("git-config"))); err != nil {
-		return err
+	failed := 0
+	for _, entry := range entries {
+		switch {
+		case entry.Err != nil:
+			failed++
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s\n", color.HiRedString("x"), entry.Path, entry.Err)
+		case entry.Bundle == "":
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: up-to-date\n", color.HiGreenString("✓"), entry.Path)
+		default:
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s\n", color.HiGreenString("✓"), entry.Path, entry.Bundle)
+		}
 	}
 
-	// little display trick
-	path = "/" + path
+	if failed > 0 {
+		return fmt.Errorf("unable to backup %d project(s)", failed)
+	}
 
-	_, _ = fmt.Fprintf(app.writer, "Successfully added %s to: %s\n", c.Args().First(), path)
+	_, _ = fmt.Fprintf(app.writer, "Successfully backed up codebase to: %s\n", c.Args().First())
 
 	return nil
 }
 
-func (app *app) syncCodebase(c *cli.Context) error {
-	cb, err := app.openCodebase()
-	if err != nil {
-		return err
+func (app *app) restoreCodebase(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errWrongRestoreUsage
+	}
+
+	path := c.Args().Get(1)
+	if !filepath.IsAbs(path) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		path = filepath.Join(cwd, path)
 	}
 
 	wg := sync.WaitGroup{}
 
-	addedChan := make(chan codebase.ProjectEntry)
-	wg.Add(1)
-	go func() {
-		for entry := range addedChan {
-			_, _ = fmt.Fprintf(app.writer, "[+] %s -> %s\n", entry.Project.Remote, entry.Path)
-		}
-		wg.Done()
-	}()
+	// Use goroutine to have un-buffered channel
+	ch := make(chan codebase.ProjectEntry)
 
-	deletedChan := make(chan codebase.ProjectEntry)
 	wg.Add(1)
 	go func() {
-		for entry := range deletedChan {
-			_, _ = fmt.Fprintf(app.writer, "[-] %s -> %s\n", entry.Project.Remote, entry.Path)
+		for entry := range ch {
+			_, _ = fmt.Fprintf(app.writer, "Restored %s -> /%s\n", entry.Project.Location(), entry.Path)
 		}
 		wg.Done()
 	}()
 
-	err = cb.Sync(c.Bool("delete-removed"), addedChan, deletedChan)
+	_, err := app.codebaseProvider.Restore(c.Args().First(), path, ch)
 
 	wg.Wait()
 
@@ -408,23 +1187,125 @@ func (app *app) syncCodebase(c *cli.Context) error {
 		return err
 	}
 
-	_, _ = fmt.Fprintln(app.writer, "Successfully synchronized codebase")
+	_, _ = fmt.Fprintf(app.writer, "Successfully restored codebase from %s to: %s\n", c.Args().First(), path)
 
 	return nil
 }
 
-func (app *app) pwd(c *cli.Context) error {
+func (app *app) addProject(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errWrongAddProjectUsage
+	}
+
 	cb, err := app.openCodebase()
 	if err != nil {
 		return err
 	}
 
-	_, _ = fmt.Fprintf(app.writer, "/%s\n", cb.LocalPath())
+	path := ""
+	if arg := c.Args().Get(1); arg != "" {
+		path = arg
+	}
 
-	return nil
-}
+	if sourceType := c.String("source"); sourceType != "" && sourceType != manifest.SourceGit {
+		project := manifest.Project{
+			Source: &manifest.Source{
+				Type:     sourceType,
+				URL:      c.Args().First(),
+				Checksum: c.String("checksum"),
+			},
+		}
 
-func (app *app) runScript(c *cli.Context) error {
+		project, err := cb.Add(path, project)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(app.writer, "Successfully added %s (%s) to: /%s\n", c.Args().First(), project.SourceType(), path)
+
+		return nil
+	}
+
+	project := manifest.Project{
+		Remote: c.Args().First(),
+		Branch: c.String("branch"),
+		Config: parseGitConfig(c.StringSlice("git-config")),
+		Clone: &manifest.CloneOptions{
+			Depth:        c.Int("depth"),
+			Filter:       c.String("filter"),
+			SingleBranch: c.Bool("single-branch"),
+			Submodules:   c.Bool("recurse-submodules"),
+			SkipLFS:      c.Bool("skip-lfs"),
+		},
+	}
+
+	if upstream := c.String("upstream"); upstream != "" {
+		project.Remotes = map[string]string{"upstream": upstream}
+	}
+
+	if _, err := cb.Add(path, project); err != nil {
+		return err
+	}
+
+	// little display trick
+	path = "/" + path
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully added %s to: %s\n", c.Args().First(), path)
+
+	return nil
+}
+
+func (app *app) syncCodebase(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+
+	addedChan := make(chan codebase.ProjectEntry)
+	wg.Add(1)
+	go func() {
+		for entry := range addedChan {
+			_, _ = fmt.Fprintf(app.writer, "[+] %s -> %s\n", entry.Project.Location(), entry.Path)
+		}
+		wg.Done()
+	}()
+
+	deletedChan := make(chan codebase.ProjectEntry)
+	wg.Add(1)
+	go func() {
+		for entry := range deletedChan {
+			_, _ = fmt.Fprintf(app.writer, "[-] %s -> %s\n", entry.Project.Location(), entry.Path)
+		}
+		wg.Done()
+	}()
+
+	err = cb.Sync(c.Bool("delete-removed"), addedChan, deletedChan)
+
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(app.writer, "Successfully synchronized codebase")
+
+	return nil
+}
+
+func (app *app) pwd(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "/%s\n", cb.LocalPath())
+
+	return nil
+}
+
+func (app *app) runScript(c *cli.Context) error {
 	if !c.Args().Present() {
 		return errWrongRunUsage
 	}
@@ -434,7 +1315,49 @@ func (app *app) runScript(c *cli.Context) error {
 		return err
 	}
 
-	return cb.Run(c.Args().First(), c.Args().Tail(), app.writer)
+	if c.Bool("watch") {
+		return app.watchScript(c, cb)
+	}
+
+	return cb.Run(c.Args().First(), c.Args().Tail(), app.reader, app.writer)
+}
+
+func (app *app) watchScript(c *cli.Context, cb codebase.Codebase) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// stop watching on interrupt
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	scriptName := c.Args().First()
+	passStyle := color.New(color.FgHiGreen, color.Bold)
+	failStyle := color.New(color.FgHiRed, color.Bold)
+
+	_, _ = fmt.Fprintf(app.writer, "Watching for changes, press Ctrl+C to stop\n")
+
+	return cb.Watch(ctx, scriptName, c.Args().Tail(), c.StringSlice("project"), app.writer, func(res codebase.WatchResult) {
+		status := passStyle.Sprint("PASS")
+		if res.Err != nil {
+			status = failStyle.Sprint("FAIL")
+		}
+
+		_, _ = fmt.Fprintf(app.writer, "%s %s in /%s (%s) - %s\n",
+			status,
+			scriptName,
+			res.Project,
+			res.Duration.Round(time.Millisecond),
+			time.Now().Format("15:04:05"))
+	})
 }
 
 func (app *app) lsProjects(c *cli.Context) error {
@@ -461,15 +1384,27 @@ func (app *app) lsProjects(c *cli.Context) error {
 	}
 	sort.Strings(keys)
 
+	worktrees, err := cb.Worktrees()
+	if err != nil {
+		return err
+	}
+
 	table := tablewriter.NewWriter(app.writer)
-	table.SetHeader([]string{"Remote", "Path", "Branch"})
-	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER})
+	table.SetHeader([]string{"Remote", "Path", "Source", "Branch"})
+	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER})
 	table.SetBorder(false)
 
 	dirtStyle := color.New(color.Italic, color.FgHiYellow)
+	offBranchStyle := color.New(color.Bold, color.FgHiRed)
 	for _, path := range keys {
 		project := projects[path]
 
+		// only the git repositories have a branch
+		if project.Repository == nil {
+			table.Append([]string{project.Project.Location(), "/" + path, project.Project.SourceType(), ""})
+			continue
+		}
+
 		branch, err := project.Repository.Head()
 		if err != nil {
 			return err
@@ -480,14 +1415,27 @@ func (app *app) lsProjects(c *cli.Context) error {
 			return err
 		}
 
-		values := []string{project.Project.Remote, "/" + path}
+		tracked := project.Project.Branch
+		offBranch := tracked != "" && branch != tracked
+
 		if dirty {
-			values = append(values, dirtStyle.Sprint(branch+"(*)"))
+			branch += "(*)"
+		}
+
+		values := []string{project.Project.Remote, "/" + path, project.Project.SourceType()}
+		if offBranch {
+			values = append(values, offBranchStyle.Sprintf("%s (expected %s)", branch, tracked))
+		} else if dirty {
+			values = append(values, dirtStyle.Sprint(branch))
 		} else {
 			values = append(values, branch)
 		}
 
 		table.Append(values)
+
+		for _, worktree := range worktrees[path] {
+			table.Append([]string{"", formatWorktreePath(worktree), "", formatWorktreeBranch(worktree) + " (worktree)"})
+		}
 	}
 
 	table.Render()
@@ -599,6 +1547,106 @@ func (app *app) script(c *cli.Context) error {
 	return cb.SetScript(c.Args().First(), script, isGlobal)
 }
 
+func (app *app) showScript(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongScriptShowUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	man, err := cb.Manifest()
+	if err != nil {
+		return err
+	}
+
+	var script []string
+
+	if c.Bool("global") {
+		val, exist := man.Scripts[c.Args().First()]
+		if !exist {
+			return manifest.ErrScriptNotFound
+		}
+
+		script = val
+	} else {
+		val, err := man.GetScript(cb.LocalPath(), c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		script = val
+	}
+
+	_, _ = fmt.Fprintln(app.writer, strings.Join(script, "\n"))
+
+	return nil
+}
+
+func (app *app) rmScript(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongScriptRmUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if err := cb.RmScript(c.Args().First(), c.Bool("global")); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully removed script `%s`\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) mvScript(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errWrongScriptMvUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if err := cb.MoveScript(c.Args().First(), c.Args().Get(1), c.Bool("global")); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully renamed script `%s` to `%s`\n", c.Args().First(), c.Args().Get(1))
+
+	return nil
+}
+
+func (app *app) promoteScript(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return errWrongScriptPromoteUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	globalName := c.Args().Get(1)
+	if globalName == "" {
+		globalName = c.Args().First()
+	}
+
+	if err := cb.PromoteScript(c.Args().First(), globalName); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully promoted script `%s` to global script `%s`\n", c.Args().First(), globalName)
+
+	return nil
+}
+
 func (app *app) mvProject(c *cli.Context) error {
 	if c.NArg() < 2 {
 		return errWrongMvUsage
@@ -638,102 +1686,1081 @@ func (app *app) rmProject(c *cli.Context) error {
 }
 
 func (app *app) hook(c *cli.Context) error {
-	if c.NArg() != 1 {
+	if c.NArg() < 1 {
 		return errWrongHookUsage
 	}
 
+	hookType, scripts := c.Args().First(), c.Args().Tail()
+
+	// srcode hook <script> is kept for backward compatibility
+	if c.NArg() == 1 {
+		hookType, scripts = "pre-push", c.Args().Slice()
+	}
+
 	cb, err := app.openCodebase()
 	if err != nil {
 		return err
 	}
 
-	if err := cb.SetHook(c.Args().First()); err != nil {
+	if err := cb.SetHook(hookType, scripts); err != nil {
 		return err
 	}
 
-	_, _ = fmt.Fprintf(app.writer, "Successfully applied hook `%s` to /%s\n", c.Args().First(), cb.LocalPath())
+	_, _ = fmt.Fprintf(app.writer, "Successfully applied %s hook `%s` to /%s\n", hookType, strings.Join(scripts, "`, `"), cb.LocalPath())
 
 	return nil
 }
 
-func (app *app) openCodebase() (codebase.Codebase, error) {
-	cwd, err := os.Getwd()
+func (app *app) lsHooks(c *cli.Context) error {
+	cb, err := app.openCodebase()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	cb, err := app.codebaseProvider.Open(cwd)
+	all := c.Bool("all")
+
+	hooks, err := cb.Hooks(all)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return cb, nil
-}
-
-func parseGitConfig(args []string) map[string]string {
-	config := map[string]string{}
+	if len(hooks) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No hooks for current project")
+		_, _ = fmt.Fprintln(app.writer, "Tips: set a hook using `srcode hook pre-push lint`")
+		return nil
+	}
 
-	for _, arg := range args {
-		parts := strings.Split(arg, "=")
-		if len(parts) == 2 {
-			config[parts[0]] = parts[1]
-		}
+	header := []string{"Type", "Scripts", "Source", "Status"}
+	if all {
+		header = append([]string{"Path"}, header...)
 	}
 
-	return config
-}
+	table := tablewriter.NewWriter(app.writer)
+	table.SetHeader(header)
+	table.SetBorder(false)
 
-func captureInputFromEditor(initialContent []string) ([]string, error) {
-	file, err := ioutil.TempFile(os.TempDir(), "*")
-	if err != nil {
-		return nil, err
-	}
-	path := file.Name()
+	missingStyle := color.New(color.Italic, color.FgHiYellow)
+	for _, hook := range hooks {
+		status := "installed"
+		if !hook.Installed {
+			status = missingStyle.Sprint("not installed")
+		} else if hook.Chained {
+			status = "installed (chained)"
+		}
 
-	defer os.Remove(path)
+		source := "project"
+		if hook.Default {
+			source = "default"
+		}
 
-	// write initial content if any
-	if len(initialContent) > 0 {
-		if _, err := io.WriteString(file, strings.Join(initialContent, "\n")); err != nil {
-			return nil, err
+		values := []string{hook.Type, strings.Join(hook.Scripts, ", "), source, status}
+		if all {
+			values = append([]string{"/" + hook.Project}, values...)
 		}
-	}
 
-	if err := file.Close(); err != nil {
-		return nil, err
+		table.Append(values)
 	}
 
-	// lookup default editor
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = "vim" // ;D
-	}
+	table.Render()
 
-	// open the editor on the temporary file
-	cmd := exec.Command(editor, path)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return nil, err
+func (app *app) rmHook(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongHookRmUsage
 	}
 
-	b, err := ioutil.ReadFile(path)
+	cb, err := app.openCodebase()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), nil
+	if err := cb.RmHook(c.Args().First()); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully removed %s hook from /%s\n", c.Args().First(), cb.LocalPath())
+
+	return nil
 }
 
-func getKeys(v map[string][]string) []string {
-	keys := make([]string, 0, len(v))
-	for k := range v {
-		keys = append(keys, k)
+func (app *app) execHook(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errWrongHookExecUsage
 	}
 
-	sort.Strings(keys)
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	return cb.ExecHook(c.Args().First(), c.Args().Tail(), app.reader, app.writer)
+}
+
+func (app *app) env(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	env, err := cb.Env(c.Bool("secrets"))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range getStringKeys(env) {
+		if c.Bool("export") {
+			_, _ = fmt.Fprintf(app.writer, "export %s='%s'\n", key, strings.ReplaceAll(env[key], "'", `'\''`))
+		} else {
+			_, _ = fmt.Fprintf(app.writer, "%s=%s\n", key, env[key])
+		}
+	}
+
+	return nil
+}
+
+func (app *app) setSecret(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return errWrongSecretSetUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	value := c.Args().Get(1)
+	if c.NArg() == 1 {
+		// read the secret from stdin to prevent leaking it in the shell history
+		b, err := ioutil.ReadAll(app.reader)
+		if err != nil {
+			return err
+		}
+
+		value = strings.TrimSuffix(string(b), "\n")
+	}
+
+	if err := cb.SetSecret(c.Args().First(), value, c.Bool("global")); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully set secret `%s`\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) getSecret(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongSecretGetUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	value, err := cb.Secret(c.Args().First(), c.Bool("global"))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(app.writer, value)
+
+	return nil
+}
+
+func (app *app) rmSecret(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongSecretRmUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if err := cb.RmSecret(c.Args().First(), c.Bool("global")); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully removed secret `%s`\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) lsSecrets(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	man, err := cb.Manifest()
+	if err != nil {
+		return err
+	}
+
+	project, exist := man.Projects[cb.LocalPath()]
+
+	if len(man.Secrets) == 0 && len(project.Secrets) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No secrets in codebase")
+		return nil
+	}
+
+	if len(man.Secrets) > 0 {
+		_, _ = fmt.Fprintf(app.writer, "available global secrets:\t%s %s %s\n",
+			color.HiWhiteString("["),
+			strings.Join(getStringKeys(man.Secrets), ", "),
+			color.HiWhiteString("]"))
+	}
+
+	if exist && len(project.Secrets) > 0 {
+		_, _ = fmt.Fprintf(app.writer, "available local secrets:\t%s %s %s\n",
+			color.HiWhiteString("["),
+			strings.Join(getStringKeys(project.Secrets), ", "),
+			color.HiWhiteString("]"))
+	}
+
+	return nil
+}
+
+func (app *app) logs(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errWrongLogsUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if c.String("id") != "" {
+		return app.printRunOutput(cb, c.String("id"))
+	}
+
+	runLogs, err := cb.RunLogs(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("failed") {
+		for _, runLog := range runLogs {
+			if runLog.Failed() {
+				return app.printRunOutput(cb, runLog.ID)
+			}
+		}
+
+		_, _ = fmt.Fprintln(app.writer, "No failed executions")
+		return nil
+	}
+
+	if len(runLogs) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No executions recorded")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(app.writer)
+	table.SetHeader([]string{"ID", "Project", "Script", "Started", "Duration", "Exit code"})
+	table.SetBorder(false)
+
+	failedStyle := color.New(color.FgHiRed)
+	for _, runLog := range runLogs {
+		exitCode := strconv.Itoa(runLog.ExitCode)
+		if runLog.Failed() {
+			exitCode = failedStyle.Sprint(exitCode)
+		}
+
+		table.Append([]string{
+			runLog.ID,
+			"/" + runLog.Project,
+			strings.TrimSpace(strings.Join(append([]string{runLog.Script}, runLog.Args...), " ")),
+			runLog.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			runLog.Duration.Round(time.Millisecond).String(),
+			exitCode,
+		})
+	}
+
+	table.Render()
+
+	return nil
+}
+
+func (app *app) printRunOutput(cb codebase.Codebase, id string) error {
+	output, err := cb.RunOutput(id)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(app.writer, output)
+
+	return nil
+}
+
+func (app *app) updateCache(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.UpdateCache()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		if entry.Err != nil {
+			failed++
+			_, _ = fmt.Fprintf(app.writer, "[%s] %s: %s\n", color.HiRedString("x"), entry.Remote, entry.Err)
+		} else {
+			_, _ = fmt.Fprintf(app.writer, "[%s] %s\n", color.HiGreenString("✓"), entry.Remote)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to update %d mirror(s)", failed)
+	}
+
+	return nil
+}
+
+func (app *app) gcCache(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	removed, err := cb.GCCache()
+	if err != nil {
+		return err
+	}
+
+	for _, remote := range removed {
+		_, _ = fmt.Fprintf(app.writer, "[-] %s\n", remote)
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully removed %d mirror(s)\n", len(removed))
+
+	return nil
+}
+
+func (app *app) checkoutDefault(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.CheckoutDefault()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "All projects are on their tracked branch")
+		return nil
+	}
+
+	return app.printCheckoutEntries(entries)
+}
+
+func (app *app) startTopic(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errWrongTopicStartUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.StartTopic(c.Args().First(), c.Args().Tail())
+	if err != nil {
+		return err
+	}
+
+	if err := app.printCheckoutEntries(entries); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully started topic %s\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) topicStatus(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errWrongTopicStatusUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	topic, statuses, err := cb.TopicStatus(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Topic %s (started %s)\n\n", topic.Name, topic.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+
+	pathStyle := color.New(color.Bold, color.FgHiWhite)
+	dirtStyle := color.New(color.Italic, color.FgHiYellow)
+	offBranchStyle := color.New(color.Bold, color.FgHiRed)
+	for _, status := range statuses {
+		path := pathStyle.Sprint("/" + status.Path)
+		if status.Dirty {
+			path += dirtStyle.Sprint("(*)")
+		}
+
+		switch {
+		case status.Err != nil:
+			_, _ = fmt.Fprintf(app.writer, "%s: %s\n", path, status.Err)
+			continue
+		case status.Head != topic.Name:
+			_, _ = fmt.Fprintf(app.writer, "%s: %s, %d commit(s) ahead of %s\n", path,
+				offBranchStyle.Sprintf("on %s", status.Head), len(status.Commits), status.Base)
+		default:
+			_, _ = fmt.Fprintf(app.writer, "%s: %d commit(s) ahead of %s\n", path, len(status.Commits), status.Base)
+		}
+
+		for _, commit := range status.Commits {
+			_, _ = fmt.Fprintf(app.writer, "  %s %s\n", shortHash(commit.Hash), commit.Subject)
+		}
+	}
+
+	return nil
+}
+
+func (app *app) switchTopic(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongTopicSwitchUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.SwitchTopic(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if err := app.printCheckoutEntries(entries); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully switched to topic %s\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) finishTopic(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errWrongTopicFinishUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.FinishTopic(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if err := app.printCheckoutEntries(entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Err != nil {
+			return fmt.Errorf("the topic is kept until every project is switched back")
+		}
+	}
+
+	_, _ = fmt.Fprintln(app.writer, "Successfully finished topic")
+
+	return nil
+}
+
+func (app *app) addWorktree(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errWrongWorktreeAddUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	worktree, err := cb.AddWorktree(c.Args().First(), c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully added worktree /%s (%s)\n", worktree.Path, worktree.Branch)
+
+	return nil
+}
+
+func (app *app) lsWorktrees(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errWrongWorktreeLsUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := cb.Worktrees()
+	if err != nil {
+		return err
+	}
+
+	if c.NArg() == 1 {
+		path := filepath.Join(cb.LocalPath(), c.Args().First())
+		worktrees = map[string][]codebase.Worktree{path: worktrees[path]}
+	}
+
+	var paths []string
+	for path, projectWorktrees := range worktrees {
+		if len(projectWorktrees) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	if len(paths) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No worktrees")
+		_, _ = fmt.Fprintln(app.writer, "Tips: add a worktree using `srcode worktree add Work/api feature/sso`")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(app.writer)
+	table.SetHeader([]string{"Project", "Path", "Branch"})
+	table.SetBorder(false)
+
+	for _, path := range paths {
+		for _, worktree := range worktrees[path] {
+			table.Append([]string{"/" + path, formatWorktreePath(worktree), formatWorktreeBranch(worktree)})
+		}
+	}
+
+	table.Render()
+
+	return nil
+}
+
+func (app *app) rmWorktree(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errWrongWorktreeRmUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	path, branch := c.Args().First(), c.Args().Get(1)
+	if err := cb.RemoveWorktree(path, branch, c.Bool("force")); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully removed worktree %s of %s\n", branch, path)
+
+	return nil
+}
+
+// printCheckoutEntries display the result of the checkouts, and returns an error if some have failed
+// the projects skipped because of their uncommitted changes are not considered as failed
+func (app *app) printCheckoutEntries(entries []codebase.CheckoutEntry) error {
+	failed := 0
+	for _, entry := range entries {
+		switch {
+		case errors.Is(entry.Err, codebase.ErrProjectDirty):
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: skipped, %s\n", color.HiYellowString("!"), entry.Path, entry.Err)
+		case entry.Err != nil:
+			failed++
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s\n", color.HiRedString("x"), entry.Path, entry.Err)
+		default:
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s -> %s\n", color.HiGreenString("✓"), entry.Path, entry.Head, entry.Branch)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to checkout %d project(s)", failed)
+	}
+
+	return nil
+}
+
+func (app *app) setRemote(c *cli.Context) error {
+	if c.NArg() != 3 {
+		return errWrongRemoteSetUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	path, name, url := c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+	if err := cb.SetRemote(path, name, url); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully set %s remote of %s to %s\n", name, path, url)
+
+	return nil
+}
+
+func (app *app) rewriteRemotes(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errWrongRemoteRewriteUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	rewrites, err := cb.RewriteRemotes(c.Args().First(), c.Args().Get(1), c.Bool("dry-run"))
+	if err != nil {
+		return err
+	}
+
+	if len(rewrites) == 0 {
+		_, _ = fmt.Fprintf(app.writer, "No remote matching %s\n", c.Args().First())
+		return nil
+	}
+
+	for _, rewrite := range rewrites {
+		_, _ = fmt.Fprintf(app.writer, "/%s (%s): %s -> %s\n", rewrite.Path, rewrite.Name, rewrite.Old, rewrite.New)
+	}
+
+	if c.Bool("dry-run") {
+		_, _ = fmt.Fprintf(app.writer, "%d remote(s) would be rewritten (run without --dry-run to apply)\n", len(rewrites))
+	} else {
+		_, _ = fmt.Fprintf(app.writer, "Successfully rewritten %d remote(s)\n", len(rewrites))
+	}
+
+	return nil
+}
+
+func (app *app) convertManifest(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	name, err := cb.ConvertManifest(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully converted manifest to %s\n", filepath.Join(".srcode", name))
+
+	return nil
+}
+
+func (app *app) splitManifest(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if err := cb.SplitManifest(); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully split manifest into .srcode/projects and .srcode/scripts\n")
+
+	return nil
+}
+
+func (app *app) mergeManifest(c *cli.Context) error {
+	if c.NArg() != 4 {
+		return errWrongManifestMergeUsage
+	}
+
+	var versions [][]byte
+	for _, file := range c.Args().Slice()[:3] {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, b)
+	}
+
+	path := c.Args().Get(3)
+
+	b, conflicts, err := manifest.MergeFile(path, versions[0], versions[1], versions[2])
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.Args().Get(1), b, 0640); err != nil {
+		return err
+	}
+
+	if report := c.String("report"); report != "" && len(conflicts) > 0 {
+		if err := manifest.ReportConflicts(report, conflicts); err != nil {
+			return err
+		}
+	}
+
+	if len(conflicts) > 0 {
+		var sb strings.Builder
+		for _, conflict := range conflicts {
+			_, _ = fmt.Fprintf(&sb, "\n%s", conflict)
+		}
+
+		return fmt.Errorf("%w in %s (the ours values have been kept):%s", errManifestConflict, path, sb.String())
+	}
+
+	return nil
+}
+
+func (app *app) resolveManifest(c *cli.Context) error {
+	// exactly one of the flags is expected
+	flags := 0
+	for _, flag := range []string{"local", "remote", "abort"} {
+		if c.Bool(flag) {
+			flags++
+		}
+	}
+	if flags != 1 || (c.Bool("abort") && c.Args().Present()) {
+		return errWrongManifestResolveUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("abort") {
+		if err := cb.AbortSync(); err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintln(app.writer, "Successfully cancelled synchronization, the local changes have been kept")
+
+		return nil
+	}
+
+	remaining, err := cb.ResolveManifest(c.Bool("local"), c.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	if len(remaining) > 0 {
+		_, _ = fmt.Fprintf(app.writer, "%d conflict(s) remaining:\n", len(remaining))
+		for _, conflict := range remaining {
+			_, _ = fmt.Fprintf(app.writer, "%s: %s\n", conflict.File, conflict.Format("remote", "local"))
+		}
+
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(app.writer, "Successfully resolved manifest conflicts")
+
+	// finish the synchronization
+	return app.syncCodebase(c)
+}
+
+func (app *app) saveSnapshot(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongSnapshotSaveUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := cb.SaveSnapshot(c.Args().First(), c.Bool("force"))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully saved snapshot %s (%d projects)\n", snapshot.Name, len(snapshot.Projects))
+
+	return nil
+}
+
+func (app *app) lsSnapshots(c *cli.Context) error {
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := cb.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No snapshots saved")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(app.writer)
+	table.SetHeader([]string{"Name", "Created", "Projects"})
+	table.SetBorder(false)
+
+	for _, snapshot := range snapshots {
+		table.Append([]string{
+			snapshot.Name,
+			snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			strconv.Itoa(len(snapshot.Projects)),
+		})
+	}
+
+	table.Render()
+
+	return nil
+}
+
+func (app *app) restoreSnapshot(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errWrongSnapshotRestoreUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cb.RestoreSnapshot(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		if entry.Err != nil {
+			failed++
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s\n", color.HiRedString("x"), entry.Path, entry.Err)
+		} else {
+			_, _ = fmt.Fprintf(app.writer, "[%s] /%s: %s\n", color.HiGreenString("✓"), entry.Path, shortHash(entry.Commit))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to restore %d project(s)", failed)
+	}
+
+	_, _ = fmt.Fprintf(app.writer, "Successfully restored snapshot %s\n", c.Args().First())
+
+	return nil
+}
+
+func (app *app) diffSnapshots(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errWrongSnapshotDiffUsage
+	}
+
+	cb, err := app.openCodebase()
+	if err != nil {
+		return err
+	}
+
+	diffs, err := cb.DiffSnapshots(c.Args().First(), c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	if len(diffs) == 0 {
+		_, _ = fmt.Fprintln(app.writer, "No differences")
+		return nil
+	}
+
+	pathStyle := color.New(color.Bold, color.FgHiWhite)
+	for _, diff := range diffs {
+		switch {
+		case diff.From == "":
+			_, _ = fmt.Fprintf(app.writer, "/%s: added (%s)\n", pathStyle.Sprint(diff.Path), shortHash(diff.To))
+		case diff.To == "":
+			_, _ = fmt.Fprintf(app.writer, "/%s: removed (%s)\n", pathStyle.Sprint(diff.Path), shortHash(diff.From))
+		case diff.Err != nil:
+			_, _ = fmt.Fprintf(app.writer, "/%s: %s..%s (%s)\n", pathStyle.Sprint(diff.Path), shortHash(diff.From), shortHash(diff.To), diff.Err)
+		default:
+			_, _ = fmt.Fprintf(app.writer, "/%s: %s..%s\n", pathStyle.Sprint(diff.Path), shortHash(diff.From), shortHash(diff.To))
+			for _, commit := range diff.Added {
+				_, _ = fmt.Fprintf(app.writer, "  %s %s %s\n", color.HiGreenString("+"), shortHash(commit.Hash), commit.Subject)
+			}
+			for _, commit := range diff.Removed {
+				_, _ = fmt.Fprintf(app.writer, "  %s %s %s\n", color.HiRedString("-"), shortHash(commit.Hash), commit.Subject)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (app *app) openCodebase() (codebase.Codebase, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	cb, err := app.codebaseProvider.Open(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return cb, nil
+}
+
+func parseGitConfig(args []string) map[string]string {
+	config := map[string]string{}
+
+	for _, arg := range args {
+		parts := strings.Split(arg, "=")
+		if len(parts) == 2 {
+			config[parts[0]] = parts[1]
+		}
+	}
+
+	return config
+}
+
+func captureInputFromEditor(initialContent []string) ([]string, error) {
+	file, err := ioutil.TempFile(os.TempDir(), "*")
+	if err != nil {
+		return nil, err
+	}
+	path := file.Name()
+
+	defer os.Remove(path)
+
+	// write initial content if any
+	if len(initialContent) > 0 {
+		if _, err := io.WriteString(file, strings.Join(initialContent, "\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	// lookup default editor
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vim" // ;D
+	}
+
+	// open the editor on the temporary file
+	cmd := exec.Command(editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), nil
+}
+
+func getKeys(v map[string][]string) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// getExitCode returns the exit code to use for given error
+func getExitCode(err error) int {
+	// use same convention as timeout(1)
+	if errors.Is(err, codebase.ErrScriptTimeout) {
+		return 124
+	}
+
+	// the exit code of git is an implementation detail
+	var gitErr *repository.GitError
+	if errors.As(err, &gitErr) {
+		return 1
+	}
+
+	if code := cmd.GetExitCode(err); code > 0 {
+		return code
+	}
+
+	return 1
+}
+
+// getErrorHint returns an hint helping the user to fix the git error (if any)
+func getErrorHint(err error) string {
+	switch {
+	case errors.Is(err, repository.ErrNotARepository):
+		return "the directory is not a git repository, use `srcode sync` to clone the missing projects"
+	case errors.Is(err, repository.ErrAuthentication):
+		return "check your credentials (SSH key loaded in the agent, HTTPS token) and your access to the remote"
+	case errors.Is(err, repository.ErrNetwork):
+		return "check your network connection and the remote URL"
+	case errors.Is(err, codebase.ErrManifestConflict):
+		return "keep the local or the remote values using `srcode manifest resolve --local|--remote [<conflicts...>]`, " +
+			"or cancel the synchronization using `srcode manifest resolve --abort`"
+	case errors.Is(err, repository.ErrConflict):
+		return "the local and remote histories have diverged, resolve the conflicts using git then retry"
+	case errors.Is(err, repository.ErrRemoteNotFound):
+		return "check the remote name and URL using `git remote -v`"
+	case errors.Is(err, repository.ErrNoUpstream):
+		return "the branch does not exist on the remote, push it first using `git push -u origin <branch>`"
+	}
+
+	return ""
+}
+
+func getStringKeys(v map[string]string) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
 
 	return keys
 }
+
+// shortHash returns the abbreviated form of given commit hash
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+
+	return hash
+}
+
+// formatWorktreePath returns the path of the worktree, prefixed by / if relative to the codebase
+func formatWorktreePath(worktree codebase.Worktree) string {
+	if filepath.IsAbs(worktree.Path) {
+		return worktree.Path
+	}
+
+	return "/" + worktree.Path
+}
+
+// formatWorktreeBranch returns the branch of the worktree, or its commit if HEAD is detached
+func formatWorktreeBranch(worktree codebase.Worktree) string {
+	if worktree.Branch == "" {
+		return shortHash(worktree.Commit)
+	}
+
+	return worktree.Branch
+}
@@ -6,13 +6,17 @@ import (
 	"github.com/creekorful/srcode/internal/codebase"
 	"github.com/creekorful/srcode/internal/codebase_mock"
 	"github.com/creekorful/srcode/internal/manifest"
+	"github.com/creekorful/srcode/internal/repository"
 	"github.com/creekorful/srcode/internal/repository_mock"
 	"github.com/creekorful/srcode/internal/str"
 	"github.com/golang/mock/gomock"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -152,6 +156,108 @@ func TestCloneCodebase(t *testing.T) {
 	}
 }
 
+func TestBackup(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	if err := app.getCliApp().Run([]string{"srcode", "backup"}); !errors.Is(err, errWrongBackupUsage) {
+		t.Errorf("got %v want %v", err, errWrongBackupUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Backup("/media/usb/backup").Return([]codebase.BackupEntry{
+		{Path: "Work/api", Bundle: "projects/Work/api/000002.bundle"},
+		{Path: "Work/web"},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "backup", "/media/usb/backup"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "/Work/api: projects/Work/api/000002.bundle") || !strings.Contains(val, "/Work/web: up-to-date") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "Successfully backed up codebase to: /media/usb/backup") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	b.Reset()
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Backup("/media/usb/backup").Return([]codebase.BackupEntry{
+		{Path: "Work/api", Err: errors.New("not a git repository")},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "backup", "/media/usb/backup"}); err == nil {
+		t.Error("a failing backup should return an error")
+	}
+	if !strings.Contains(b.String(), "/Work/api: not a git repository") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestRestore(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	if err := app.getCliApp().Run([]string{"srcode", "restore"}); !errors.Is(err, errWrongRestoreUsage) {
+		t.Errorf("got %v want %v", err, errWrongRestoreUsage)
+	}
+
+	codebaseProviderMock.EXPECT().
+		Restore("/media/usb/backup", filepath.Join(cwd, "code"), gomock.Any()).
+		Do(func(dir, path string, ch chan<- codebase.ProjectEntry) {
+			ch <- codebase.ProjectEntry{
+				Path:    "Work/api",
+				Project: manifest.Project{Remote: "git@github.com:me/api.git"},
+			}
+			close(ch)
+		})
+
+	if err := app.getCliApp().Run([]string{"srcode", "restore", "/media/usb/backup", "code"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "Restored git@github.com:me/api.git -> /Work/api") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, fmt.Sprintf("Successfully restored codebase from /media/usb/backup to: %s", filepath.Join(cwd, "code"))) {
+		t.Errorf("wrong output: %s", val)
+	}
+}
+
 func TestAddProject(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -180,7 +286,11 @@ func TestAddProject(t *testing.T) {
 	// test empty path
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
 	codebaseMock.EXPECT().
-		Add("https://example.com/test.git", "", map[string]string{}).
+		Add("", manifest.Project{
+			Remote: "https://example.com/test.git",
+			Config: map[string]string{},
+			Clone:  &manifest.CloneOptions{},
+		}).
 		Return(manifest.Project{}, nil)
 
 	if err := app.getCliApp().Run([]string{"srcode", "add", "https://example.com/test.git"}); err != nil {
@@ -194,7 +304,11 @@ func TestAddProject(t *testing.T) {
 	b.Reset()
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
 	codebaseMock.EXPECT().
-		Add("https://example.com/test.git", "Contributing/test", map[string]string{}).
+		Add("Contributing/test", manifest.Project{
+			Remote: "https://example.com/test.git",
+			Config: map[string]string{},
+			Clone:  &manifest.CloneOptions{},
+		}).
 		Return(manifest.Project{}, nil)
 
 	if err := app.getCliApp().Run([]string{"srcode", "add", "https://example.com/test.git", "Contributing/test"}); err != nil {
@@ -208,8 +322,11 @@ func TestAddProject(t *testing.T) {
 	b.Reset()
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
 	codebaseMock.EXPECT().
-		Add("https://example.com/test.git", "Contributing/test",
-			map[string]string{"user.name": "Aloïs Micard", "user.email": "alois@micard.lu"}).
+		Add("Contributing/test", manifest.Project{
+			Remote: "https://example.com/test.git",
+			Config: map[string]string{"user.name": "Aloïs Micard", "user.email": "alois@micard.lu"},
+			Clone:  &manifest.CloneOptions{},
+		}).
 		Return(manifest.Project{}, nil)
 
 	if err := app.getCliApp().Run([]string{"srcode", "add",
@@ -221,6 +338,50 @@ func TestAddProject(t *testing.T) {
 	if b.String() != "Successfully added https://example.com/test.git to: /Contributing/test\n" {
 		t.Fail()
 	}
+
+	// test with clone options
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().
+		Add("Contributing/test", manifest.Project{
+			Remote:  "https://example.com/test.git",
+			Remotes: map[string]string{"upstream": "https://example.com/upstream/test.git"},
+			Branch:  "develop",
+			Config:  map[string]string{},
+			Clone: &manifest.CloneOptions{
+				Depth:        1,
+				Filter:       "blob:none",
+				SingleBranch: true,
+				Submodules:   true,
+				SkipLFS:      true,
+			},
+		}).
+		Return(manifest.Project{}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "add",
+		"--depth", "1", "--filter", "blob:none", "--branch", "develop",
+		"--upstream", "https://example.com/upstream/test.git",
+		"--single-branch", "--recurse-submodules", "--skip-lfs",
+		"https://example.com/test.git", "Contributing/test"}); err != nil {
+		t.Error(err)
+	}
+
+	// test with archive source
+	b.Reset()
+	archive := manifest.Project{
+		Source: &manifest.Source{Type: manifest.SourceArchive, URL: "https://example.com/sdk.tar.gz", Checksum: "abcdef"},
+	}
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Add("Vendor/sdk", archive).Return(archive, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "add",
+		"--source", "archive", "--checksum", "abcdef",
+		"https://example.com/sdk.tar.gz", "Vendor/sdk"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully added https://example.com/sdk.tar.gz (archive) to: /Vendor/sdk\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
 }
 
 func TestSyncCodebase(t *testing.T) {
@@ -348,8 +509,10 @@ func TestRunScript(t *testing.T) {
 	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
 
-	codebaseMock.EXPECT().Run("test", []string{"."}, b).
-		Do(func(command string, args []string, writer io.Writer) { _, _ = io.WriteString(writer, "test 42\n") }).
+	codebaseMock.EXPECT().Run("test", []string{"."}, nil, b).
+		Do(func(command string, args []string, reader io.Reader, writer io.Writer) {
+			_, _ = io.WriteString(writer, "test 42\n")
+		}).
 		Return(nil)
 
 	if err := app.getCliApp().Run([]string{"srcode", "run", "test", "."}); err != nil {
@@ -411,14 +574,20 @@ func TestLsProjects(t *testing.T) {
 	codebaseMock.EXPECT().Projects().
 		Return(map[string]codebase.ProjectEntry{
 			"Contributing/test": {
-				Project:    manifest.Project{Remote: "https://example/test.git"},
+				Project:    manifest.Project{Remote: "https://example/test.git", Branch: "main"},
 				Repository: repo1,
 			},
 			"Another/Stuff": {
 				Project:    manifest.Project{Remote: "https://old.example/stuff.git"},
 				Repository: repo2,
 			},
+			"Personal/notes": {
+				Project: manifest.Project{Source: &manifest.Source{Type: manifest.SourceSymlink, URL: "/home/user/notes"}},
+			},
 		}, nil)
+	codebaseMock.EXPECT().Worktrees().Return(map[string][]codebase.Worktree{
+		"Contributing/test": {{Path: "Contributing/test@review", Branch: "review"}},
+	}, nil)
 
 	if err := app.getCliApp().Run([]string{"srcode", "ls"}); err != nil {
 		t.Fail()
@@ -431,7 +600,7 @@ func TestLsProjects(t *testing.T) {
 	if !strings.Contains(val, "https://example/test.git") {
 		t.Fail()
 	}
-	if !strings.Contains(val, "develop") {
+	if !strings.Contains(val, "develop (expected main)") {
 		t.Fail()
 	}
 	if !strings.Contains(val, "Another/Stuff") {
@@ -446,6 +615,12 @@ func TestLsProjects(t *testing.T) {
 	if !strings.Contains(val, "(*)") {
 		t.Fail()
 	}
+	if !strings.Contains(val, "/Contributing/test@review") || !strings.Contains(val, "review (worktree)") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/home/user/notes") || !strings.Contains(val, "symlink") {
+		t.Errorf("wrong output: %s", val)
+	}
 }
 
 func TestBulkGit(t *testing.T) {
@@ -482,6 +657,62 @@ func TestBulkGit(t *testing.T) {
 	}
 }
 
+func TestCheckoutDefault(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// All projects on their branch
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().CheckoutDefault().Return(nil, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "checkout-default"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "All projects are on their tracked branch\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().CheckoutDefault().Return([]codebase.CheckoutEntry{
+		{Path: "a/dirty", Head: "feature", Branch: "main", Err: codebase.ErrProjectDirty},
+		{Path: "a/failing", Head: "feature", Branch: "missing", Err: errors.New("pathspec 'missing' did not match")},
+		{Path: "a/test", Head: "feature", Branch: "main"},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "checkout-default"}); err == nil {
+		t.Error("a failing checkout should return an error")
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "/a/dirty: skipped, project has uncommitted changes") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/a/failing: pathspec 'missing' did not match") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/a/test: feature -> main") {
+		t.Errorf("wrong output: %s", val)
+	}
+}
+
 func TestScript(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -574,7 +805,7 @@ func TestScript(t *testing.T) {
 	}
 }
 
-func TestMvProject(t *testing.T) {
+func TestScriptShow(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -592,29 +823,52 @@ func TestMvProject(t *testing.T) {
 		t.FailNow()
 	}
 
-	// test with no enough args should fails
-	if err := app.getCliApp().Run([]string{"srcode", "mv"}); err != errWrongMvUsage {
-		t.Errorf("got %v want %v", err, errWrongMvUsage)
+	// test with no args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "script", "show"}); err != errWrongScriptShowUsage {
+		t.Errorf("got %v want %v", err, errWrongScriptShowUsage)
 	}
-	if err := app.getCliApp().Run([]string{"srcode", "mv", "test"}); err != errWrongMvUsage {
-		t.Errorf("got %v want %v", err, errWrongMvUsage)
+
+	man := manifest.Manifest{
+		Projects: map[string]manifest.Project{"test-42": {Scripts: map[string][]string{"test": {"@go-test"}}}},
+		Scripts:  map[string][]string{"go-test": {"go vet ./...", "go test ./..."}},
 	}
 
 	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// display local script (resolved)
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Manifest().Return(man, nil)
+	codebaseMock.EXPECT().LocalPath().Return("test-42")
 
-	codebaseMock.EXPECT().
-		MoveProject("Perso/SuperStuff", "OldStuff/SuperStuff")
+	if err := app.getCliApp().Run([]string{"srcode", "script", "show", "test"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "go vet ./...\ngo test ./...\n" {
+		t.Errorf("got %s", b.String())
+	}
 
-	if err := app.getCliApp().Run([]string{"srcode", "mv", "Perso/SuperStuff", "OldStuff/SuperStuff"}); err != nil {
-		t.Fail()
+	// display global script
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Manifest().Return(man, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "show", "--global", "go-test"}); err != nil {
+		t.Error(err)
 	}
-	if b.String() != "Successfully moved from Perso/SuperStuff to OldStuff/SuperStuff\n" {
-		t.Fail()
+	if b.String() != "go vet ./...\ngo test ./...\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// display non existing global script
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Manifest().Return(man, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "show", "--global", "test"}); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Error(err)
 	}
 }
 
-func TestRmProject(t *testing.T) {
+func TestScriptRm(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -632,31 +886,59 @@ func TestRmProject(t *testing.T) {
 		t.FailNow()
 	}
 
-	// test with no enough args should fails
-	if err := app.getCliApp().Run([]string{"srcode", "rm"}); err != errWrongRmUsage {
-		t.Errorf("got %v want %v", err, errWrongRmUsage)
+	// test with no args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "script", "rm"}); err != errWrongScriptRmUsage {
+		t.Errorf("got %v want %v", err, errWrongScriptRmUsage)
 	}
 
 	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
-	codebaseMock.EXPECT().RmProject("Contributing/Test", false)
-	if err := app.getCliApp().Run([]string{"srcode", "rm", "Contributing/Test"}); err != nil {
-		t.Fail()
+	codebaseMock.EXPECT().RmScript("go-test", true).Return(nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "rm", "--global", "go-test"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully removed script `go-test`\n" {
+		t.Errorf("got %s", b.String())
 	}
+}
 
-	b.Reset()
-	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
-	codebaseMock.EXPECT().RmProject("Contributing/Test", true)
-	if err := app.getCliApp().Run([]string{"srcode", "rm", "--delete", "Contributing/Test"}); err != nil {
-		t.Fail()
+func TestScriptMv(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
 	}
 
-	if b.String() != "Successfully deleted Contributing/Test\n" {
-		t.Fail()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "script", "mv", "test"}); err != errWrongScriptMvUsage {
+		t.Errorf("got %v want %v", err, errWrongScriptMvUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().MoveScript("test", "check", false).Return(nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "mv", "test", "check"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully renamed script `test` to `check`\n" {
+		t.Errorf("got %s", b.String())
 	}
 }
 
-func TestHook(t *testing.T) {
+func TestScriptPromote(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -674,38 +956,1075 @@ func TestHook(t *testing.T) {
 		t.FailNow()
 	}
 
-	// test with no enough args should fails
-	if err := app.getCliApp().Run([]string{"srcode", "hook"}); err != errWrongHookUsage {
-		t.Errorf("got %v want %v", err, errWrongHookUsage)
+	// test with no args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "script", "promote"}); err != errWrongScriptPromoteUsage {
+		t.Errorf("got %v want %v", err, errWrongScriptPromoteUsage)
 	}
 
 	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
 	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
-	codebaseMock.EXPECT().SetHook("lint").Return(nil)
-	codebaseMock.EXPECT().LocalPath().Return("Contributing/Test")
-	if err := app.getCliApp().Run([]string{"srcode", "hook", "lint"}); err != nil {
-		t.Fail()
+	codebaseMock.EXPECT().PromoteScript("lint", "lint").Return(nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "promote", "lint"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully promoted script `lint` to global script `lint`\n" {
+		t.Errorf("got %s", b.String())
 	}
 
-	if b.String() != "Successfully applied hook `lint` to /Contributing/Test\n" {
-		t.Fail()
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().PromoteScript("lint", "go-lint").Return(nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "script", "promote", "lint", "go-lint"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully promoted script `lint` to global script `go-lint`\n" {
+		t.Errorf("got %s", b.String())
 	}
 }
 
-func TestParseGitConfig(t *testing.T) {
-	config := parseGitConfig([]string{})
-	if len(config) != 0 {
-		t.Fail()
+func TestMvProject(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
 	}
 
-	config = parseGitConfig([]string{"user.name=test", "user.email=something", "invalid"})
-	if len(config) != 2 {
-		t.Fail()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
 	}
-	if config["user.name"] != "test" {
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "mv"}); err != errWrongMvUsage {
+		t.Errorf("got %v want %v", err, errWrongMvUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "mv", "test"}); err != errWrongMvUsage {
+		t.Errorf("got %v want %v", err, errWrongMvUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+
+	codebaseMock.EXPECT().
+		MoveProject("Perso/SuperStuff", "OldStuff/SuperStuff")
+
+	if err := app.getCliApp().Run([]string{"srcode", "mv", "Perso/SuperStuff", "OldStuff/SuperStuff"}); err != nil {
 		t.Fail()
 	}
-	if config["user.email"] != "something" {
+	if b.String() != "Successfully moved from Perso/SuperStuff to OldStuff/SuperStuff\n" {
 		t.Fail()
 	}
 }
+
+func TestRmProject(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "rm"}); err != errWrongRmUsage {
+		t.Errorf("got %v want %v", err, errWrongRmUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RmProject("Contributing/Test", false)
+	if err := app.getCliApp().Run([]string{"srcode", "rm", "Contributing/Test"}); err != nil {
+		t.Fail()
+	}
+
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RmProject("Contributing/Test", true)
+	if err := app.getCliApp().Run([]string{"srcode", "rm", "--delete", "Contributing/Test"}); err != nil {
+		t.Fail()
+	}
+
+	if b.String() != "Successfully deleted Contributing/Test\n" {
+		t.Fail()
+	}
+}
+
+func TestRemoteSet(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "remote", "set", "Contributing/Test", "upstream"}); err != errWrongRemoteSetUsage {
+		t.Errorf("got %v want %v", err, errWrongRemoteSetUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SetRemote("Contributing/Test", "upstream", "https://example.com/test.git").Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "remote", "set", "Contributing/Test", "upstream", "https://example.com/test.git"}); err != nil {
+		t.Error(err)
+	}
+
+	if b.String() != "Successfully set upstream remote of Contributing/Test to https://example.com/test.git\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestRemoteRewrite(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "remote", "rewrite", "git@github.com:"}); err != errWrongRemoteRewriteUsage {
+		t.Errorf("got %v want %v", err, errWrongRemoteRewriteUsage)
+	}
+
+	rewrites := []codebase.RemoteRewrite{
+		{Path: "a", Name: "origin", Old: "git@github.com:me/a.git", New: "https://github.com/me/a.git"},
+		{Path: "b", Name: "upstream", Old: "git@github.com:other/b.git", New: "https://github.com/other/b.git"},
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RewriteRemotes("git@github.com:", "https://github.com/", true).Return(rewrites, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "remote", "rewrite", "--dry-run", "git@github.com:", "https://github.com/"}); err != nil {
+		t.Error(err)
+	}
+
+	expected := `/a (origin): git@github.com:me/a.git -> https://github.com/me/a.git
+/b (upstream): git@github.com:other/b.git -> https://github.com/other/b.git
+2 remote(s) would be rewritten (run without --dry-run to apply)
+`
+	if b.String() != expected {
+		t.Errorf("got %s want %s", b.String(), expected)
+	}
+
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RewriteRemotes("git@github.com:", "https://github.com/", false).Return(rewrites, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "remote", "rewrite", "git@github.com:", "https://github.com/"}); err != nil {
+		t.Error(err)
+	}
+
+	if !strings.HasSuffix(b.String(), "Successfully rewritten 2 remote(s)\n") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestManifestConvert(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// the format is required
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "convert"}); err == nil {
+		t.Error("convert without format should fail")
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().ConvertManifest("yaml").Return("manifest.yaml", nil)
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "convert", "--to", "yaml"}); err != nil {
+		t.Error(err)
+	}
+
+	if b.String() != "Successfully converted manifest to .srcode/manifest.yaml\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestManifestSplit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SplitManifest().Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "split"}); err != nil {
+		t.Error(err)
+	}
+
+	if b.String() != "Successfully split manifest into .srcode/projects and .srcode/scripts\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestManifestMerge(t *testing.T) {
+	app := app{writer: &strings.Builder{}}
+
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "merge", "a", "b"}); err != errWrongManifestMergeUsage {
+		t.Errorf("got %v want %v", err, errWrongManifestMergeUsage)
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	base := write("base", `{"projects": {"api": {"remote": "api.git", "scripts": {"test": ["go test"]}}}}`)
+	ours := write("ours", `{"projects": {"api": {"remote": "api.git", "scripts": {"test": ["go test -v"]}}}}`)
+	theirs := write("theirs", `{"projects": {"api": {"remote": "api.git", "scripts": {"test": ["go test"]}}, "web": {"remote": "web.git"}}}`)
+
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "merge", base, ours, theirs, "manifest.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "go test -v") || !strings.Contains(string(b), "web.git") {
+		t.Errorf("wrong merge result: %s", b)
+	}
+
+	// the same script changed differently
+	theirs = write("theirs", `{"projects": {"api": {"remote": "api.git", "scripts": {"test": ["go test -race"]}}}}`)
+
+	err = app.getCliApp().Run([]string{"srcode", "manifest", "merge", base, ours, theirs, "manifest.json"})
+	if !errors.Is(err, errManifestConflict) {
+		t.Fatalf("got %v want %v", err, errManifestConflict)
+	}
+
+	want := `conflicting changes in manifest.json (the ours values have been kept):
+projects > api > scripts > test
+  base:   ["go test"]
+  ours:   ["go test -v"]
+  theirs: ["go test -race"]`
+	if err.Error() != want {
+		t.Errorf("got %s want %s", err, want)
+	}
+
+	// the conflicts are reported for sync
+	report := filepath.Join(dir, "conflicts")
+	err = app.getCliApp().Run([]string{"srcode", "manifest", "merge", "--report", report, base, ours, theirs, "manifest.json"})
+	if !errors.Is(err, errManifestConflict) {
+		t.Fatalf("got %v want %v", err, errManifestConflict)
+	}
+
+	conflicts, err := manifest.ReadConflicts(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].File != "manifest.json" {
+		t.Errorf("wrong conflicts: %v", conflicts)
+	}
+}
+
+func TestManifestResolve(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	for _, args := range [][]string{
+		{"srcode", "manifest", "resolve"},
+		{"srcode", "manifest", "resolve", "--local", "--remote"},
+		{"srcode", "manifest", "resolve", "--abort", "scripts > test"},
+	} {
+		if err := app.getCliApp().Run(args); err != errWrongManifestResolveUsage {
+			t.Errorf("got %v want %v", err, errWrongManifestResolveUsage)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).AnyTimes().Return(codebaseMock, nil)
+
+	// a conflict is remaining
+	remaining := []manifest.Conflict{{File: "manifest.json", Path: []string{"scripts", "lint"}, Ours: []interface{}{"golint"}}}
+	codebaseMock.EXPECT().ResolveManifest(true, []string{"scripts > test"}).Return(remaining, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "resolve", "--local", "scripts > test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `1 conflict(s) remaining:
+manifest.json: scripts > lint
+  base:   (none)
+  remote: ["golint"]
+  local:  (none)
+`
+	if b.String() != want {
+		t.Errorf("got %s want %s", b.String(), want)
+	}
+
+	// the synchronization is finished once the conflicts are resolved
+	b.Reset()
+	codebaseMock.EXPECT().ResolveManifest(false, []string{}).Return(nil, nil)
+	codebaseMock.EXPECT().
+		Sync(false, gomock.Any(), gomock.Any()).
+		Do(func(delete bool, ch1, ch2 chan<- codebase.ProjectEntry) {
+			close(ch1)
+			close(ch2)
+		}).
+		Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "resolve", "--remote"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != "Successfully resolved manifest conflicts\nSuccessfully synchronized codebase\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+	codebaseMock.EXPECT().AbortSync().Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "manifest", "resolve", "--abort"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != "Successfully cancelled synchronization, the local changes have been kept\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	if hint := getErrorHint(fmt.Errorf("%w: scripts > test", codebase.ErrManifestConflict)); !strings.Contains(hint, "srcode manifest resolve") {
+		t.Errorf("wrong hint: %s", hint)
+	}
+}
+
+func TestHook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with no enough args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "hook"}); err != errWrongHookUsage {
+		t.Errorf("got %v want %v", err, errWrongHookUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil).Times(2)
+	codebaseMock.EXPECT().LocalPath().Return("Contributing/Test").Times(2)
+
+	// only the script provided: should be used as pre-push hook
+	codebaseMock.EXPECT().SetHook("pre-push", []string{"lint"}).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "lint"}); err != nil {
+		t.Fail()
+	}
+
+	if b.String() != "Successfully applied pre-push hook `lint` to /Contributing/Test\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	b.Reset()
+	codebaseMock.EXPECT().SetHook("pre-commit", []string{"fmt", "test"}).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "pre-commit", "fmt", "test"}); err != nil {
+		t.Fail()
+	}
+
+	if b.String() != "Successfully applied pre-commit hook `fmt`, `test` to /Contributing/Test\n" {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestHookSubCommands(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+		reader:           strings.NewReader("refs/heads/main"),
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "rm"}); err != errWrongHookRmUsage {
+		t.Errorf("got %v want %v", err, errWrongHookRmUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "exec"}); err != errWrongHookExecUsage {
+		t.Errorf("got %v want %v", err, errWrongHookExecUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil).Times(3)
+
+	// ls
+	codebaseMock.EXPECT().Hooks(false).Return([]codebase.HookEntry{
+		{Type: "pre-commit", Scripts: []string{"fmt", "lint"}, Installed: true, Chained: true},
+	}, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "ls"}); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(b.String(), "fmt, lint") || !strings.Contains(b.String(), "installed (chained)") {
+		t.Errorf("got %s", b.String())
+	}
+
+	// rm
+	b.Reset()
+	codebaseMock.EXPECT().RmHook("pre-commit").Return(nil)
+	codebaseMock.EXPECT().LocalPath().Return("Contributing/Test")
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "rm", "pre-commit"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully removed pre-commit hook from /Contributing/Test\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// exec
+	codebaseMock.EXPECT().ExecHook("pre-push", []string{"origin", "git@github.com:creekorful/srcode.git"}, app.reader, app.writer).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "hook", "exec", "pre-push", "origin", "git@github.com:creekorful/srcode.git"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil).Times(2)
+	codebaseMock.EXPECT().Env(false).Return(map[string]string{
+		"SRCODE_PROJECT": "Work/api",
+		"GOFLAGS":        "-mod=mod",
+		"MESSAGE":        "it's working",
+	}, nil).Times(2)
+
+	if err := app.getCliApp().Run([]string{"srcode", "env"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "GOFLAGS=-mod=mod\nMESSAGE=it's working\nSRCODE_PROJECT=Work/api\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	b.Reset()
+	if err := app.getCliApp().Run([]string{"srcode", "env", "--export"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "export GOFLAGS='-mod=mod'\nexport MESSAGE='it'\\''s working'\nexport SRCODE_PROJECT='Work/api'\n" {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestSecret(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		reader:           strings.NewReader("secret-from-stdin\n"),
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with wrong args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "set"}); err != errWrongSecretSetUsage {
+		t.Errorf("got %v want %v", err, errWrongSecretSetUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "get"}); err != errWrongSecretGetUsage {
+		t.Errorf("got %v want %v", err, errWrongSecretGetUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "rm"}); err != errWrongSecretRmUsage {
+		t.Errorf("got %v want %v", err, errWrongSecretRmUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// set secret from args
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SetSecret("TOKEN", "42", true).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "set", "--global", "TOKEN", "42"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully set secret `TOKEN`\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// set secret from stdin
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SetSecret("TOKEN", "secret-from-stdin", false).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "set", "TOKEN"}); err != nil {
+		t.Error(err)
+	}
+
+	// get secret
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Secret("TOKEN", false).Return("42", nil)
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "get", "TOKEN"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "42\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// rm secret
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RmSecret("TOKEN", true).Return(nil)
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "rm", "--global", "TOKEN"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully removed secret `TOKEN`\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// ls secrets
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Manifest().Return(manifest.Manifest{
+		Projects: map[string]manifest.Project{"Work/api": {Secrets: map[string]string{"API_TOKEN": "encrypted"}}},
+		Secrets:  map[string]string{"GITHUB_TOKEN": "encrypted"},
+	}, nil)
+	codebaseMock.EXPECT().LocalPath().Return("Work/api")
+	if err := app.getCliApp().Run([]string{"srcode", "secret", "ls"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "GITHUB_TOKEN") || !strings.Contains(val, "API_TOKEN") {
+		t.Errorf("got %s", val)
+	}
+	if strings.Contains(val, "encrypted") {
+		t.Errorf("got %s", val)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil).Times(3)
+
+	runLogs := []codebase.RunLog{
+		{ID: "2", Project: "Work/api", Script: "test", ExitCode: 0},
+		{ID: "1", Project: "Work/api", Script: "test", Args: []string{"-v"}, ExitCode: 2},
+	}
+
+	// list the runs
+	codebaseMock.EXPECT().RunLogs("test").Return(runLogs, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "logs", "test"}); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(b.String(), "test -v") || !strings.Contains(b.String(), "/Work/api") {
+		t.Errorf("got %s", b.String())
+	}
+
+	// display the last failure
+	b.Reset()
+	codebaseMock.EXPECT().RunLogs("").Return(runLogs, nil)
+	codebaseMock.EXPECT().RunOutput("1").Return("--- FAIL: TestSomething\n", nil)
+	if err := app.getCliApp().Run([]string{"srcode", "logs", "--failed"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "--- FAIL: TestSomething\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// display a run by id
+	b.Reset()
+	codebaseMock.EXPECT().RunOutput("2").Return("ok\n", nil)
+	if err := app.getCliApp().Run([]string{"srcode", "logs", "--id", "2"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "ok\n" {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	// test with wrong args should fails
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "save"}); err != errWrongSnapshotSaveUsage {
+		t.Errorf("got %v want %v", err, errWrongSnapshotSaveUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "restore"}); err != errWrongSnapshotRestoreUsage {
+		t.Errorf("got %v want %v", err, errWrongSnapshotRestoreUsage)
+	}
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "diff", "v1"}); err != errWrongSnapshotDiffUsage {
+		t.Errorf("got %v want %v", err, errWrongSnapshotDiffUsage)
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// save
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SaveSnapshot("v1", true).Return(codebase.Snapshot{
+		Name:     "v1",
+		Projects: map[string]codebase.SnapshotProject{"a": {}, "b": {}},
+	}, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "save", "--force", "v1"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully saved snapshot v1 (2 projects)\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	// restore
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RestoreSnapshot("v1").Return([]codebase.SnapshotEntry{
+		{Path: "a", Commit: "0123456789abcdef0123456789abcdef01234567"},
+		{Path: "b", Commit: "fedcba9876543210fedcba9876543210fedcba98", Err: errors.New("reference is not a tree")},
+	}, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "restore", "v1"}); err == nil {
+		t.Error("a failing restoration should return an error")
+	}
+	if val := b.String(); !strings.Contains(val, "/a: 0123456") || !strings.Contains(val, "/b: reference is not a tree") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	// diff
+	b.Reset()
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().DiffSnapshots("v1", "v2").Return([]codebase.SnapshotDiff{
+		{
+			Path:    "a",
+			From:    "0123456789abcdef0123456789abcdef01234567",
+			To:      "fedcba9876543210fedcba9876543210fedcba98",
+			Added:   []repository.Commit{{Hash: "fedcba9876543210fedcba9876543210fedcba98", Subject: "Fix the login"}},
+			Removed: []repository.Commit{{Hash: "0123456789abcdef0123456789abcdef01234567", Subject: "Break the login"}},
+		},
+		{Path: "b", To: "fedcba9876543210fedcba9876543210fedcba98"},
+	}, nil)
+	if err := app.getCliApp().Run([]string{"srcode", "snapshot", "diff", "v1", "v2"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	for _, expected := range []string{"0123456..fedcba9", "fedcba9 Fix the login", "0123456 Break the login", "added (fedcba9)"} {
+		if !strings.Contains(val, expected) {
+			t.Errorf("wrong output: %s", val)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// Start
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "start"}); !errors.Is(err, errWrongTopicStartUsage) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().StartTopic("feature/sso", []string{"a/api", "a/web"}).Return([]codebase.CheckoutEntry{
+		{Path: "a/api", Head: "main", Branch: "feature/sso"},
+		{Path: "a/web", Head: "develop", Branch: "feature/sso"},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "start", "feature/sso", "a/api", "a/web"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "/a/api: main -> feature/sso") || !strings.Contains(val, "/a/web: develop -> feature/sso") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "Successfully started topic feature/sso") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	b.Reset()
+
+	// Status
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().TopicStatus("").Return(codebase.Topic{Name: "feature/sso"}, []codebase.TopicProjectStatus{
+		{Path: "a/api", Head: "feature/sso", Base: "main", Commits: []repository.Commit{
+			{Hash: "a4f0d3c1b2e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9", Subject: "Add sso login"},
+		}},
+		{Path: "a/web", Head: "develop", Base: "develop", Dirty: true},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "status"}); err != nil {
+		t.Error(err)
+	}
+
+	val = b.String()
+	if !strings.Contains(val, "Topic feature/sso") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/a/api: 1 commit(s) ahead of main") || !strings.Contains(val, "  a4f0d3c Add sso login") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/a/web(*): on develop, 0 commit(s) ahead of develop") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	b.Reset()
+
+	// Switch
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "switch"}); !errors.Is(err, errWrongTopicSwitchUsage) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().SwitchTopic("feature/sso").Return([]codebase.CheckoutEntry{
+		{Path: "a/web", Head: "develop", Branch: "feature/sso"},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "switch", "feature/sso"}); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(b.String(), "Successfully switched to topic feature/sso") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+
+	// Finish with a dirty project
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().FinishTopic("").Return([]codebase.CheckoutEntry{
+		{Path: "a/api", Head: "feature/sso", Branch: "main"},
+		{Path: "a/web", Head: "feature/sso", Branch: "develop", Err: codebase.ErrProjectDirty},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "finish"}); err == nil {
+		t.Error("finishing a topic with a dirty project should fail")
+	}
+	if !strings.Contains(b.String(), "/a/web: skipped, project has uncommitted changes") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+
+	// Finish
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().FinishTopic("feature/sso").Return([]codebase.CheckoutEntry{
+		{Path: "a/web", Head: "feature/sso", Branch: "develop"},
+	}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "topic", "finish", "feature/sso"}); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(b.String(), "Successfully finished topic") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestWorktree(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	codebaseProviderMock := codebase_mock.NewMockProvider(mockCtrl)
+
+	b := &strings.Builder{}
+
+	app := app{
+		codebaseProvider: codebaseProviderMock,
+		writer:           b,
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.FailNow()
+	}
+
+	codebaseMock := codebase_mock.NewMockCodebase(mockCtrl)
+
+	// Add
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "add", "Work/api"}); !errors.Is(err, errWrongWorktreeAddUsage) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().AddWorktree("Work/api", "feature/sso").
+		Return(codebase.Worktree{Path: "Work/api@feature-sso", Branch: "feature/sso"}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "add", "Work/api", "feature/sso"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully added worktree /Work/api@feature-sso (feature/sso)\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+
+	// Ls
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Worktrees().Return(map[string][]codebase.Worktree{"Work/web": nil}, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "ls"}); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(b.String(), "No worktrees") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	b.Reset()
+
+	worktrees := map[string][]codebase.Worktree{
+		"Work/api": {
+			{Path: "Work/api@feature-sso", Branch: "feature/sso"},
+			{Path: "/tmp/api-review", Commit: "a4f0d3c1b2e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9"},
+		},
+		"Work/web": {{Path: "Work/web@main", Branch: "main"}},
+	}
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Worktrees().Return(worktrees, nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "ls"}); err != nil {
+		t.Error(err)
+	}
+
+	val := b.String()
+	if !strings.Contains(val, "/Work/api@feature-sso") || !strings.Contains(val, "feature/sso") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/tmp/api-review") || !strings.Contains(val, "a4f0d3c") {
+		t.Errorf("wrong output: %s", val)
+	}
+	if !strings.Contains(val, "/Work/web@main") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	b.Reset()
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().Worktrees().Return(worktrees, nil)
+	codebaseMock.EXPECT().LocalPath().Return("Work")
+
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "ls", "web"}); err != nil {
+		t.Error(err)
+	}
+	if val := b.String(); !strings.Contains(val, "/Work/web@main") || strings.Contains(val, "/Work/api@feature-sso") {
+		t.Errorf("wrong output: %s", val)
+	}
+
+	b.Reset()
+
+	// Rm
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "rm", "Work/api"}); !errors.Is(err, errWrongWorktreeRmUsage) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	codebaseProviderMock.EXPECT().Open(cwd).Return(codebaseMock, nil)
+	codebaseMock.EXPECT().RemoveWorktree("Work/api", "feature/sso", true).Return(nil)
+
+	if err := app.getCliApp().Run([]string{"srcode", "worktree", "rm", "--force", "Work/api", "feature/sso"}); err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Successfully removed worktree feature/sso of Work/api\n" {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestParseGitConfig(t *testing.T) {
+	config := parseGitConfig([]string{})
+	if len(config) != 0 {
+		t.Fail()
+	}
+
+	config = parseGitConfig([]string{"user.name=test", "user.email=something", "invalid"})
+	if len(config) != 2 {
+		t.Fail()
+	}
+	if config["user.name"] != "test" {
+		t.Fail()
+	}
+	if config["user.email"] != "something" {
+		t.Fail()
+	}
+}
+
+func TestGetExitCode(t *testing.T) {
+	if code := getExitCode(errors.New("test")); code != 1 {
+		t.Errorf("got %d want 1", code)
+	}
+
+	if code := getExitCode(fmt.Errorf("test: %w", codebase.ErrScriptTimeout)); code != 124 {
+		t.Errorf("got %d want 124", code)
+	}
+
+	err := exec.Command("sh", "-c", "exit 12").Run()
+	if code := getExitCode(fmt.Errorf("test: %w", err)); code != 12 {
+		t.Errorf("got %d want 12", code)
+	}
+
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	if code := getExitCode(err); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("got %d want %d", code, 128+int(syscall.SIGTERM))
+	}
+
+	err = exec.Command("sh", "-c", "exit 128").Run()
+	if code := getExitCode(&repository.GitError{Args: []string{"push"}, ExitCode: 128, Err: err}); code != 1 {
+		t.Errorf("got %d want 1", code)
+	}
+}
+
+func TestGetErrorHint(t *testing.T) {
+	if hint := getErrorHint(errors.New("test")); hint != "" {
+		t.Errorf("unexpected hint: %s", hint)
+	}
+
+	err := fmt.Errorf("error while synchronizing: %w", repository.ErrAuthentication)
+	if hint := getErrorHint(err); !strings.Contains(hint, "credentials") {
+		t.Errorf("wrong hint: %s", hint)
+	}
+}
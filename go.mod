@@ -3,9 +3,14 @@ module github.com/creekorful/srcode
 go 1.15
 
 require (
+	filippo.io/age v1.0.0-rc.1
 	github.com/fatih/color v1.10.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-git/go-git/v5 v5.2.0
 	github.com/golang/mock v1.4.4
+	github.com/mattn/go-isatty v0.0.12
 	github.com/olekukonko/tablewriter v0.0.4
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -7,6 +7,22 @@ import (
 	"strings"
 )
 
+// ExecError is returned when a command executed using ExecWithOutput fails
+type ExecError struct {
+	Command string
+	Stderr  string
+	// Err is the original error (usually an *exec.ExitError)
+	Err error
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("error while running `%s`: %s", e.Command, e.Stderr)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
 // ExecWithOutput execute given command and return the output as a string
 func ExecWithOutput(cmd *exec.Cmd) (string, error) {
 	// capture stderr
@@ -15,7 +31,7 @@ func ExecWithOutput(cmd *exec.Cmd) (string, error) {
 
 	b, err := cmd.Output()
 	if err != nil {
-		return "", fmt.Errorf("error while running `%s`: %s", cmd.String(), stdErr)
+		return "", &ExecError{Command: cmd.String(), Stderr: strings.TrimSpace(stdErr.String()), Err: err}
 	}
 
 	return strings.TrimSuffix(string(b), "\n"), err
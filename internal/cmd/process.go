@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"github.com/mattn/go-isatty"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// killGracePeriod is the time given to a process group to exit after being terminated
+// before being killed
+const killGracePeriod = 5 * time.Second
+
+// ExecInteractive execute given command in its own process group.
+// If the command stdin is the current terminal, the process group is put in foreground
+// so that the command can interact with the user. The signals received by the current
+// process are forwarded to the process group, and the whole process tree is killed once ctx is done.
+func ExecInteractive(ctx context.Context, command *exec.Cmd) error {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// Give the terminal to the command if we currently own it
+	tty, isForeground := foregroundTerminal(command.Stdin)
+	if isForeground {
+		command.SysProcAttr.Foreground = true
+		command.SysProcAttr.Ctty = int(tty.Fd())
+	}
+
+	// Start catching the signals before starting the command
+	// to make sure none of them get lost
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	if err := command.Start(); err != nil {
+		return err
+	}
+
+	// the process group id is the same as the process id since it's the group leader
+	pgid := command.Process.Pid
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				_ = syscall.Kill(-pgid, sig.(syscall.Signal))
+			case <-ctx.Done():
+				_ = syscall.Kill(-pgid, syscall.SIGTERM)
+
+				select {
+				case <-done:
+				case <-time.After(killGracePeriod):
+					_ = syscall.Kill(-pgid, syscall.SIGKILL)
+				}
+
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := command.Wait()
+	close(done)
+
+	// Take back the terminal
+	if isForeground {
+		restoreForeground(tty)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// foregroundTerminal returns the terminal linked to given reader,
+// and whether the current process group is in foreground on it
+func foregroundTerminal(reader interface{}) (*os.File, bool) {
+	file, ok := reader.(*os.File)
+	if !ok || file == nil {
+		return nil, false
+	}
+
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return nil, false // not a terminal
+	}
+
+	return file, int(pgrp) == syscall.Getpgrp()
+}
+
+// IsTerminal returns true if given reader (or writer) is a terminal
+func IsTerminal(val interface{}) bool {
+	file, ok := val.(*os.File)
+	return ok && file != nil && isatty.IsTerminal(file.Fd())
+}
+
+// restoreForeground put back the current process group in foreground on given terminal
+func restoreForeground(tty *os.File) {
+	// we are currently a background process: changing the foreground group
+	// will trigger a SIGTTOU that need to be ignored
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	pgrp := int32(syscall.Getpgrp())
+	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+}
+
+// GetExitCode returns the exit code of the process that has returned given error
+// 0 is returned if there's no error, and -1 if the process has not exited
+// If the process has been killed by a signal, the same convention as the shell is used (128 + signal)
+func GetExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return exitErr.ExitCode()
+}
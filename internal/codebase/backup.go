@@ -0,0 +1,363 @@
+package codebase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/repository"
+	"golang.org/x/sync/errgroup"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// backupFile is the file (inside the backup directory) containing the backup metadata
+	backupFile = "backup.json"
+	// backupMetaDir is the directory (inside the backup directory) containing the meta repository bundles
+	backupMetaDir = "meta"
+	// backupProjectsDir is the directory (inside the backup directory) containing the projects bundles
+	backupProjectsDir = "projects"
+)
+
+var (
+	// ErrBackupNotFound is returned when the directory doesn't contain a backup
+	ErrBackupNotFound = errors.New("no backup found")
+	// ErrProjectNotBackedUp is returned when restoring a project missing from the backup
+	ErrProjectNotBackedUp = errors.New("project not found in backup")
+)
+
+// Backup is the metadata of a codebase backup
+type Backup struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Remote is the remote of the codebase (empty if none)
+	Remote   string                      `json:"remote,omitempty"`
+	Meta     BackupRepository            `json:"meta"`
+	Projects map[string]BackupRepository `json:"projects"`
+}
+
+// BackupRepository is the backup of a repository
+type BackupRepository struct {
+	// Bundles are the bundles (relative to the backup directory) to fetch in order, each one based on the previous ones
+	Bundles []string `json:"bundles"`
+	// Refs are the references of the repository when the backup has been made
+	Refs map[string]string `json:"refs"`
+	// Head is the checked out branch (or commit if HEAD is detached)
+	Head string `json:"head"`
+}
+
+// BackupEntry is the result of the backup of a project
+type BackupEntry struct {
+	Path string
+	// Bundle is the bundle created (empty if the project has not changed since the previous backup)
+	Bundle string
+	Err    error
+}
+
+func (codebase *codebase) Backup(dir string) ([]BackupEntry, error) {
+	// the bundles are created from the repositories directory
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := readBackup(dir)
+	if err != nil && !errors.Is(err, ErrBackupNotFound) {
+		return nil, err
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// the remote is optional
+	backup.Remote, _ = codebase.repo.Remote("origin")
+
+	if backup.Meta, _, err = backupRepository(codebase.repo, dir, backupMetaDir, backup.Meta); err != nil {
+		return nil, fmt.Errorf("error while backing up codebase: %w", err)
+	}
+
+	// the projects which are not git repositories are restored from their source
+	var paths []string
+	for path, project := range man.Projects {
+		if project.IsGit() {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var entries []BackupEntry
+	for _, path := range paths {
+		entry := BackupEntry{Path: path}
+
+		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+		if err != nil {
+			entry.Err = err
+			entries = append(entries, entry)
+			continue
+		}
+
+		// the previous backup of the project is kept if it fails
+		var backupRepo BackupRepository
+		backupRepo, entry.Bundle, entry.Err = backupRepository(repo, dir, filepath.Join(backupProjectsDir, path), backup.Projects[path])
+		if entry.Err == nil {
+			backup.Projects[path] = backupRepo
+		}
+
+		entries = append(entries, entry)
+	}
+
+	backup.UpdatedAt = time.Now()
+	if err := writeBackup(dir, backup); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (provider *provider) Restore(dir, path string, ch chan<- ProjectEntry) (Codebase, error) {
+	defer func() {
+		if ch != nil {
+			close(ch)
+		}
+	}()
+
+	exist, err := codebaseExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, fmt.Errorf("error while restoring codebase at %s: %w", path, ErrCodebaseAlreadyExist)
+	}
+
+	// the bundles are fetched from the repositories directory
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+
+	backup, err := readBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := provider.repoProvider.Init(filepath.Join(path, metaDir))
+	if err != nil {
+		return nil, fmt.Errorf("error while restoring codebase at %s: %w", path, err)
+	}
+
+	if err := restoreRepository(repo, dir, backup.Meta); err != nil {
+		return nil, fmt.Errorf("error while restoring codebase: %w", err)
+	}
+
+	if backup.Remote != "" {
+		if err := repo.AddRemote("origin", backup.Remote); err != nil {
+			return nil, err
+		}
+	}
+
+	// the cache is best-effort
+	_ = provider.cache.register(path)
+
+	codebase := &codebase{
+		rootPath:       path,
+		repoProvider:   provider.repoProvider,
+		repo:           repo,
+		manProvider:    provider.manifestProvider,
+		sourceProvider: provider.sourceProvider,
+		keyring:        provider.keyring,
+		cache:          provider.cache,
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// Restore the projects, and point them back to their real remote
+	g := errgroup.Group{}
+	for projectPath, project := range man.Projects {
+		projectPath := projectPath
+		project := project
+
+		g.Go(func() error {
+			if !project.IsGit() {
+				if _, err := codebase.syncSource(projectPath, project); err != nil {
+					return err
+				}
+
+				if ch != nil {
+					ch <- ProjectEntry{
+						Path:    projectPath,
+						Project: project,
+					}
+				}
+
+				return nil
+			}
+
+			backupRepo, exist := backup.Projects[projectPath]
+			if !exist {
+				return fmt.Errorf("unable to restore %s: %w", projectPath, ErrProjectNotBackedUp)
+			}
+
+			repo, err := provider.repoProvider.Init(filepath.Join(path, projectPath))
+			if err != nil {
+				return err
+			}
+
+			if err := restoreRepository(repo, dir, backupRepo); err != nil {
+				return fmt.Errorf("unable to restore %s: %w", projectPath, err)
+			}
+
+			if err := repo.AddRemote("origin", project.Remote); err != nil {
+				return err
+			}
+
+			if err := codebase.configureProject(man, projectPath); err != nil {
+				return err
+			}
+
+			if ch != nil {
+				ch <- ProjectEntry{
+					Path:    projectPath,
+					Project: project,
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return codebase, nil
+}
+
+// backupRepository create a bundle containing the objects added since the previous backup
+// the bundle is not created if there is no new objects
+func backupRepository(repo repository.Repository, dir, name string, previous BackupRepository) (BackupRepository, string, error) {
+	refs, err := repo.Refs()
+	if err != nil {
+		return previous, "", err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return previous, "", err
+	}
+	if head == "HEAD" {
+		if head, err = repo.HeadCommit(); err != nil {
+			return previous, "", err
+		}
+	}
+
+	backupRepo := BackupRepository{
+		Bundles: append([]string{}, previous.Bundles...),
+		Refs:    refs,
+		Head:    head,
+	}
+
+	// the objects reachable from the previous references are already in the previous bundles
+	var exclude []string
+	seen := map[string]bool{}
+	for _, hash := range previous.Refs {
+		if !seen[hash] {
+			seen[hash] = true
+			exclude = append(exclude, hash)
+		}
+	}
+	sort.Strings(exclude)
+
+	if err := os.MkdirAll(filepath.Join(dir, name), 0750); err != nil {
+		return previous, "", err
+	}
+
+	bundle := filepath.Join(name, fmt.Sprintf("%06d.bundle", len(previous.Bundles)+1))
+	if err := repo.CreateBundle(filepath.Join(dir, bundle), exclude); err != nil {
+		if errors.Is(err, repository.ErrEmptyBundle) {
+			return backupRepo, "", nil
+		}
+		return previous, "", err
+	}
+
+	// the backup may be restored on another OS
+	backupRepo.Bundles = append(backupRepo.Bundles, filepath.ToSlash(bundle))
+
+	return backupRepo, filepath.ToSlash(bundle), nil
+}
+
+// restoreRepository fetch the bundles in the (empty) repository, then restore its references and HEAD
+func restoreRepository(repo repository.Repository, dir string, backupRepo BackupRepository) error {
+	for _, bundle := range backupRepo.Bundles {
+		if err := repo.FetchBundle(filepath.Join(dir, filepath.FromSlash(bundle))); err != nil {
+			return err
+		}
+	}
+
+	// the bundles only contain the references updated since the previous one
+	refs, err := repo.Refs()
+	if err != nil {
+		return err
+	}
+
+	for name := range refs {
+		if _, exist := backupRepo.Refs[name]; !exist {
+			if err := repo.UpdateRef(name, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, hash := range backupRepo.Refs {
+		if refs[name] != hash {
+			if err := repo.UpdateRef(name, hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the working tree is empty: synchronize it if the current branch has been restored
+	if _, err := repo.HeadCommit(); err == nil {
+		if err := repo.Reset("HEAD"); err != nil {
+			return err
+		}
+	}
+
+	return repo.Checkout(backupRepo.Head)
+}
+
+func readBackup(dir string) (Backup, error) {
+	backup := Backup{Projects: map[string]BackupRepository{}}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, backupFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return backup, fmt.Errorf("unable to read backup at %s: %w", dir, ErrBackupNotFound)
+		}
+		return backup, err
+	}
+
+	if err := json.Unmarshal(b, &backup); err != nil {
+		return backup, fmt.Errorf("invalid backup at %s: %w", dir, err)
+	}
+
+	if backup.Projects == nil {
+		backup.Projects = map[string]BackupRepository{}
+	}
+
+	return backup, nil
+}
+
+func writeBackup(dir string, backup Backup) error {
+	b, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, backupFile), b, 0640)
+}
@@ -0,0 +1,310 @@
+package codebase
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"github.com/creekorful/srcode/internal/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheCodebasesFile is the file (inside the cache directory) listing the codebases using the cache
+const cacheCodebasesFile = "codebases"
+
+var (
+	// ErrNoCacheDir is returned when the cache directory cannot be determined
+	ErrNoCacheDir = errors.New("no cache directory")
+
+	// mirrorLocks prevent a mirror from being updated concurrently
+	mirrorLocks sync.Map
+)
+
+// CacheEntry is the result of the update of a mirror
+type CacheEntry struct {
+	Remote string
+	Err    error
+}
+
+// mirrorCache is a directory of bare mirrors (keyed by remote) used to speed up the clones
+type mirrorCache struct {
+	dir string
+}
+
+// defaultMirrorCache returns the cache located in the user cache directory
+// The location can be overridden using the SRCODE_CACHE_DIR environment variable
+func defaultMirrorCache() mirrorCache {
+	if dir := os.Getenv("SRCODE_CACHE_DIR"); dir != "" {
+		return mirrorCache{dir: dir}
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return mirrorCache{}
+	}
+
+	return mirrorCache{dir: filepath.Join(cacheDir, "srcode", "mirrors")}
+}
+
+func (codebase *codebase) UpdateCache() ([]CacheEntry, error) {
+	if codebase.cache.dir == "" {
+		return nil, ErrNoCacheDir
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors, err := codebase.cache.mirrors(codebase.repoProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	// refresh the existing mirrors & create the ones of the current codebase
+	remotes := map[string]bool{}
+	for _, remote := range mirrors {
+		remotes[remote] = true
+	}
+	for _, project := range man.Projects {
+		if useCache(project) {
+			remotes[project.Remote] = true
+		}
+	}
+
+	var entries []CacheEntry
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for remote := range remotes {
+		wg.Add(1)
+		go func(remote string) {
+			defer wg.Done()
+
+			_, err := codebase.cache.update(codebase.repoProvider, remote)
+
+			mutex.Lock()
+			entries = append(entries, CacheEntry{Remote: remote, Err: err})
+			mutex.Unlock()
+		}(remote)
+	}
+	wg.Wait()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Remote < entries[j].Remote
+	})
+
+	return entries, nil
+}
+
+func (codebase *codebase) GCCache() ([]string, error) {
+	if codebase.cache.dir == "" {
+		return nil, ErrNoCacheDir
+	}
+
+	roots, err := codebase.cache.codebases()
+	if err != nil {
+		return nil, err
+	}
+
+	// collect the remotes of the codebases still existing
+	remotes := map[string]bool{}
+	var existingRoots []string
+	for _, root := range append([]string{codebase.rootPath}, roots...) {
+		if exist, err := codebaseExists(root); err != nil || !exist {
+			continue
+		}
+
+		man, err := codebase.manProvider.Read(filepath.Join(root, metaDir, findManifestFile(root)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, project := range man.Projects {
+			if project.IsGit() {
+				remotes[project.Remote] = true
+			}
+		}
+
+		if root != codebase.rootPath {
+			existingRoots = append(existingRoots, root)
+		}
+	}
+
+	mirrors, err := codebase.cache.mirrors(codebase.repoProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for path, remote := range mirrors {
+		if remotes[remote] {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+
+		if remote == "" {
+			remote = path
+		}
+		removed = append(removed, remote)
+	}
+
+	sort.Strings(removed)
+
+	if err := codebase.cache.writeCodebases(append(existingRoots, codebase.rootPath)); err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// cloneProject clone the project at given path
+// If possible, the objects are borrowed from the project mirror
+func (codebase *codebase) cloneProject(path string, project manifest.Project) (repository.Repository, error) {
+	options := getCloneOptions(project)
+
+	// the cache is best-effort: the project is cloned from the remote anyway
+	if codebase.cache.dir != "" && useCache(project) {
+		if mirrorPath, err := codebase.cache.update(codebase.repoProvider, project.Remote); err == nil {
+			options.Reference = mirrorPath
+		}
+	}
+
+	return codebase.repoProvider.Clone(project.Remote, filepath.Join(codebase.rootPath, path), options)
+}
+
+// useCache returns true if the project should be mirrored
+// the shallow & partial clones are not, since a full mirror would defeat their purpose
+func useCache(project manifest.Project) bool {
+	if !project.IsGit() {
+		return false
+	}
+
+	return project.Clone == nil || (project.Clone.Depth == 0 && project.Clone.Filter == "")
+}
+
+// path returns the path of the mirror of given remote
+func (cache mirrorCache) path(remote string) string {
+	hash := sha256.Sum256([]byte(remote))
+
+	name := strings.TrimSuffix(strings.TrimRight(remote, "/"), ".git")
+	if i := strings.LastIndexAny(name, "/:"); i != -1 {
+		name = name[i+1:]
+	}
+
+	return filepath.Join(cache.dir, fmt.Sprintf("%s-%s.git", name, hex.EncodeToString(hash[:])[:12]))
+}
+
+// update create the mirror of given remote, or refresh it if it already exist
+func (cache mirrorCache) update(provider repository.Provider, remote string) (string, error) {
+	path := cache.path(remote)
+
+	lock, _ := mirrorLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		repo, err := provider.Open(path)
+		if err != nil {
+			return "", err
+		}
+
+		return path, repo.Fetch("origin")
+	}
+
+	if err := os.MkdirAll(cache.dir, 0750); err != nil {
+		return "", err
+	}
+
+	if _, err := provider.Mirror(remote, path); err != nil {
+		_ = os.RemoveAll(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// mirrors returns the remote of the mirrors, indexed by their path
+// the invalid mirrors have an empty remote
+func (cache mirrorCache) mirrors(provider repository.Provider) (map[string]string, error) {
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	mirrors := map[string]string{}
+	for _, file := range files {
+		if !file.IsDir() || !strings.HasSuffix(file.Name(), ".git") {
+			continue
+		}
+
+		path := filepath.Join(cache.dir, file.Name())
+		mirrors[path] = ""
+
+		if repo, err := provider.Open(path); err == nil {
+			if remote, err := repo.Remote("origin"); err == nil {
+				mirrors[path] = remote
+			}
+		}
+	}
+
+	return mirrors, nil
+}
+
+// register add the codebase located at given path to the codebases using the cache
+func (cache mirrorCache) register(rootPath string) error {
+	if cache.dir == "" {
+		return nil
+	}
+
+	roots, err := cache.codebases()
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if root == rootPath {
+			return nil
+		}
+	}
+
+	return cache.writeCodebases(append(roots, rootPath))
+}
+
+// codebases returns the path of the codebases using the cache
+func (cache mirrorCache) codebases() ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cache.dir, cacheCodebasesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var roots []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			roots = append(roots, line)
+		}
+	}
+
+	return roots, nil
+}
+
+func (cache mirrorCache) writeCodebases(roots []string) error {
+	if err := os.MkdirAll(cache.dir, 0750); err != nil {
+		return err
+	}
+
+	sort.Strings(roots)
+
+	return ioutil.WriteFile(filepath.Join(cache.dir, cacheCodebasesFile), []byte(strings.Join(roots, "\n")+"\n"), 0640)
+}
@@ -3,10 +3,15 @@ package codebase
 //go:generate mockgen -destination=../codebase_mock/codebase_mock.go -package=codebase_mock . Codebase,Provider
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/creekorful/srcode/internal/cmd"
+	"github.com/creekorful/srcode/internal/dotenv"
 	"github.com/creekorful/srcode/internal/manifest"
 	"github.com/creekorful/srcode/internal/repository"
+	"github.com/creekorful/srcode/internal/secret"
+	"github.com/creekorful/srcode/internal/source"
 	"github.com/fatih/color"
 	"golang.org/x/sync/errgroup"
 	"io"
@@ -15,12 +20,22 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 var (
 	// ErrPathTaken is returned when a project already exist at given path
 	ErrPathTaken = errors.New("a project already exist at given path")
+	// ErrScriptTimeout is returned when a script has exceeded its timeout
+	ErrScriptTimeout = errors.New("script has timed out")
+	// ErrScriptExist is returned when a script with the same name already exist
+	ErrScriptExist = errors.New("a script with the same name already exist")
+	// ErrScriptInUse is returned when trying to delete a script that is still referenced
+	ErrScriptInUse = errors.New("script is still referenced")
+	// ErrProjectDirty is returned when a project has uncommitted changes
+	ErrProjectDirty = errors.New("project has uncommitted changes")
 )
 
 // ProjectEntry map a codebase project entry (i.e the project alongside his codebase local path)
@@ -31,19 +46,81 @@ type ProjectEntry struct {
 	Repository repository.Repository
 }
 
+// CheckoutEntry is the result of the checkout of a project tracked branch
+type CheckoutEntry struct {
+	Path string
+	// Head is the branch the project was on
+	Head   string
+	Branch string
+	Err    error
+}
+
+// RemoteRewrite is the rewrite of a project remote url
+type RemoteRewrite struct {
+	Path string
+	Name string
+	Old  string
+	New  string
+}
+
 // Codebase is a collection of projects
 type Codebase interface {
 	Projects() (map[string]ProjectEntry, error)
 	Manifest() (manifest.Manifest, error)
-	Add(remote, path string, config map[string]string) (manifest.Project, error)
+	// Add clone the project (Remote, Branch, Remotes, Config & Clone are used) and add it to the codebase
+	Add(path string, project manifest.Project) (manifest.Project, error)
 	Sync(delete bool, addedChan chan<- ProjectEntry, deletedChan chan<- ProjectEntry) error
+	// ResolveManifest keep the local (or the remote) values of the manifest conflicts which have stopped Sync
+	// names restrict the resolution to the given conflicts (e.g `manifest.json: scripts > test`), all by default
+	// The remaining conflicts are returned, Sync has to be run again once they are all resolved
+	ResolveManifest(local bool, names []string) ([]manifest.Conflict, error)
+	// AbortSync cancel the synchronization stopped by the manifest conflicts, the local changes being kept
+	AbortSync() error
 	LocalPath() string
-	Run(scriptName string, args []string, writer io.Writer) error
+	Run(scriptName string, args []string, reader io.Reader, writer io.Writer) error
 	BulkGIT(args []string, writer io.Writer) error
+	CheckoutDefault() ([]CheckoutEntry, error)
+	StartTopic(name string, paths []string) ([]CheckoutEntry, error)
+	TopicStatus(name string) (Topic, []TopicProjectStatus, error)
+	SwitchTopic(name string) ([]CheckoutEntry, error)
+	FinishTopic(name string) ([]CheckoutEntry, error)
 	SetScript(name string, script []string, global bool) error
+	RmScript(name string, global bool) error
+	MoveScript(oldName, newName string, global bool) error
+	PromoteScript(name, globalName string) error
 	MoveProject(oldPath, newPath string) error
 	RmProject(path string, delete bool) error
-	SetHook(scriptName string) error
+	// SetRemote set the url of the project remote (origin being the project primary remote)
+	SetRemote(path, name, url string) error
+	// RewriteRemotes replace the from prefix of the projects remotes by to
+	RewriteRemotes(from, to string, dryRun bool) ([]RemoteRewrite, error)
+	// ConvertManifest rewrite the manifest using given format (json or yaml), and returns the new manifest file name
+	ConvertManifest(format string) (string, error)
+	// SplitManifest store each project & each global script of the manifest in its own file
+	SplitManifest() error
+	SetHook(hookType string, scriptNames []string) error
+	Hooks(all bool) ([]HookEntry, error)
+	RmHook(hookType string) error
+	ExecHook(hookType string, args []string, reader io.Reader, writer io.Writer) error
+	Env(withSecrets bool) (map[string]string, error)
+	SetSecret(name, value string, global bool) error
+	Secret(name string, global bool) (string, error)
+	RmSecret(name string, global bool) error
+	RunLogs(scriptName string) ([]RunLog, error)
+	RunOutput(id string) (string, error)
+	SaveSnapshot(name string, force bool) (Snapshot, error)
+	Snapshots() ([]Snapshot, error)
+	RestoreSnapshot(name string) ([]SnapshotEntry, error)
+	DiffSnapshots(from, to string) ([]SnapshotDiff, error)
+	AddWorktree(path, branch string) (Worktree, error)
+	// Worktrees returns the linked worktrees of the projects, indexed by the project path
+	Worktrees() (map[string][]Worktree, error)
+	RemoveWorktree(path, branch string, force bool) error
+	// Backup write (or update) a backup of the codebase in dir, using incremental git bundles
+	Backup(dir string) ([]BackupEntry, error)
+	UpdateCache() ([]CacheEntry, error)
+	GCCache() ([]string, error)
+	Watch(ctx context.Context, scriptName string, args []string, projects []string, writer io.Writer, callback func(WatchResult)) error
 }
 
 type codebase struct {
@@ -61,6 +138,13 @@ type codebase struct {
 	repoProvider repository.Provider
 	// The manifest provider (i.e the way we are reading/writing the manifest)
 	manProvider manifest.Provider
+	// The source provider (i.e the way we are materialising the projects which are not Git repositories)
+	sourceProvider source.Provider
+	// The mirrors used to speed up the clones
+	cache mirrorCache
+
+	// The keyring containing the keys to decrypt the secrets
+	keyring secret.Keyring
 }
 
 func (codebase *codebase) Projects() (map[string]ProjectEntry, error) {
@@ -72,16 +156,21 @@ func (codebase *codebase) Projects() (map[string]ProjectEntry, error) {
 	entries := map[string]ProjectEntry{}
 
 	for path, project := range man.Projects {
-		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
-		if err != nil {
-			return nil, err
+		entry := ProjectEntry{
+			Path:    path,
+			Project: project,
 		}
 
-		entries[path] = ProjectEntry{
-			Path:       path,
-			Project:    project,
-			Repository: repo,
+		// the projects which are not git repositories have no repository
+		if project.IsGit() {
+			repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+			if err != nil {
+				return nil, err
+			}
+			entry.Repository = repo
 		}
+
+		entries[path] = entry
 	}
 
 	return entries, nil
@@ -91,10 +180,9 @@ func (codebase *codebase) Manifest() (manifest.Manifest, error) {
 	return codebase.readManifest()
 }
 
-func (codebase *codebase) Add(remote, path string, config map[string]string) (manifest.Project, error) {
+func (codebase *codebase) Add(path string, project manifest.Project) (manifest.Project, error) {
 	if path == "" {
-		parts := strings.Split(remote, "/")
-		path = strings.TrimSuffix(parts[len(parts)-1], ".git")
+		path = getDefaultPath(project)
 	}
 
 	if codebase.localPath != "" {
@@ -112,25 +200,43 @@ func (codebase *codebase) Add(remote, path string, config map[string]string) (ma
 
 	// Make sure path is not taken
 	if _, exist := man.Projects[path]; exist {
-		return manifest.Project{}, fmt.Errorf("unable to add project %s: %w", remote, ErrPathTaken)
+		return manifest.Project{}, fmt.Errorf("unable to add project %s: %w", project.Location(), ErrPathTaken)
 	}
 
-	repo, err := codebase.repoProvider.Clone(remote, filepath.Join(codebase.rootPath, path))
-	if err != nil {
-		return manifest.Project{}, err
+	if project.Clone != nil && *project.Clone == (manifest.CloneOptions{}) {
+		project.Clone = nil
 	}
 
-	// Apply config
-	for key, value := range config {
-		if err := repo.SetConfig(key, value); err != nil {
+	if project.IsGit() {
+		project.Source = nil
+
+		repo, err := codebase.cloneProject(path, project)
+		if err != nil {
+			return manifest.Project{}, err
+		}
+
+		// Apply config
+		for key, value := range project.Config {
+			if err := repo.SetConfig(key, value); err != nil {
+				return manifest.Project{}, err
+			}
+		}
+
+		if err := setRemotes(repo, project.Remotes); err != nil {
 			return manifest.Project{}, err
 		}
+	} else if _, err := codebase.syncSource(path, project); err != nil {
+		return manifest.Project{}, err
 	}
 
 	// Update manifest
-	man.Projects[path] = manifest.Project{
-		Remote: remote,
-		Config: config,
+	man.Projects[path] = project
+
+	// Install the default hooks
+	if project.IsGit() {
+		if err := codebase.installHooks(man, path); err != nil {
+			return manifest.Project{}, err
+		}
 	}
 
 	if err := codebase.writeManifest(man); err != nil {
@@ -138,7 +244,7 @@ func (codebase *codebase) Add(remote, path string, config map[string]string) (ma
 	}
 
 	// Create commit
-	if err := codebase.repo.CommitFiles(fmt.Sprintf("Add %s to %s", remote, path), manifestFile); err != nil {
+	if err := codebase.repo.CommitFiles(fmt.Sprintf("Add %s to %s", project.Location(), path), codebase.manifestCommitFiles()...); err != nil {
 		return manifest.Project{}, err
 	}
 
@@ -155,15 +261,46 @@ func (codebase *codebase) Sync(delete bool, addedChan chan<- ProjectEntry, delet
 		}
 	}()
 
+	// the previous synchronization has been stopped by the manifest conflicts
+	if codebase.rebaseInProgress() {
+		if err := codebase.manifestConflicts(); err != nil {
+			return err
+		}
+
+		return ErrRebaseInProgress
+	}
+
 	// read manifest
 	previousMan, err := codebase.readManifest()
 	if err != nil {
 		return err
 	}
 
+	// the manifest changes are merged per project & per script
+	if err := codebase.registerMergeDriver(); err != nil {
+		return err
+	}
+
+	// the conflicts of a previous merge are not relevant anymore
+	if err := os.Remove(codebase.conflictsPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	// pull & push
 	// Allow to fail because may fail if not already pushed (todo better)
-	_ = codebase.repo.Pull("origin", "main")
+	if err := codebase.repo.Pull("origin", "main"); err != nil && codebase.rebaseInProgress() {
+		// the rebase is left stopped until the conflicts are resolved (see ResolveManifest)
+		if conflictsErr := codebase.manifestConflicts(); conflictsErr != nil {
+			return conflictsErr
+		}
+
+		// not a conflict of the manifest: restore the meta repository
+		if abortErr := codebase.repo.RawCmd([]string{"rebase", "--abort"}, nil, ioutil.Discard); abortErr != nil {
+			return abortErr
+		}
+
+		return fmt.Errorf("unable to merge the remote changes, the local changes have been kept: %w", err)
+	}
 
 	if err := codebase.repo.Push("origin", "main"); err != nil {
 		return err
@@ -203,7 +340,42 @@ func (codebase *codebase) Sync(delete bool, addedChan chan<- ProjectEntry, delet
 					}
 
 					// Clone the project (and don't break in case of error)
-					_, _ = codebase.repoProvider.Clone(project.Remote, filepath.Join(codebase.rootPath, p))
+					if project.IsGit() {
+						_, _ = codebase.cloneProject(p, project)
+					} else {
+						_, _ = codebase.syncSource(p, project)
+					}
+				} else if !project.IsGit() {
+					// Update the project if its source has changed (e.g. new archive version)
+					if !reflect.DeepEqual(previousMan.Projects[p].Source, project.Source) {
+						if _, err := codebase.syncSource(p, project); err != nil {
+							return err
+						}
+					}
+				} else {
+					previousProject := previousMan.Projects[p]
+					removedRemotes := removedRemotes(previousProject.Remotes, project.Remotes)
+
+					if previousProject.Remote != project.Remote || len(removedRemotes) > 0 {
+						repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, p))
+						if err != nil {
+							return err
+						}
+
+						// Propagate the primary remote change (e.g. after a `srcode remote rewrite`)
+						if previousProject.Remote != project.Remote {
+							if err := setRemotes(repo, map[string]string{"origin": project.Remote}); err != nil {
+								return err
+							}
+						}
+
+						// Remove the remotes deleted from the manifest
+						for _, name := range removedRemotes {
+							if err := repo.RemoveRemote(name); err != nil && !errors.Is(err, repository.ErrRemoteNotFound) {
+								return err
+							}
+						}
+					}
 				}
 
 				// (Re-)Configure the project
@@ -253,13 +425,24 @@ func (codebase *codebase) LocalPath() string {
 	return codebase.localPath
 }
 
-func (codebase *codebase) Run(scriptName string, args []string, writer io.Writer) error {
+func (codebase *codebase) Run(scriptName string, args []string, reader io.Reader, writer io.Writer) error {
+	return codebase.run(context.Background(), codebase.localPath, scriptName, args, reader, writer)
+}
+
+// run execute given script of the project located at projectPath
+// the script is killed once ctx is done
+func (codebase *codebase) run(ctx context.Context, projectPath, scriptName string, args []string, reader io.Reader, writer io.Writer) error {
 	man, err := codebase.readManifest()
 	if err != nil {
 		return err
 	}
 
-	scriptVal, err := man.GetScript(codebase.localPath, scriptName)
+	scriptVal, err := man.GetScript(projectPath, scriptName)
+	if err != nil {
+		return fmt.Errorf("error while running script %s: %w", scriptName, err)
+	}
+
+	timeout, err := man.GetScriptTimeout(projectPath, scriptName)
 	if err != nil {
 		return fmt.Errorf("error while running script %s: %w", scriptName, err)
 	}
@@ -280,11 +463,76 @@ func (codebase *codebase) Run(scriptName string, args []string, writer io.Writer
 	cmdArgs := []string{path}
 	cmdArgs = append(cmdArgs, args...)
 
-	cmd := exec.Command("sh", cmdArgs...)
-	cmd.Stdout = writer
-	cmd.Stderr = writer
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	env, err := codebase.getEnv(man, projectPath, true)
+	if errors.Is(err, secret.ErrKeyNotFound) {
+		// the key is not available on this machine: the scripts not using the secrets should still work
+		_, _ = fmt.Fprintf(writer, "warning: the secrets are not available (%s), running %s without them\n", err, scriptName)
+		env, err = codebase.getEnv(man, projectPath, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Record the execution
+	runLog := RunLog{
+		Project:   projectPath,
+		Script:    scriptName,
+		Args:      args,
+		StartedAt: time.Now(),
+	}
+
+	if repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, projectPath)); err == nil {
+		runLog.Head, _ = repo.HeadCommit()
+	}
+
+	// the recording is best-effort: the script is executed even if it cannot be recorded
+	output := writer
+	logFile, logErr := codebase.createRunLog(&runLog)
+	if logErr != nil {
+		_, _ = fmt.Fprintf(writer, "warning: unable to record the execution of %s: %s\n", scriptName, logErr)
+	} else {
+		defer logFile.Close()
+
+		if cmd.IsTerminal(writer) {
+			// capturing the output would take the terminal from the script (colors, progress bars, ...)
+			_, _ = io.WriteString(logFile, runOutputNotRecorded)
+		} else {
+			output = io.MultiWriter(writer, logFile)
+		}
+	}
+
+	command := exec.Command("sh", cmdArgs...)
+	command.Env = append(os.Environ(), formatEnv(env)...)
+	command.Stdin = reader
+	command.Stdout = output
+	if projectPath != codebase.localPath {
+		// scripts of another project are executed from its root directory
+		command.Dir = filepath.Join(codebase.rootPath, projectPath)
+	}
+	command.Stderr = command.Stdout
+
+	err = cmd.ExecInteractive(ctx, command)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("error while running script %s: %w (%s)", scriptName, ErrScriptTimeout, timeout)
+	}
+
+	if logErr == nil {
+		runLog.Duration = time.Since(runLog.StartedAt)
+		runLog.ExitCode = getRunExitCode(err)
+
+		// the script error (and exit code) takes precedence
+		if logErr := codebase.writeRunLog(runLog); logErr != nil {
+			_, _ = fmt.Fprintf(writer, "warning: unable to record the execution of %s: %s\n", scriptName, logErr)
+		}
+	}
 
-	return cmd.Run()
+	return err
 }
 
 func (codebase *codebase) BulkGIT(args []string, writer io.Writer) error {
@@ -296,15 +544,25 @@ func (codebase *codebase) BulkGIT(args []string, writer io.Writer) error {
 	sepStyle := color.New(color.Bold, color.FgHiYellow).Sprint("===")
 	pathStyle := color.New(color.Bold, color.FgHiWhite)
 
-	for path := range man.Projects {
+	for path, project := range man.Projects {
+		if !project.IsGit() {
+			continue
+		}
+
 		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
 		if err != nil {
 			return err
 		}
 
+		// the commands are executed with the project environment (without the secrets)
+		env, err := codebase.getEnv(man, path, false)
+		if err != nil {
+			return err
+		}
+
 		_, _ = io.WriteString(writer, fmt.Sprintf("%s /%s %s\n\n", sepStyle, pathStyle.Sprint(path), sepStyle))
 
-		if err := repo.RawCmd(args, writer); err != nil {
+		if err := repo.RawCmd(args, formatEnv(env), writer); err != nil {
 			return err
 		}
 
@@ -314,6 +572,61 @@ func (codebase *codebase) BulkGIT(args []string, writer io.Writer) error {
 	return nil
 }
 
+func (codebase *codebase) CheckoutDefault() ([]CheckoutEntry, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for path, project := range man.Projects {
+		if project.Branch != "" && project.IsGit() {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	return codebase.checkoutProjects(paths, func(path string) string {
+		return man.Projects[path].Branch
+	})
+}
+
+// checkoutProjects switch the projects to their branch, the projects having uncommitted changes are skipped
+func (codebase *codebase) checkoutProjects(paths []string, branchFunc func(path string) string) ([]CheckoutEntry, error) {
+	var entries []CheckoutEntry
+	for _, path := range paths {
+		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+		if err != nil {
+			return nil, err
+		}
+
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := CheckoutEntry{Path: path, Head: head, Branch: branchFunc(path)}
+		if head == entry.Branch {
+			continue
+		}
+
+		dirty, err := repo.IsDirty()
+		if err != nil {
+			return nil, err
+		}
+
+		if dirty {
+			entry.Err = ErrProjectDirty
+		} else {
+			entry.Err = repo.Checkout(entry.Branch)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
 func (codebase *codebase) SetScript(name string, script []string, global bool) error {
 	man, err := codebase.readManifest()
 	if err != nil {
@@ -334,7 +647,7 @@ func (codebase *codebase) SetScript(name string, script []string, global bool) e
 
 		if err := codebase.repo.CommitFiles(
 			fmt.Sprintf("Add global script `%s`", name),
-			manifestFile,
+			codebase.manifestCommitFiles()...,
 		); err != nil {
 			return err
 		}
@@ -361,7 +674,7 @@ func (codebase *codebase) SetScript(name string, script []string, global bool) e
 
 	if err := codebase.repo.CommitFiles(
 		fmt.Sprintf("Add script `%s` to %s", name, codebase.localPath),
-		manifestFile,
+		codebase.manifestCommitFiles()...,
 	); err != nil {
 		return err
 	}
@@ -369,6 +682,213 @@ func (codebase *codebase) SetScript(name string, script []string, global bool) e
 	return nil
 }
 
+func (codebase *codebase) RmScript(name string, global bool) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	// This is a global script
+	if global {
+		if _, exist := man.Scripts[name]; !exist {
+			return manifest.ErrScriptNotFound
+		}
+
+		// Make sure the script is not used anymore
+		if refs := man.GetScriptReferences(name); len(refs) > 0 {
+			return fmt.Errorf("unable to remove global script %s (used by %s): %w", name, strings.Join(refs, ", "), ErrScriptInUse)
+		}
+		for _, defaultHook := range man.DefaultHooks {
+			for hookType, scripts := range defaultHook.Hooks {
+				for _, script := range scripts {
+					if script == "@"+name {
+						return fmt.Errorf("unable to remove global script %s (used as default %s hook): %w", name, hookType, ErrScriptInUse)
+					}
+				}
+			}
+		}
+
+		delete(man.Scripts, name)
+		delete(man.Timeouts, name)
+
+		if err := codebase.writeManifest(man); err != nil {
+			return err
+		}
+
+		return codebase.repo.CommitFiles(fmt.Sprintf("Remove global script `%s`", name), codebase.manifestCommitFiles()...)
+	}
+
+	project, exist := man.Projects[codebase.localPath]
+	if !exist {
+		return manifest.ErrNoProjectFound
+	}
+
+	if _, exist := project.Scripts[name]; !exist {
+		return manifest.ErrScriptNotFound
+	}
+
+	// Make sure the script is not used as hook
+	hooks, err := man.GetHooks(codebase.localPath)
+	if err != nil {
+		return err
+	}
+	for hookType, scripts := range hooks {
+		for _, script := range scripts {
+			if script == name {
+				return fmt.Errorf("unable to remove script %s (used as %s hook): %w", name, hookType, ErrScriptInUse)
+			}
+		}
+	}
+
+	delete(project.Scripts, name)
+	delete(project.Timeouts, name)
+	man.Projects[codebase.localPath] = project
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(
+		fmt.Sprintf("Remove script `%s` from %s", name, codebase.localPath),
+		codebase.manifestCommitFiles()...,
+	)
+}
+
+func (codebase *codebase) MoveScript(oldName, newName string, global bool) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	// This is a global script
+	if global {
+		script, exist := man.Scripts[oldName]
+		if !exist {
+			return manifest.ErrScriptNotFound
+		}
+
+		if _, exist := man.Scripts[newName]; exist {
+			return ErrScriptExist
+		}
+
+		delete(man.Scripts, oldName)
+		man.Scripts[newName] = script
+
+		if timeout, exist := man.Timeouts[oldName]; exist {
+			delete(man.Timeouts, oldName)
+			man.Timeouts[newName] = timeout
+		}
+
+		// Update the aliases and the hooks
+		for _, path := range man.GetScriptReferences(oldName) {
+			project := man.Projects[path]
+			for name, scriptVal := range project.Scripts {
+				if len(scriptVal) == 1 && scriptVal[0] == "@"+oldName {
+					project.Scripts[name] = []string{"@" + newName}
+				}
+			}
+			renameHookScript(project.Hooks, "@"+oldName, "@"+newName)
+			man.Projects[path] = project
+		}
+		for _, defaultHook := range man.DefaultHooks {
+			renameHookScript(defaultHook.Hooks, "@"+oldName, "@"+newName)
+		}
+
+		if err := codebase.writeManifest(man); err != nil {
+			return err
+		}
+
+		return codebase.repo.CommitFiles(
+			fmt.Sprintf("Rename global script `%s` to `%s`", oldName, newName),
+			codebase.manifestCommitFiles()...,
+		)
+	}
+
+	project, exist := man.Projects[codebase.localPath]
+	if !exist {
+		return manifest.ErrNoProjectFound
+	}
+
+	script, exist := project.Scripts[oldName]
+	if !exist {
+		return manifest.ErrScriptNotFound
+	}
+
+	if _, exist := project.Scripts[newName]; exist {
+		return ErrScriptExist
+	}
+
+	delete(project.Scripts, oldName)
+	project.Scripts[newName] = script
+
+	if timeout, exist := project.Timeouts[oldName]; exist {
+		delete(project.Timeouts, oldName)
+		project.Timeouts[newName] = timeout
+	}
+
+	renameHookScript(project.Hooks, oldName, newName)
+
+	man.Projects[codebase.localPath] = project
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(
+		fmt.Sprintf("Rename script `%s` to `%s` in %s", oldName, newName, codebase.localPath),
+		codebase.manifestCommitFiles()...,
+	)
+}
+
+func (codebase *codebase) PromoteScript(name, globalName string) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	if globalName == "" {
+		globalName = name
+	}
+
+	project, exist := man.Projects[codebase.localPath]
+	if !exist {
+		return manifest.ErrNoProjectFound
+	}
+
+	script, exist := project.Scripts[name]
+	if !exist {
+		return manifest.ErrScriptNotFound
+	}
+
+	// Script is already an alias
+	if len(script) == 1 && strings.HasPrefix(script[0], "@") {
+		return fmt.Errorf("unable to promote script %s (already an alias of %s): %w", name, script[0], ErrScriptExist)
+	}
+
+	// Make sure we are not overriding an existing (different) global script
+	if globalScript, exist := man.Scripts[globalName]; exist && !reflect.DeepEqual(globalScript, script) {
+		return fmt.Errorf("unable to promote script %s to global script %s: %w", name, globalName, ErrScriptExist)
+	}
+
+	if man.Scripts == nil {
+		man.Scripts = map[string][]string{}
+	}
+	man.Scripts[globalName] = script
+
+	// The project timeout (if any) is kept as-is since it take precedence
+	project.Scripts[name] = []string{"@" + globalName}
+	man.Projects[codebase.localPath] = project
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(
+		fmt.Sprintf("Promote script `%s` of %s to global script `%s`", name, codebase.localPath, globalName),
+		codebase.manifestCommitFiles()...,
+	)
+}
+
 func (codebase *codebase) MoveProject(oldPath, newPath string) error {
 	man, err := codebase.readManifest()
 	if err != nil {
@@ -411,7 +931,7 @@ func (codebase *codebase) MoveProject(oldPath, newPath string) error {
 	}
 
 	msg := fmt.Sprintf("Moved %s from %s to %s", project.Remote, oldPath, newPath)
-	if err := codebase.repo.CommitFiles(msg, manifestFile); err != nil {
+	if err := codebase.repo.CommitFiles(msg, codebase.manifestCommitFiles()...); err != nil {
 		return err
 	}
 
@@ -436,7 +956,7 @@ func (codebase *codebase) RmProject(path string, shouldDelete bool) error {
 		return err
 	}
 
-	if err := codebase.repo.CommitFiles(fmt.Sprintf("Remove %s", path), manifestFile); err != nil {
+	if err := codebase.repo.CommitFiles(fmt.Sprintf("Remove %s", path), codebase.manifestCommitFiles()...); err != nil {
 		return err
 	}
 
@@ -449,35 +969,150 @@ func (codebase *codebase) RmProject(path string, shouldDelete bool) error {
 	return nil
 }
 
-func (codebase *codebase) SetHook(scriptName string) error {
+func (codebase *codebase) SetRemote(path, name, url string) error {
 	man, err := codebase.readManifest()
 	if err != nil {
 		return err
 	}
 
-	script, err := man.GetScript(codebase.localPath, scriptName)
+	path = filepath.Join(codebase.localPath, path)
+
+	if err := gitProject(man, path); err != nil {
+		return err
+	}
+	project := man.Projects[path]
+
+	if name == "origin" {
+		project.Remote = url
+	} else {
+		if project.Remotes == nil {
+			project.Remotes = map[string]string{}
+		}
+		project.Remotes[name] = url
+	}
+	man.Projects[path] = project
+
+	repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return err
+	}
+
+	if err := setRemotes(repo, map[string]string{name: url}); err != nil {
+		return err
+	}
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(fmt.Sprintf("Set %s remote of %s to %s", name, path, url), codebase.manifestCommitFiles()...)
+}
+
+func (codebase *codebase) RewriteRemotes(from, to string, dryRun bool) ([]RemoteRewrite, error) {
+	man, err := codebase.readManifest()
 	if err != nil {
-		return fmt.Errorf("error while setting hook %s: %w", scriptName, err)
+		return nil, err
+	}
+
+	var rewrites []RemoteRewrite
+	for path, project := range man.Projects {
+		if !project.IsGit() {
+			continue
+		}
+
+		remotes := map[string]string{"origin": project.Remote}
+		for name, url := range project.Remotes {
+			remotes[name] = url
+		}
+
+		for name, url := range remotes {
+			if strings.HasPrefix(url, from) {
+				rewrites = append(rewrites, RemoteRewrite{Path: path, Name: name, Old: url, New: to + strings.TrimPrefix(url, from)})
+			}
+		}
+	}
+
+	sort.Slice(rewrites, func(i, j int) bool {
+		if rewrites[i].Path != rewrites[j].Path {
+			return rewrites[i].Path < rewrites[j].Path
+		}
+		return rewrites[i].Name < rewrites[j].Name
+	})
+
+	if dryRun || len(rewrites) == 0 {
+		return rewrites, nil
+	}
+
+	for _, rewrite := range rewrites {
+		project := man.Projects[rewrite.Path]
+		if rewrite.Name == "origin" {
+			project.Remote = rewrite.New
+		} else {
+			project.Remotes[rewrite.Name] = rewrite.New
+		}
+		man.Projects[rewrite.Path] = project
+	}
+
+	if err := codebase.writeManifest(man); err != nil {
+		return nil, err
+	}
+
+	if err := codebase.repo.CommitFiles(fmt.Sprintf("Rewrite remotes from %s to %s", from, to), codebase.manifestCommitFiles()...); err != nil {
+		return nil, err
+	}
+
+	// update the local repositories (the ones not cloned yet will use the new urls)
+	for _, rewrite := range rewrites {
+		repoPath := filepath.Join(codebase.rootPath, rewrite.Path)
+		if !codebase.repoProvider.Exists(repoPath) {
+			continue
+		}
+
+		repo, err := codebase.repoProvider.Open(repoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setRemotes(repo, map[string]string{rewrite.Name: rewrite.New}); err != nil {
+			return nil, err
+		}
 	}
 
-	// copy the script to .git/hooks directory
-	f, err := os.OpenFile(filepath.Join(codebase.rootPath, codebase.localPath, ".git", "hooks", "pre-push"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0750)
+	return rewrites, nil
+}
+
+func (codebase *codebase) SetHook(hookType string, scriptNames []string) error {
+	if !IsValidHookType(hookType) {
+		return fmt.Errorf("error while setting hook %s: %w", hookType, ErrInvalidHookType)
+	}
+
+	man, err := codebase.readManifest()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	// write the content
-	if _, err := io.WriteString(f, strings.Join(script, "\n")); err != nil {
+	if err := gitProject(man, codebase.localPath); err != nil {
 		return err
 	}
+	project := man.Projects[codebase.localPath]
+
+	// make sure the scripts exist
+	for _, scriptName := range scriptNames {
+		if _, err := man.GetScript(codebase.localPath, scriptName); err != nil {
+			return fmt.Errorf("error while setting hook %s: %w", hookType, err)
+		}
+	}
+
+	// install the hook shim in .git/hooks directory
+	if err := codebase.installHook(codebase.localPath, hookType); err != nil {
+		return fmt.Errorf("error while setting hook %s: %w", hookType, err)
+	}
 
 	// Update the manifest
-	project, exists := man.Projects[codebase.localPath]
-	if !exists {
-		return manifest.ErrNoProjectFound // should not happen there
+	if project.Hooks == nil {
+		project.Hooks = map[string][]string{}
 	}
-	project.Hook = scriptName
+	project.Hooks[hookType] = scriptNames
 	man.Projects[codebase.localPath] = project
 
 	if err := codebase.writeManifest(man); err != nil {
@@ -485,15 +1120,157 @@ func (codebase *codebase) SetHook(scriptName string) error {
 	}
 
 	// Commit the changes
-	if err := codebase.repo.CommitFiles(fmt.Sprintf("Set pre-push hook `%s` for %s", scriptName, codebase.localPath), manifestFile); err != nil {
+	msg := fmt.Sprintf("Set %s hook `%s` for %s", hookType, strings.Join(scriptNames, "`, `"), codebase.localPath)
+	if err := codebase.repo.CommitFiles(msg, codebase.manifestCommitFiles()...); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+func (codebase *codebase) Env(withSecrets bool) (map[string]string, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return codebase.getEnv(man, codebase.localPath, withSecrets)
+}
+
+func (codebase *codebase) SetSecret(name, value string, global bool) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	project, exist := man.Projects[codebase.localPath]
+	if !exist && !global {
+		return manifest.ErrNoProjectFound
+	}
+
+	// Generate the codebase key if needed
+	if man.SecretsKey == "" {
+		publicKey, err := codebase.keyring.Generate()
+		if err != nil {
+			return err
+		}
+
+		man.SecretsKey = publicKey
+	}
+
+	encrypted, err := secret.Encrypt(man.SecretsKey, value)
+	if err != nil {
+		return err
+	}
+
+	var msg string
+	if global {
+		if man.Secrets == nil {
+			man.Secrets = map[string]string{}
+		}
+		man.Secrets[name] = encrypted
+		msg = fmt.Sprintf("Set global secret `%s`", name)
+	} else {
+		if project.Secrets == nil {
+			project.Secrets = map[string]string{}
+		}
+		project.Secrets[name] = encrypted
+		man.Projects[codebase.localPath] = project
+		msg = fmt.Sprintf("Set secret `%s` for %s", name, codebase.localPath)
+	}
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(msg, codebase.manifestCommitFiles()...)
+}
+
+func (codebase *codebase) Secret(name string, global bool) (string, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return "", err
+	}
+
+	secrets := man.Secrets
+	if !global {
+		project, exist := man.Projects[codebase.localPath]
+		if !exist {
+			return "", manifest.ErrNoProjectFound
+		}
+
+		secrets = project.Secrets
+	}
+
+	value, exist := secrets[name]
+	if !exist {
+		return "", manifest.ErrSecretNotFound
+	}
+
+	return codebase.keyring.Decrypt(man.SecretsKey, value)
+}
+
+func (codebase *codebase) RmSecret(name string, global bool) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	var msg string
+	if global {
+		if _, exist := man.Secrets[name]; !exist {
+			return manifest.ErrSecretNotFound
+		}
+
+		delete(man.Secrets, name)
+		msg = fmt.Sprintf("Remove global secret `%s`", name)
+	} else {
+		project, exist := man.Projects[codebase.localPath]
+		if !exist {
+			return manifest.ErrNoProjectFound
+		}
+
+		if _, exist := project.Secrets[name]; !exist {
+			return manifest.ErrSecretNotFound
+		}
+
+		delete(project.Secrets, name)
+		man.Projects[codebase.localPath] = project
+		msg = fmt.Sprintf("Remove secret `%s` from %s", name, codebase.localPath)
+	}
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(msg, codebase.manifestCommitFiles()...)
+}
+
+// manifestName returns the name of the manifest file (inside the meta directory)
+func (codebase *codebase) manifestName() string {
+	return findManifestFile(codebase.rootPath)
+}
+
+// manifestCommitFiles returns the files (relative to the meta repository) to commit when the manifest is updated
+// i.e the manifest itself, and the projects & scripts directories of the split layout
+func (codebase *codebase) manifestCommitFiles() []string {
+	files := []string{codebase.manifestName()}
+
+	for _, dir := range []string{manifest.ProjectsDir, manifest.ScriptsDir} {
+		if _, err := os.Stat(filepath.Join(codebase.rootPath, metaDir, dir)); err == nil {
+			files = append(files, dir)
+		}
+	}
+
+	return files
+}
+
+func (codebase *codebase) manifestPath() string {
+	return filepath.Join(codebase.rootPath, metaDir, codebase.manifestName())
+}
+
 func (codebase *codebase) readManifest() (manifest.Manifest, error) {
-	man, err := codebase.manProvider.Read(filepath.Join(filepath.Join(codebase.rootPath, metaDir, manifestFile)))
+	man, err := codebase.manProvider.Read(codebase.manifestPath())
 	if err != nil {
 		return manifest.Manifest{}, err
 	}
@@ -502,7 +1279,7 @@ func (codebase *codebase) readManifest() (manifest.Manifest, error) {
 }
 
 func (codebase *codebase) writeManifest(man manifest.Manifest) error {
-	return codebase.manProvider.Write(filepath.Join(filepath.Join(codebase.rootPath, metaDir, manifestFile)), man)
+	return codebase.manProvider.Write(codebase.manifestPath(), man)
 }
 
 func (codebase *codebase) configureProject(man manifest.Manifest, path string) error {
@@ -511,8 +1288,13 @@ func (codebase *codebase) configureProject(man manifest.Manifest, path string) e
 		return manifest.ErrNoProjectFound
 	}
 
-	// (Re-)Apply the configuration
-	if len(project.Config) > 0 {
+	// the configuration & the hooks only apply to git repositories
+	if !project.IsGit() {
+		return nil
+	}
+
+	// (Re-)Apply the configuration & the remotes
+	if len(project.Config) > 0 || len(project.Remotes) > 0 {
 		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
 		if err != nil {
 			return err
@@ -523,20 +1305,145 @@ func (codebase *codebase) configureProject(man manifest.Manifest, path string) e
 				return err
 			}
 		}
+
+		if err := setRemotes(repo, project.Remotes); err != nil {
+			return err
+		}
 	}
 
-	// Apply hook if any
-	if project.Hook != "" {
-		script, err := man.GetScript(path, project.Hook)
-		if err == nil {
-			f, err := os.OpenFile(filepath.Join(codebase.rootPath, path, ".git", "hooks", "pre-push"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0750)
-			if err != nil {
+	// Apply hooks if any
+	if err := codebase.installHooks(man, path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getDefaultPath returns the path of the project when none is given (i.e the name of the repository or of the directory)
+func getDefaultPath(project manifest.Project) string {
+	parts := strings.Split(strings.TrimRight(project.Location(), "/"), "/")
+	name := parts[len(parts)-1]
+
+	if project.SourceType() == manifest.SourceArchive {
+		for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+			if strings.HasSuffix(name, ext) {
+				return strings.TrimSuffix(name, ext)
+			}
+		}
+	}
+
+	return strings.TrimSuffix(name, ".git")
+}
+
+// getEnv returns the environment variables of the project located at given path
+// The manifest variables are overridden by the secrets (if requested), then
+// by the project .env file (if any) and finally by the srcode variables
+func (codebase *codebase) getEnv(man manifest.Manifest, path string, withSecrets bool) (map[string]string, error) {
+	env, err := man.GetEnv(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if withSecrets {
+		for _, secrets := range []map[string]string{man.Secrets, man.Projects[path].Secrets} {
+			for key, value := range secrets {
+				decrypted, err := codebase.keyring.Decrypt(man.SecretsKey, value)
+				if err != nil {
+					return nil, fmt.Errorf("error while decrypting secret %s: %w", key, err)
+				}
+
+				env[key] = decrypted
+			}
+		}
+	}
+
+	dotEnv, err := dotenv.ReadFile(filepath.Join(codebase.rootPath, path, dotEnvFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error while reading %s: %w", dotEnvFile, err)
+	}
+	for key, value := range dotEnv {
+		env[key] = value
+	}
+
+	env["SRCODE_ROOT"] = codebase.rootPath
+	env["SRCODE_PROJECT"] = path
+	env["SRCODE_REMOTE"] = man.Projects[path].Remote
+
+	return env, nil
+}
+
+// formatEnv format given environment variables using the KEY=VALUE syntax
+func formatEnv(env map[string]string) []string {
+	var res []string
+	for key, value := range env {
+		res = append(res, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// getRunExitCode returns the exit code of a script execution
+func getRunExitCode(err error) int {
+	if errors.Is(err, ErrScriptTimeout) {
+		return 124 // use same convention as timeout(1)
+	}
+
+	if code := cmd.GetExitCode(err); code >= 0 {
+		return code
+	}
+
+	return 1
+}
+
+// getCloneOptions returns the options to use when cloning given project
+func getCloneOptions(project manifest.Project) repository.CloneOptions {
+	if project.Clone == nil {
+		return repository.CloneOptions{Branch: project.Branch}
+	}
+
+	return repository.CloneOptions{
+		Depth:        project.Clone.Depth,
+		Filter:       project.Clone.Filter,
+		Branch:       project.Branch,
+		SingleBranch: project.Clone.SingleBranch,
+		Submodules:   project.Clone.Submodules,
+		SkipLFS:      project.Clone.SkipLFS,
+	}
+}
+
+// removedRemotes returns the names of the previous remotes which are not in the current ones
+func removedRemotes(previous, current map[string]string) []string {
+	var names []string
+	for name := range previous {
+		if _, exist := current[name]; !exist {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// setRemotes add the remotes to the repository, or update their url if they already exist
+func setRemotes(repo repository.Repository, remotes map[string]string) error {
+	for name, url := range remotes {
+		current, err := repo.Remote(name)
+		if err != nil {
+			if !errors.Is(err, repository.ErrRemoteNotFound) {
 				return err
 			}
-			defer f.Close()
 
-			// write the content
-			if _, err := io.WriteString(f, strings.Join(script, "\n")); err != nil {
+			if err := repo.AddRemote(name, url); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if current != url {
+			if err := repo.SetConfig(fmt.Sprintf("remote.%s.url", name), url); err != nil {
 				return err
 			}
 		}
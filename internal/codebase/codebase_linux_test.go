@@ -0,0 +1,89 @@
+package codebase
+
+import (
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"github.com/creekorful/srcode/internal/manifest_mock"
+	"github.com/creekorful/srcode/internal/repository_mock"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestCodebase_Run_Terminal(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+
+	rootPath := t.TempDir()
+	resultPath := filepath.Join(t.TempDir(), "result")
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     rootPath,
+		localPath:    "test",
+	}
+
+	repoProviderMock.EXPECT().Open(filepath.Join(rootPath, "test")).Return(nil, fmt.Errorf("not a repository"))
+	manProviderMock.EXPECT().
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test": {Scripts: map[string][]string{"tty": {"if [ -t 1 ]; then echo tty > $1; else echo notty > $1; fi"}}},
+			},
+		}, nil)
+
+	// the script should keep the terminal while its execution is recorded
+	tty := openPty(t)
+	if err := codebase.Run("tty", []string{resultPath}, nil, tty); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(resultPath); err != nil || strings.TrimSpace(string(b)) != "tty" {
+		t.Errorf("the script should be executed in the terminal, got %s (%v)", b, err)
+	}
+
+	runLogs, err := codebase.RunLogs("tty")
+	if err != nil || len(runLogs) != 1 {
+		t.Fatalf("the execution should be recorded: %v (%v)", runLogs, err)
+	}
+	if output, err := codebase.RunOutput(runLogs[0].ID); err != nil || output != runOutputNotRecorded {
+		t.Errorf("got %s (%v) want %s", output, err, runOutputNotRecorded)
+	}
+}
+
+// openPty returns the terminal side of a new pseudo terminal
+func openPty(t *testing.T) *os.File {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals are not available: %s", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Skipf("unable to unlock the pseudo terminal: %s", errno)
+	}
+
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Skipf("unable to get the pseudo terminal number: %s", errno)
+	}
+
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("unable to open the pseudo terminal: %s", err)
+	}
+	t.Cleanup(func() { _ = tty.Close() })
+
+	return tty
+}
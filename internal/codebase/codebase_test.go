@@ -1,22 +1,70 @@
 package codebase
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/creekorful/srcode/internal/manifest"
 	"github.com/creekorful/srcode/internal/manifest_mock"
+	"github.com/creekorful/srcode/internal/repository"
 	"github.com/creekorful/srcode/internal/repository_mock"
+	"github.com/creekorful/srcode/internal/secret"
+	"github.com/creekorful/srcode/internal/source"
 	"github.com/golang/mock/gomock"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// the test binary is registered as merge driver of the manifest by Sync (see registerMergeDriver)
+	if len(os.Args) == 9 && os.Args[1] == "manifest" && os.Args[2] == "merge" {
+		os.Exit(mergeManifestDriver(os.Args[4], os.Args[5:]))
+	}
+
+	os.Exit(m.Run())
+}
+
+// mergeManifestDriver behave like `srcode manifest merge --report <report> <base> <ours> <theirs> <path>`
+func mergeManifestDriver(report string, args []string) int {
+	var versions [][]byte
+	for _, file := range args[:3] {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 2
+		}
+		versions = append(versions, b)
+	}
+
+	b, conflicts, err := manifest.MergeFile(args[3], versions[0], versions[1], versions[2])
+	if err != nil {
+		return 2
+	}
+	if err := ioutil.WriteFile(args[1], b, 0640); err != nil {
+		return 2
+	}
+
+	if len(conflicts) > 0 {
+		if err := manifest.ReportConflicts(report, conflicts); err != nil {
+			return 2
+		}
+		return 1
+	}
+
+	return 0
+}
+
 func TestCodebase_Projects(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -110,7 +158,7 @@ func TestCodebase_Add(t *testing.T) {
 		currentRepoMock := repository_mock.NewMockRepository(mockCtrl)
 
 		repoProviderMock.EXPECT().
-			Clone(test.repoRemote, filepath.Join(codebase.rootPath, test.localPath)).
+			Clone(test.repoRemote, filepath.Join(codebase.rootPath, test.localPath), repository.CloneOptions{}).
 			Return(currentRepoMock, nil)
 
 		manProviderMock.EXPECT().
@@ -143,9 +191,13 @@ func TestCodebase_Add(t *testing.T) {
 			SetConfig("user.email", "alois@micard.lu").
 			Return(nil)
 
-		project, err := codebase.Add(test.repoRemote, test.argPath, map[string]string{
-			"user.name":  "Aloïs Micard",
-			"user.email": "alois@micard.lu",
+		project, err := codebase.Add(test.argPath, manifest.Project{
+			Remote: test.repoRemote,
+			Config: map[string]string{
+				"user.name":  "Aloïs Micard",
+				"user.email": "alois@micard.lu",
+			},
+			Clone: &manifest.CloneOptions{},
 		})
 		if err != nil {
 			t.Fail()
@@ -163,6 +215,63 @@ func TestCodebase_Add(t *testing.T) {
 	}
 }
 
+func TestCodebase_Add_CloneOptions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+	linuxRepoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		repoProvider: repoProviderMock,
+		repo:         repoMock,
+		manProvider:  manProviderMock,
+		rootPath:     "/home/creekorful",
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).
+		Return(manifest.Manifest{}, nil)
+
+	repoProviderMock.EXPECT().
+		Clone("git@github.com:torvalds/linux.git", filepath.Join(codebase.rootPath, "linux"),
+			repository.CloneOptions{Filter: "blob:none", Branch: "master", SingleBranch: true}).
+		Return(linuxRepoMock, nil)
+
+	linuxRepoMock.EXPECT().Remote("upstream").Return("", fmt.Errorf("no such remote: %w", repository.ErrRemoteNotFound))
+	linuxRepoMock.EXPECT().AddRemote("upstream", "git@github.com:linux/linux.git").Return(nil)
+
+	manProviderMock.EXPECT().
+		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile), manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"linux": {
+					Remote:  "git@github.com:torvalds/linux.git",
+					Remotes: map[string]string{"upstream": "git@github.com:linux/linux.git"},
+					Branch:  "master",
+					Clone:   &manifest.CloneOptions{Filter: "blob:none", SingleBranch: true},
+				},
+			},
+		}).
+		Return(nil)
+
+	repoMock.EXPECT().CommitFiles("Add git@github.com:torvalds/linux.git to linux", manifestFile).Return(nil)
+
+	project, err := codebase.Add("", manifest.Project{
+		Remote:  "git@github.com:torvalds/linux.git",
+		Remotes: map[string]string{"upstream": "git@github.com:linux/linux.git"},
+		Branch:  "master",
+		Clone:   &manifest.CloneOptions{Filter: "blob:none", SingleBranch: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Clone == nil || project.Clone.Filter != "blob:none" {
+		t.Errorf("wrong clone options: %v", project.Clone)
+	}
+}
+
 func TestCodebase_Add_PathTaken(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -189,12 +298,12 @@ func TestCodebase_Add_PathTaken(t *testing.T) {
 			},
 		}, nil)
 
-	if _, err := codebase.Add("git@github.com:test/test.git", "test/test", nil); !errors.Is(err, ErrPathTaken) {
+	if _, err := codebase.Add("test/test", manifest.Project{Remote: "git@github.com:test/test.git"}); !errors.Is(err, ErrPathTaken) {
 		t.Fail()
 	}
 
 	codebase.localPath = "inside-dir"
-	if _, err := codebase.Add("git@github.com:test/test.git", "", nil); !errors.Is(err, ErrPathTaken) {
+	if _, err := codebase.Add("", manifest.Project{Remote: "git@github.com:test/test.git"}); !errors.Is(err, ErrPathTaken) {
 		t.Fail()
 	}
 }
@@ -232,10 +341,19 @@ func TestCodebase_Sync(t *testing.T) {
 		Return(manifest.Manifest{
 			Projects: map[string]manifest.Project{
 				"test/a/b": {Remote: "test.git"},
-				"test/c/d": {Remote: "test.git"},
+				"test/c/d": {
+					Remote: "old-test.git",
+					Remotes: map[string]string{
+						"upstream": "old-upstream.git",
+						"fork":     "fork.git",
+						"mirror":   "mirror.git",
+					},
+				},
 			},
 		}, nil)
 
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.name", "srcode manifest merge driver").Return(nil)
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.driver", gomock.Any()).Return(nil)
 	repoMock.EXPECT().Pull("origin", "main").Return(nil)
 	repoMock.EXPECT().Push("origin", "main").Return(nil)
 
@@ -251,17 +369,18 @@ func TestCodebase_Sync(t *testing.T) {
 					Scripts: map[string][]string{
 						"test-local": {"go test -v"},
 					},
-					Hook: "test-local",
+					Hooks: map[string][]string{"pre-push": {"test-local"}},
 				},
 				"test/c/d": {
-					Remote: "test.git",
+					Remote:  "test.git",
+					Remotes: map[string]string{"upstream": "upstream.git"},
 					Config: map[string]string{
 						"user.mail": "alois@micard.lu",
 					},
 					Scripts: map[string][]string{
 						"test-global": {"@global-test"},
 					},
-					Hook: "test-global",
+					Hooks: map[string][]string{"pre-push": {"test-global"}},
 				},
 			},
 			Scripts: map[string][]string{
@@ -271,7 +390,7 @@ func TestCodebase_Sync(t *testing.T) {
 
 	// should clone missing projects
 	repoProviderMock.EXPECT().
-		Clone("test.git", filepath.Join(dir, "test-12")).
+		Clone("test.git", filepath.Join(dir, "test-12"), repository.CloneOptions{}).
 		Return(nil, nil)
 
 	cRepoMock := repository_mock.NewMockRepository(mockCtrl)
@@ -279,15 +398,21 @@ func TestCodebase_Sync(t *testing.T) {
 	cRepoMock.EXPECT().SetConfig("user.name", "Aloïs Micard").Return(nil) // restore config
 
 	cRepoMock = repository_mock.NewMockRepository(mockCtrl)
-	repoProviderMock.EXPECT().Open(filepath.Join(dir, "test/c/d")).Return(cRepoMock, nil)
+	repoProviderMock.EXPECT().Open(filepath.Join(dir, "test/c/d")).Return(cRepoMock, nil).Times(2)
+	cRepoMock.EXPECT().Remote("origin").Return("old-test.git", nil) // propagate remote change
+	cRepoMock.EXPECT().SetConfig("remote.origin.url", "test.git").Return(nil)
 	cRepoMock.EXPECT().SetConfig("user.mail", "alois@micard.lu").Return(nil)
+	cRepoMock.EXPECT().Remote("upstream").Return("old-upstream.git", nil) // update remote
+	cRepoMock.EXPECT().SetConfig("remote.upstream.url", "upstream.git").Return(nil)
+	cRepoMock.EXPECT().RemoveRemote("fork").Return(nil) // remove the remotes deleted from the manifest
+	cRepoMock.EXPECT().RemoveRemote("mirror").Return(&repository.GitError{Err: repository.ErrRemoteNotFound})
 
 	wg := sync.WaitGroup{}
 
 	added := map[string]manifest.Project{}
 	addedChan := make(chan ProjectEntry)
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		for entry := range addedChan {
 			added[entry.Path] = entry.Project
 		}
@@ -297,8 +422,8 @@ func TestCodebase_Sync(t *testing.T) {
 
 	deleted := map[string]manifest.Project{}
 	deletedChan := make(chan ProjectEntry)
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		for entry := range deletedChan {
 			deleted[entry.Path] = entry.Project
 		}
@@ -324,7 +449,7 @@ func TestCodebase_Sync(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if string(b) != "go test -v" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Fatalf("got: %s want: go lint", string(b))
 	}
 
@@ -333,7 +458,7 @@ func TestCodebase_Sync(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if string(b) != "go test" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Error(err)
 	}
 
@@ -374,6 +499,8 @@ func TestCodebase_Sync_NoChannel(t *testing.T) {
 			},
 		}, nil)
 
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.name", "srcode manifest merge driver").Return(nil)
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.driver", gomock.Any()).Return(nil)
 	repoMock.EXPECT().Pull("origin", "main").Return(nil)
 	repoMock.EXPECT().Push("origin", "main").Return(nil)
 
@@ -398,7 +525,7 @@ func TestCodebase_Sync_NoChannel(t *testing.T) {
 
 	// should clone missing projects
 	repoProviderMock.EXPECT().
-		Clone("test.git", filepath.Join(dir, "test-12")).
+		Clone("test.git", filepath.Join(dir, "test-12"), repository.CloneOptions{}).
 		Return(nil, nil)
 
 	cRepoMock := repository_mock.NewMockRepository(mockCtrl)
@@ -414,23 +541,276 @@ func TestCodebase_Sync_NoChannel(t *testing.T) {
 	}
 }
 
-func TestCodebase_Run(t *testing.T) {
+func TestCodebase_Sync_Conflict(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	dir := t.TempDir()
+	rebaseDir := filepath.Join(dir, metaDir, ".git", "rebase-merge")
 
 	codebase := &codebase{
+		repo:        repoMock,
 		manProvider: manProviderMock,
-		rootPath:    "test-dir",
-		localPath:   "",
+		rootPath:    dir,
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(dir, metaDir, manifestFile)).
+		Return(manifest.Manifest{Projects: map[string]manifest.Project{"api": {Remote: "api.git"}}}, nil)
+
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.name", "srcode manifest merge driver").Return(nil)
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.driver", gomock.Any()).Return(nil)
+
+	// the merge driver report the conflict & the rebase is stopped
+	repoMock.EXPECT().Pull("origin", "main").DoAndReturn(func(repo, refspec string) error {
+		if err := os.MkdirAll(rebaseDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+
+		conflicts := []manifest.Conflict{
+			{File: "manifest.json", Path: []string{"projects", "api", "branch"}, Base: "main", Ours: "develop", Theirs: "next"},
+		}
+		if err := manifest.ReportConflicts(codebase.conflictsPath(), conflicts); err != nil {
+			t.Fatal(err)
+		}
+
+		return errors.New("could not apply 1a2b3c... Set api branch")
+	})
+
+	// the rebase is left stopped until the conflicts are resolved
+	err := codebase.Sync(false, nil, nil)
+	if !errors.Is(err, ErrManifestConflict) {
+		t.Fatalf("got %v want %v", err, ErrManifestConflict)
+	}
+
+	want := `the manifest has been changed differently locally and remotely, the synchronization is stopped until they are resolved:
+manifest.json: projects > api > branch
+  base:   "main"
+  remote: "develop"
+  local:  "next"`
+	if err.Error() != want {
+		t.Errorf("got %s want %s", err, want)
+	}
+	if !codebase.rebaseInProgress() {
+		t.Error("the rebase should be left stopped")
+	}
+
+	// the next synchronizations are stopped too
+	if err := codebase.Sync(false, nil, nil); !errors.Is(err, ErrManifestConflict) {
+		t.Errorf("got %v want %v", err, ErrManifestConflict)
+	}
+
+	// not a conflict of the manifest: the rebase is aborted
+	if err := os.Remove(codebase.conflictsPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := codebase.Sync(false, nil, nil); !errors.Is(err, ErrRebaseInProgress) {
+		t.Errorf("got %v want %v", err, ErrRebaseInProgress)
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(dir, metaDir, manifestFile)).
+		Return(manifest.Manifest{Projects: map[string]manifest.Project{"api": {Remote: "api.git"}}}, nil)
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.name", "srcode manifest merge driver").Return(nil)
+	repoMock.EXPECT().SetConfig("merge.srcode-manifest.driver", gomock.Any()).Return(nil)
+	repoMock.EXPECT().Pull("origin", "main").DoAndReturn(func(repo, refspec string) error {
+		if err := os.MkdirAll(rebaseDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		return errors.New("could not apply 1a2b3c... Add README.md")
+	})
+	repoMock.EXPECT().RawCmd([]string{"rebase", "--abort"}, nil, ioutil.Discard).DoAndReturn(func(args, env []string, writer io.Writer) error {
+		return os.RemoveAll(rebaseDir)
+	})
+
+	if err := os.RemoveAll(rebaseDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := codebase.Sync(false, nil, nil); err == nil || errors.Is(err, ErrManifestConflict) {
+		t.Errorf("got %v want the pull error", err)
+	}
+	if codebase.rebaseInProgress() {
+		t.Error("the rebase should be aborted")
+	}
+}
+
+func TestCodebase_ResolveManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	gitCmd := func(args ...string) {
+		args = append([]string{"-c", "user.name=srcode", "-c", "user.email=srcode@example.org"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	// create the meta remote
+	work := filepath.Join(dir, "work")
+	gitCmd("init", "--bare", filepath.Join(dir, "meta.git"))
+	gitCmd("init", work)
+	content := `{"version": 1, "scripts": {"lint": ["golint"], "test": ["go test"]}}`
+	if err := ioutil.WriteFile(filepath.Join(work, manifestFile), []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd("-C", work, "add", manifestFile)
+	gitCmd("-C", work, "commit", "-m", "Initial commit")
+	gitCmd("-C", work, "push", filepath.Join(dir, "meta.git"), "HEAD:refs/heads/main")
+	gitCmd("-C", filepath.Join(dir, "meta.git"), "symbolic-ref", "HEAD", "refs/heads/main")
+
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	newCodebase := func(name string) *codebase {
+		rootPath := filepath.Join(dir, name)
+		gitCmd("clone", "file://"+filepath.Join(dir, "meta.git"), filepath.Join(rootPath, metaDir))
+
+		repo, err := repoProvider.Open(filepath.Join(rootPath, metaDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range map[string]string{"user.name": "srcode", "user.email": "srcode@example.org"} {
+			if err := repo.SetConfig(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return &codebase{rootPath: rootPath, repo: repo, repoProvider: repoProvider, manProvider: manifest.DefaultProvider}
+	}
+
+	setScripts := func(codebase *codebase, scripts map[string][]string) {
+		man, err := codebase.readManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		man.Scripts = scripts
+		if err := codebase.writeManifest(man); err != nil {
+			t.Fatal(err)
+		}
+		if err := codebase.repo.CommitFiles("Update scripts", manifestFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertScripts := func(codebase *codebase, scripts map[string][]string) {
+		t.Helper()
+		if man, err := codebase.readManifest(); err != nil || !reflect.DeepEqual(man.Scripts, scripts) {
+			t.Errorf("got %v (%v) want %v", man.Scripts, err, scripts)
+		}
+	}
+
+	a := newCodebase("a")
+	b := newCodebase("b")
+
+	if _, err := b.ResolveManifest(true, nil); !errors.Is(err, ErrNoManifestConflict) {
+		t.Errorf("got %v want %v", err, ErrNoManifestConflict)
+	}
+	if err := b.AbortSync(); !errors.Is(err, ErrNoManifestConflict) {
+		t.Errorf("got %v want %v", err, ErrNoManifestConflict)
+	}
+
+	// both scripts are changed differently on a & b
+	setScripts(a, map[string][]string{"lint": {"golint ./..."}, "test": {"go test -v"}})
+	if err := a.Sync(false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	setScripts(b, map[string][]string{"lint": {"golint -set_exit_status"}, "test": {"go test -race"}})
+	if err := b.Sync(false, nil, nil); !errors.Is(err, ErrManifestConflict) {
+		t.Fatalf("got %v want %v", err, ErrManifestConflict)
+	}
+	if !b.rebaseInProgress() {
+		t.Fatal("the rebase should be left stopped")
+	}
+
+	if _, err := b.ResolveManifest(true, []string{"scripts > build"}); !errors.Is(err, ErrNoManifestConflict) {
+		t.Errorf("got %v want %v", err, ErrNoManifestConflict)
+	}
+
+	// keep the remote lint script
+	remaining, err := b.ResolveManifest(false, []string{"manifest.json: scripts > lint"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || strings.Join(remaining[0].Path, " > ") != "scripts > test" {
+		t.Fatalf("wrong remaining conflicts: %v", remaining)
+	}
+	if err := b.Sync(false, nil, nil); !errors.Is(err, ErrManifestConflict) {
+		t.Errorf("got %v want %v", err, ErrManifestConflict)
+	}
+
+	// keep the local test script: the rebase is finished
+	if remaining, err = b.ResolveManifest(true, nil); err != nil || len(remaining) != 0 {
+		t.Fatalf("got %v (%v) want no remaining conflicts", remaining, err)
+	}
+	if b.rebaseInProgress() {
+		t.Fatal("the rebase should be finished")
+	}
+
+	// the synchronization can be finished
+	if err := b.Sync(false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Sync(false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{"lint": {"golint ./..."}, "test": {"go test -race"}}
+	assertScripts(a, want)
+	assertScripts(b, want)
+
+	// the synchronization can be cancelled, the local changes being kept
+	setScripts(a, map[string][]string{"lint": {"golint ./..."}, "test": {"go test ./..."}})
+	if err := a.Sync(false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	setScripts(b, map[string][]string{"lint": {"golint ./..."}, "test": {"go test -short"}})
+	if err := b.Sync(false, nil, nil); !errors.Is(err, ErrManifestConflict) {
+		t.Fatalf("got %v want %v", err, ErrManifestConflict)
+	}
+	if err := b.AbortSync(); err != nil {
+		t.Fatal(err)
+	}
+	if b.rebaseInProgress() {
+		t.Error("the rebase should be aborted")
+	}
+	assertScripts(b, map[string][]string{"lint": {"golint ./..."}, "test": {"go test -short"}})
+}
+
+func TestCodebase_Run(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	rootPath := t.TempDir()
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     rootPath,
+		localPath:    "",
 	}
 
 	b := &strings.Builder{}
 
+	repoProviderMock.EXPECT().
+		Open(filepath.Join(rootPath, "test", "something")).
+		AnyTimes().
+		Return(repoMock, nil)
+	repoMock.EXPECT().HeadCommit().AnyTimes().Return("0c35fd3", nil)
+
 	manProviderMock.EXPECT().
-		Read(filepath.Join("test-dir", metaDir, manifestFile)).
-		Times(6).
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
 		Return(manifest.Manifest{
 			Projects: map[string]manifest.Project{
 				"test/something": {
@@ -439,7 +819,16 @@ func TestCodebase_Run(t *testing.T) {
 						"greet-global":   {"@greet"},
 						"invalid-global": {"@invalid"},
 						"greet-custom":   {"@greet-custom"},
+						"read-input":     {"read name", "echo Hello $name"},
+						"exit-code":      {"exit 42"},
+						"sleep":          {"sleep 10"},
+						"print-env":      {"echo $GOFLAGS $SRCODE_PROJECT $SRCODE_REMOTE"},
+					},
+					Timeouts: map[string]string{
+						"sleep": "100ms",
 					},
+					Remote: "test.git",
+					Env:    map[string]string{"GOFLAGS": "-mod=mod"},
 				},
 			},
 			Scripts: map[string][]string{
@@ -449,7 +838,7 @@ func TestCodebase_Run(t *testing.T) {
 		}, nil)
 
 	// Try to run script from a non-project directory
-	if err := codebase.Run("greet-local", nil, b); !errors.Is(err, manifest.ErrNoProjectFound) {
+	if err := codebase.Run("greet-local", nil, nil, b); !errors.Is(err, manifest.ErrNoProjectFound) {
 		t.Fail()
 	}
 
@@ -457,35 +846,153 @@ func TestCodebase_Run(t *testing.T) {
 	codebase.localPath = "test/something"
 
 	// Try to run an non existing local script
-	if err := codebase.Run("blah", nil, b); !errors.Is(err, manifest.ErrScriptNotFound) {
+	if err := codebase.Run("blah", nil, nil, b); !errors.Is(err, manifest.ErrScriptNotFound) {
 		t.Fail()
 	}
 
 	// Try to run an non existing global script
-	if err := codebase.Run("invalid-global", nil, b); !errors.Is(err, manifest.ErrScriptNotFound) {
+	if err := codebase.Run("invalid-global", nil, nil, b); !errors.Is(err, manifest.ErrScriptNotFound) {
 		t.Fail()
 	}
 
 	// Try to run a local script
 	b.Reset()
-	if err := codebase.Run("greet-local", nil, b); err != nil || b.String() != "Hello from local script\n" {
+	if err := codebase.Run("greet-local", nil, nil, b); err != nil || b.String() != "Hello from local script\n" {
 		t.Errorf("error: %v", err)
 		t.Errorf("got: '%s' want: '%s'", b.String(), "Hello from local script")
 	}
 
 	// Try to run a global script
 	b.Reset()
-	if err := codebase.Run("greet-global", nil, b); err != nil || b.String() != "Hello from global script\n" {
+	if err := codebase.Run("greet-global", nil, nil, b); err != nil || b.String() != "Hello from global script\n" {
 		t.Errorf("error: %v", err)
 		t.Errorf("got: '%s' want: '%s'", b.String(), "Hello from global script")
 	}
 
 	// Try to run a global custom script
 	b.Reset()
-	if err := codebase.Run("greet-custom", []string{"param1", "param2"}, b); err != nil || b.String() != "Hello param2 param1\n" {
+	if err := codebase.Run("greet-custom", []string{"param1", "param2"}, nil, b); err != nil || b.String() != "Hello param2 param1\n" {
 		t.Errorf("error: %v", err)
 		t.Errorf("got: '%s' want: '%s'", b.String(), "Hello param2 param1")
 	}
+
+	// Try to run a script reading from stdin
+	b.Reset()
+	if err := codebase.Run("read-input", nil, strings.NewReader("creekorful\n"), b); err != nil || b.String() != "Hello creekorful\n" {
+		t.Errorf("error: %v", err)
+		t.Errorf("got: '%s' want: '%s'", b.String(), "Hello creekorful")
+	}
+
+	// Make sure the exit code is forwarded
+	var exitErr *exec.ExitError
+	if err := codebase.Run("exit-code", nil, nil, b); !errors.As(err, &exitErr) || exitErr.ExitCode() != 42 {
+		t.Errorf("got: %v want exit code 42", err)
+	}
+
+	// Make sure the environment is injected
+	b.Reset()
+	if err := codebase.Run("print-env", nil, nil, b); err != nil || b.String() != "-mod=mod test/something test.git\n" {
+		t.Errorf("error: %v", err)
+		t.Errorf("got: '%s' want: '%s'", b.String(), "-mod=mod test/something test.git")
+	}
+
+	// Make sure the script is killed once timeout is reached
+	start := time.Now()
+	if err := codebase.Run("sleep", nil, nil, b); !errors.Is(err, ErrScriptTimeout) {
+		t.Errorf("got: %v want: %v", err, ErrScriptTimeout)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("script not killed after timeout")
+	}
+
+	// Make sure the executions are recorded
+	runLogs, err := codebase.RunLogs("")
+	if err != nil || len(runLogs) != 7 {
+		t.Fatalf("got %d run logs (%v) want 7", len(runLogs), err)
+	}
+	if runLogs[0].Script != "sleep" || runLogs[0].ExitCode != 124 || runLogs[0].Head != "0c35fd3" {
+		t.Errorf("invalid run log: %+v", runLogs[0])
+	}
+
+	runLogs, err = codebase.RunLogs("exit-code")
+	if err != nil || len(runLogs) != 1 || runLogs[0].ExitCode != 42 || !runLogs[0].Failed() {
+		t.Errorf("invalid run logs: %+v (%v)", runLogs, err)
+	}
+
+	runLogs, err = codebase.RunLogs("greet-custom")
+	if err != nil || len(runLogs) != 1 || !reflect.DeepEqual(runLogs[0].Args, []string{"param1", "param2"}) {
+		t.Errorf("invalid run logs: %+v (%v)", runLogs, err)
+	}
+
+	output, err := codebase.RunOutput(runLogs[0].ID)
+	if err != nil || output != "Hello param2 param1\n" {
+		t.Errorf("got: '%s' (%v) want: '%s'", output, err, "Hello param2 param1")
+	}
+
+	if _, err := codebase.RunOutput("../manifest"); !errors.Is(err, ErrRunLogNotFound) {
+		t.Errorf("got: %v want: %v", err, ErrRunLogNotFound)
+	}
+
+	// Make sure the project is part of the id (the same script may run concurrently in several projects)
+	if !strings.HasSuffix(runLogs[0].ID, "-test_something-greet-custom") {
+		t.Errorf("invalid run log id: %s", runLogs[0].ID)
+	}
+
+	// Make sure the run logs are not committed
+	if _, err := os.Stat(filepath.Join(rootPath, metaDir, runsDir, ".gitignore")); err != nil {
+		t.Errorf("missing .gitignore in runs directory")
+	}
+
+	// Make sure the script is executed (and its error returned) even if it cannot be recorded
+	if err := os.RemoveAll(filepath.Join(rootPath, metaDir, runsDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootPath, metaDir, runsDir), nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Reset()
+	if err := codebase.Run("exit-code", nil, nil, b); !errors.As(err, &exitErr) || exitErr.ExitCode() != 42 {
+		t.Errorf("got: %v want exit code 42", err)
+	}
+	if !strings.HasPrefix(b.String(), "warning: unable to record the execution of exit-code") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+}
+
+func TestCodebase_WriteRunLog(t *testing.T) {
+	rootPath := t.TempDir()
+
+	codebase := &codebase{rootPath: rootPath}
+
+	start := time.Now()
+	for i := 0; i < maxRunLogs+5; i++ {
+		runLog := RunLog{Script: "test", StartedAt: start.Add(time.Duration(i) * time.Second)}
+		f, err := codebase.createRunLog(&runLog)
+		if err != nil {
+			t.FailNow()
+		}
+		_ = f.Close()
+
+		if err := codebase.writeRunLog(runLog); err != nil {
+			t.FailNow()
+		}
+	}
+
+	runLogs, err := codebase.RunLogs("")
+	if err != nil || len(runLogs) != maxRunLogs {
+		t.Fatalf("got %d run logs (%v) want %d", len(runLogs), err, maxRunLogs)
+	}
+
+	// make sure the oldest ones were removed
+	if !runLogs[maxRunLogs-1].StartedAt.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("oldest run logs not removed")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(rootPath, metaDir, runsDir, "*.log"))
+	if len(files) != maxRunLogs {
+		t.Errorf("got %d log files want %d", len(files), maxRunLogs)
+	}
 }
 
 func TestCodebase_BulkGIT(t *testing.T) {
@@ -506,11 +1013,29 @@ func TestCodebase_BulkGIT(t *testing.T) {
 		Read(filepath.Join("/", "etc", "code", metaDir, manifestFile)).
 		Return(manifest.Manifest{
 			Projects: map[string]manifest.Project{
-				"test/something-1": {},
-				"test/something-2": {},
+				"test/something-1": {Remote: "a.git", Env: map[string]string{"GOFLAGS": "-mod=vendor"}},
+				"test/something-2": {Remote: "b.git"},
 			},
+			Env: map[string]string{"GOPRIVATE": "example.org", "GOFLAGS": "-mod=mod"},
 		}, nil)
 
+	envs := map[string][]string{
+		"test/something-1": {
+			"GOFLAGS=-mod=vendor",
+			"GOPRIVATE=example.org",
+			"SRCODE_PROJECT=test/something-1",
+			"SRCODE_REMOTE=a.git",
+			"SRCODE_ROOT=/etc/code",
+		},
+		"test/something-2": {
+			"GOFLAGS=-mod=mod",
+			"GOPRIVATE=example.org",
+			"SRCODE_PROJECT=test/something-2",
+			"SRCODE_REMOTE=b.git",
+			"SRCODE_ROOT=/etc/code",
+		},
+	}
+
 	sb := &strings.Builder{}
 	for _, path := range []string{"test/something-1", "test/something-2"} {
 		repoMock := repository_mock.NewMockRepository(mockCtrl)
@@ -518,8 +1043,9 @@ func TestCodebase_BulkGIT(t *testing.T) {
 			Open(filepath.Join("/", "etc", "code", path)).
 			Return(repoMock, nil)
 
+		// the commands are executed with the project environment
 		repoMock.EXPECT().
-			RawCmd([]string{"pull", "--rebase"}, sb).
+			RawCmd([]string{"pull", "--rebase"}, envs[path], sb).
 			Return(nil)
 	}
 
@@ -559,9 +1085,9 @@ func TestCodebase_BulkGIT_WithOut(t *testing.T) {
 			Return(repoMock, nil)
 
 		repoMock.EXPECT().
-			RawCmd([]string{"pull", "--rebase"}, sb).
-			Do(func(path string) func([]string, io.Writer) {
-				return func(args []string, w io.Writer) {
+			RawCmd([]string{"pull", "--rebase"}, gomock.Any(), sb).
+			Do(func(path string) func([]string, []string, io.Writer) {
+				return func(args []string, env []string, w io.Writer) {
 					_, _ = io.WriteString(w, fmt.Sprintf("out: %s", path))
 				}
 			}(path)).Return(nil)
@@ -581,44 +1107,109 @@ func TestCodebase_BulkGIT_WithOut(t *testing.T) {
 	}
 }
 
-func TestCodebase_SetScript(t *testing.T) {
+func TestCodebase_CheckoutDefault(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
-	repoMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
 
 	codebase := &codebase{
-		manProvider: manProviderMock,
-		repo:        repoMock,
-		rootPath:    "test-dir",
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     "/etc/code",
 	}
 
 	manProviderMock.EXPECT().
-		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Read(filepath.Join("/", "etc", "code", metaDir, manifestFile)).
 		Return(manifest.Manifest{
 			Projects: map[string]manifest.Project{
-				"test/something": {},
+				"a/on-branch":  {Branch: "main"},
+				"a/off-branch": {Branch: "main"},
+				"a/dirty":      {Branch: "develop"},
+				"a/failing":    {Branch: "missing"},
+				"a/untracked":  {},
 			},
 		}, nil)
 
-	// from should should only works with global = true
-	if err := codebase.SetScript("test", []string{"test"}, false); !errors.Is(err, manifest.ErrNoProjectFound) {
-		t.Fail()
-	}
+	onBranchMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Open(filepath.Join("/", "etc", "code", "a", "on-branch")).Return(onBranchMock, nil)
+	onBranchMock.EXPECT().Head().Return("main", nil)
 
-	manProviderMock.EXPECT().
-		Read(filepath.Join("test-dir", metaDir, manifestFile)).
-		Return(manifest.Manifest{
-			Projects: map[string]manifest.Project{
-				"test/something": {},
-			},
-		}, nil)
+	offBranchMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Open(filepath.Join("/", "etc", "code", "a", "off-branch")).Return(offBranchMock, nil)
+	offBranchMock.EXPECT().Head().Return("feature", nil)
+	offBranchMock.EXPECT().IsDirty().Return(false, nil)
+	offBranchMock.EXPECT().Checkout("main").Return(nil)
 
-	manProviderMock.EXPECT().
-		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile),
-			manifest.Manifest{
-				Projects: map[string]manifest.Project{
+	dirtyMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Open(filepath.Join("/", "etc", "code", "a", "dirty")).Return(dirtyMock, nil)
+	dirtyMock.EXPECT().Head().Return("main", nil)
+	dirtyMock.EXPECT().IsDirty().Return(true, nil)
+
+	failingMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Open(filepath.Join("/", "etc", "code", "a", "failing")).Return(failingMock, nil)
+	failingMock.EXPECT().Head().Return("main", nil)
+	failingMock.EXPECT().IsDirty().Return(false, nil)
+	failingMock.EXPECT().Checkout("missing").Return(errors.New("pathspec 'missing' did not match"))
+
+	entries, err := codebase.CheckoutDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("wrong number of entries: %v", entries)
+	}
+	if entries[0].Path != "a/dirty" || !errors.Is(entries[0].Err, ErrProjectDirty) {
+		t.Errorf("wrong entry: %v", entries[0])
+	}
+	if entries[1].Path != "a/failing" || entries[1].Err == nil {
+		t.Errorf("wrong entry: %v", entries[1])
+	}
+	if entries[2] != (CheckoutEntry{Path: "a/off-branch", Head: "feature", Branch: "main"}) {
+		t.Errorf("wrong entry: %v", entries[2])
+	}
+}
+
+func TestCodebase_SetScript(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		repo:        repoMock,
+		rootPath:    "test-dir",
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something": {},
+			},
+		}, nil)
+
+	// from should should only works with global = true
+	if err := codebase.SetScript("test", []string{"test"}, false); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Fail()
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something": {},
+			},
+		}, nil)
+
+	manProviderMock.EXPECT().
+		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile),
+			manifest.Manifest{
+				Projects: map[string]manifest.Project{
 					"test/something": {},
 				},
 				Scripts: map[string][]string{
@@ -923,6 +1514,186 @@ func TestCodebase_RmProject(t *testing.T) {
 	}
 }
 
+func TestCodebase_SetRemote(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		repoProvider: repoProviderMock,
+		repo:         repoMock,
+		manProvider:  manProviderMock,
+		rootPath:     "/home/creekorful",
+		localPath:    "Contributing",
+	}
+
+	man := manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"Contributing/test": {Remote: "git@github.com:creekorful/test.git"},
+		},
+	}
+
+	// unknown project
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(man, nil)
+	if err := codebase.SetRemote("missing", "upstream", "git@github.com:test/test.git"); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Errorf("got %v want %v", err, manifest.ErrNoProjectFound)
+	}
+
+	// add the upstream remote
+	projectRepoMock := repository_mock.NewMockRepository(mockCtrl)
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(man, nil)
+	repoProviderMock.EXPECT().Open(filepath.Join(codebase.rootPath, "Contributing", "test")).Return(projectRepoMock, nil)
+	projectRepoMock.EXPECT().Remote("upstream").Return("", fmt.Errorf("no such remote: %w", repository.ErrRemoteNotFound))
+	projectRepoMock.EXPECT().AddRemote("upstream", "git@github.com:test/test.git").Return(nil)
+	manProviderMock.EXPECT().
+		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile), manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"Contributing/test": {
+					Remote:  "git@github.com:creekorful/test.git",
+					Remotes: map[string]string{"upstream": "git@github.com:test/test.git"},
+				},
+			},
+		}).
+		Return(nil)
+	repoMock.EXPECT().
+		CommitFiles("Set upstream remote of Contributing/test to git@github.com:test/test.git", manifestFile).
+		Return(nil)
+
+	if err := codebase.SetRemote("test", "upstream", "git@github.com:test/test.git"); err != nil {
+		t.Error(err)
+	}
+
+	// update the primary remote
+	man = manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"Contributing/test": {Remote: "git@github.com:creekorful/test.git"},
+		},
+	}
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(man, nil)
+	repoProviderMock.EXPECT().Open(filepath.Join(codebase.rootPath, "Contributing", "test")).Return(projectRepoMock, nil)
+	projectRepoMock.EXPECT().Remote("origin").Return("git@github.com:creekorful/test.git", nil)
+	projectRepoMock.EXPECT().SetConfig("remote.origin.url", "https://github.com/creekorful/test.git").Return(nil)
+	manProviderMock.EXPECT().
+		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile), manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"Contributing/test": {Remote: "https://github.com/creekorful/test.git"},
+			},
+		}).
+		Return(nil)
+	repoMock.EXPECT().
+		CommitFiles("Set origin remote of Contributing/test to https://github.com/creekorful/test.git", manifestFile).
+		Return(nil)
+
+	if err := codebase.SetRemote("test", "origin", "https://github.com/creekorful/test.git"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodebase_RewriteRemotes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		repoProvider: repoProviderMock,
+		repo:         repoMock,
+		manProvider:  manProviderMock,
+		rootPath:     "/home/creekorful",
+	}
+
+	getManifest := func() manifest.Manifest {
+		return manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"a": {
+					Remote:  "git@old.example.org:team/a.git",
+					Remotes: map[string]string{"upstream": "git@github.com:other/a.git"},
+				},
+				"b": {
+					Remote:  "git@github.com:me/b.git",
+					Remotes: map[string]string{"upstream": "git@old.example.org:team/b.git"},
+				},
+				"c": {Remote: "git@old.example.org:team/c.git"},
+				"d": {Remote: "git@github.com:me/d.git"},
+			},
+		}
+	}
+
+	expected := []RemoteRewrite{
+		{Path: "a", Name: "origin", Old: "git@old.example.org:team/a.git", New: "ssh://git@new.example.org/team/a.git"},
+		{Path: "b", Name: "upstream", Old: "git@old.example.org:team/b.git", New: "ssh://git@new.example.org/team/b.git"},
+		{Path: "c", Name: "origin", Old: "git@old.example.org:team/c.git", New: "ssh://git@new.example.org/team/c.git"},
+	}
+
+	// dry run
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(getManifest(), nil)
+
+	rewrites, err := codebase.RewriteRemotes("git@old.example.org:team/", "ssh://git@new.example.org/team/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rewrites, expected) {
+		t.Errorf("got %v want %v", rewrites, expected)
+	}
+
+	// no match
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(getManifest(), nil)
+
+	if rewrites, err := codebase.RewriteRemotes("https://", "git@", false); err != nil || len(rewrites) != 0 {
+		t.Errorf("no remote should be rewritten: %v (%v)", rewrites, err)
+	}
+
+	// apply
+	manProviderMock.EXPECT().Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).Return(getManifest(), nil)
+	manProviderMock.EXPECT().
+		Write(filepath.Join(codebase.rootPath, metaDir, manifestFile), manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"a": {
+					Remote:  "ssh://git@new.example.org/team/a.git",
+					Remotes: map[string]string{"upstream": "git@github.com:other/a.git"},
+				},
+				"b": {
+					Remote:  "git@github.com:me/b.git",
+					Remotes: map[string]string{"upstream": "ssh://git@new.example.org/team/b.git"},
+				},
+				"c": {Remote: "ssh://git@new.example.org/team/c.git"},
+				"d": {Remote: "git@github.com:me/d.git"},
+			},
+		}).
+		Return(nil)
+	repoMock.EXPECT().
+		CommitFiles("Rewrite remotes from git@old.example.org:team/ to ssh://git@new.example.org/team/", manifestFile).
+		Return(nil)
+
+	aRepoMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Exists(filepath.Join(codebase.rootPath, "a")).Return(true)
+	repoProviderMock.EXPECT().Open(filepath.Join(codebase.rootPath, "a")).Return(aRepoMock, nil)
+	aRepoMock.EXPECT().Remote("origin").Return("git@old.example.org:team/a.git", nil)
+	aRepoMock.EXPECT().SetConfig("remote.origin.url", "ssh://git@new.example.org/team/a.git").Return(nil)
+
+	bRepoMock := repository_mock.NewMockRepository(mockCtrl)
+	repoProviderMock.EXPECT().Exists(filepath.Join(codebase.rootPath, "b")).Return(true)
+	repoProviderMock.EXPECT().Open(filepath.Join(codebase.rootPath, "b")).Return(bRepoMock, nil)
+	bRepoMock.EXPECT().Remote("upstream").Return("", fmt.Errorf("no such remote: %w", repository.ErrRemoteNotFound))
+	bRepoMock.EXPECT().AddRemote("upstream", "ssh://git@new.example.org/team/b.git").Return(nil)
+
+	// not cloned
+	repoProviderMock.EXPECT().Exists(filepath.Join(codebase.rootPath, "c")).Return(false)
+
+	rewrites, err = codebase.RewriteRemotes("git@old.example.org:team/", "ssh://git@new.example.org/team/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rewrites, expected) {
+		t.Errorf("got %v want %v", rewrites, expected)
+	}
+}
+
 func TestCodebase_SetHook(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -968,7 +1739,7 @@ func TestCodebase_SetHook(t *testing.T) {
 				"global-42": {"#/bin/sh", "echo hello from global"},
 			},
 		}, nil)
-	if err := codebase.SetHook("test-12"); !errors.Is(err, manifest.ErrNoProjectFound) {
+	if err := codebase.SetHook("pre-push", []string{"test-12"}); !errors.Is(err, manifest.ErrNoProjectFound) {
 		t.Fail()
 	}
 
@@ -995,7 +1766,7 @@ func TestCodebase_SetHook(t *testing.T) {
 			},
 		}, nil)
 	codebase.localPath = "test/something-1"
-	if err := codebase.SetHook("test-111"); !errors.Is(err, manifest.ErrScriptNotFound) {
+	if err := codebase.SetHook("pre-push", []string{"test-111"}); !errors.Is(err, manifest.ErrScriptNotFound) {
 		t.Error(err)
 	}
 
@@ -1028,7 +1799,7 @@ func TestCodebase_SetHook(t *testing.T) {
 				Scripts: map[string][]string{
 					"test-12": {"echo hello"},
 				},
-				Hook: "test-12",
+				Hooks: map[string][]string{"pre-push": {"test-12"}},
 			},
 			"test/something-2": {
 				Remote: "test-2.git",
@@ -1042,14 +1813,14 @@ func TestCodebase_SetHook(t *testing.T) {
 		},
 	})
 	repoMock.EXPECT().CommitFiles("Set pre-push hook `test-12` for test/something-1", manifestFile)
-	if err := codebase.SetHook("test-12"); err != nil {
+	if err := codebase.SetHook("pre-push", []string{"test-12"}); err != nil {
 		t.Fail()
 	}
 	b, err := ioutil.ReadFile(filepath.Join(path, "test", "something-1", ".git", "hooks", "pre-push"))
 	if err != nil {
 		t.Fail()
 	}
-	if string(b) != "echo hello" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Fatal()
 	}
 
@@ -1089,7 +1860,7 @@ func TestCodebase_SetHook(t *testing.T) {
 				Scripts: map[string][]string{
 					"test-42": {"@global-42"},
 				},
-				Hook: "test-42",
+				Hooks: map[string][]string{"pre-push": {"test-42"}},
 			},
 		},
 		Scripts: map[string][]string{
@@ -1097,16 +1868,45 @@ func TestCodebase_SetHook(t *testing.T) {
 		},
 	})
 	repoMock.EXPECT().CommitFiles("Set pre-push hook `test-42` for test/something-2", manifestFile)
-	if err := codebase.SetHook("test-42"); err != nil {
+	if err := codebase.SetHook("pre-push", []string{"test-42"}); err != nil {
 		t.Fail()
 	}
 	b, err = ioutil.ReadFile(filepath.Join(path, "test", "something-2", ".git", "hooks", "pre-push"))
 	if err != nil {
 		t.Fail()
 	}
-	if string(b) != "#/bin/sh\necho hello from global" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Fatal()
 	}
+
+	// test set invalid hook type
+	if err := codebase.SetHook("pre-blah", []string{"test-42"}); !errors.Is(err, ErrInvalidHookType) {
+		t.Error(err)
+	}
+
+	// test set hook with several scripts
+	manProviderMock.EXPECT().
+		Read(filepath.Join(codebase.rootPath, metaDir, manifestFile)).
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something-2": {
+					Remote: "test-2.git",
+					Scripts: map[string][]string{
+						"fmt":  {"echo fmt"},
+						"lint": {"echo lint"},
+					},
+				},
+			},
+		}, nil)
+	manProviderMock.EXPECT().Write(filepath.Join(codebase.rootPath, metaDir, manifestFile), gomock.Any())
+	repoMock.EXPECT().CommitFiles("Set commit-msg hook `fmt`, `lint` for test/something-2", manifestFile)
+	if err := codebase.SetHook("commit-msg", []string{"fmt", "lint"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(path, "test", "something-2", ".git", "hooks", "commit-msg"))
+	if err != nil || string(b) != getHookShimContent("commit-msg") {
+		t.Errorf("got %s (%v)", b, err)
+	}
 }
 
 func TestCodebase_Manifest(t *testing.T) {
@@ -1131,3 +1931,2028 @@ func TestCodebase_Manifest(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestCodebase_RmScript(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		repo:        repoMock,
+		rootPath:    "test-dir",
+	}
+
+	man := func() manifest.Manifest {
+		return manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something": {
+					Scripts: map[string][]string{
+						"test":  {"@go-test"},
+						"lint":  {"golint"},
+						"build": {"go build"},
+					},
+					Timeouts: map[string]string{"build": "1m"},
+					Hooks:    map[string][]string{"pre-push": {"lint"}},
+				},
+			},
+			Scripts: map[string][]string{
+				"go-test":              {"go test"},
+				"go-build":             {"go build"},
+				"conventional-commits": {"exit 0"},
+			},
+			Timeouts: map[string]string{"go-build": "1m"},
+			DefaultHooks: []manifest.DefaultHook{
+				{Path: "Personal/**", Hooks: map[string][]string{"commit-msg": {"@conventional-commits"}}},
+			},
+		}
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Times(8).
+		DoAndReturn(func(path string) (manifest.Manifest, error) { return man(), nil })
+
+	// global script does not exist
+	if err := codebase.RmScript("go-lint", true); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Error(err)
+	}
+
+	// global script still referenced
+	if err := codebase.RmScript("go-test", true); !errors.Is(err, ErrScriptInUse) {
+		t.Error(err)
+	}
+
+	// global script used by default hooks
+	if err := codebase.RmScript("conventional-commits", true); !errors.Is(err, ErrScriptInUse) {
+		t.Error(err)
+	}
+
+	// remove global script
+	expected := man()
+	delete(expected.Scripts, "go-build")
+	delete(expected.Timeouts, "go-build")
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), expected)
+	repoMock.EXPECT().CommitFiles("Remove global script `go-build`", manifestFile)
+
+	if err := codebase.RmScript("go-build", true); err != nil {
+		t.Error(err)
+	}
+
+	// not inside a project
+	if err := codebase.RmScript("lint", false); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Error(err)
+	}
+
+	codebase.localPath = "test/something"
+
+	// local script does not exist
+	if err := codebase.RmScript("go-build", false); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Error(err)
+	}
+
+	// local script used as hook
+	if err := codebase.RmScript("lint", false); !errors.Is(err, ErrScriptInUse) {
+		t.Error(err)
+	}
+
+	// remove local script
+	expected = man()
+	delete(expected.Projects["test/something"].Scripts, "build")
+	delete(expected.Projects["test/something"].Timeouts, "build")
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), expected)
+	repoMock.EXPECT().CommitFiles("Remove script `build` from test/something", manifestFile)
+
+	if err := codebase.RmScript("build", false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodebase_MoveScript(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		repo:        repoMock,
+		rootPath:    "test-dir",
+	}
+
+	man := func() manifest.Manifest {
+		return manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something": {
+					Scripts: map[string][]string{
+						"test": {"@go-test"},
+						"lint": {"golint"},
+					},
+					Timeouts: map[string]string{"lint": "1m"},
+					Hooks:    map[string][]string{"pre-push": {"lint"}},
+				},
+				"test/another": {
+					Scripts: map[string][]string{
+						"check": {"@go-test"},
+					},
+				},
+			},
+			Scripts: map[string][]string{
+				"go-test":  {"go test"},
+				"go-build": {"go build"},
+			},
+			Timeouts: map[string]string{"go-test": "1m"},
+		}
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Times(6).
+		DoAndReturn(func(path string) (manifest.Manifest, error) { return man(), nil })
+
+	// global script does not exist
+	if err := codebase.MoveScript("go-lint", "lint", true); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Error(err)
+	}
+
+	// global script already exist
+	if err := codebase.MoveScript("go-test", "go-build", true); !errors.Is(err, ErrScriptExist) {
+		t.Error(err)
+	}
+
+	// rename global script should update aliases
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"test/something": {
+				Scripts: map[string][]string{
+					"test": {"@go-test-race"},
+					"lint": {"golint"},
+				},
+				Timeouts: map[string]string{"lint": "1m"},
+				Hooks:    map[string][]string{"pre-push": {"lint"}},
+			},
+			"test/another": {
+				Scripts: map[string][]string{
+					"check": {"@go-test-race"},
+				},
+			},
+		},
+		Scripts: map[string][]string{
+			"go-test-race": {"go test"},
+			"go-build":     {"go build"},
+		},
+		Timeouts: map[string]string{"go-test-race": "1m"},
+	})
+	repoMock.EXPECT().CommitFiles("Rename global script `go-test` to `go-test-race`", manifestFile)
+
+	if err := codebase.MoveScript("go-test", "go-test-race", true); err != nil {
+		t.Error(err)
+	}
+
+	// not inside a project
+	if err := codebase.MoveScript("lint", "go-lint", false); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Error(err)
+	}
+
+	codebase.localPath = "test/something"
+
+	// local script already exist
+	if err := codebase.MoveScript("lint", "test", false); !errors.Is(err, ErrScriptExist) {
+		t.Error(err)
+	}
+
+	// rename local script should update the hook
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"test/something": {
+				Scripts: map[string][]string{
+					"test":    {"@go-test"},
+					"go-lint": {"golint"},
+				},
+				Timeouts: map[string]string{"go-lint": "1m"},
+				Hooks:    map[string][]string{"pre-push": {"go-lint"}},
+			},
+			"test/another": {
+				Scripts: map[string][]string{
+					"check": {"@go-test"},
+				},
+			},
+		},
+		Scripts: map[string][]string{
+			"go-test":  {"go test"},
+			"go-build": {"go build"},
+		},
+		Timeouts: map[string]string{"go-test": "1m"},
+	})
+	repoMock.EXPECT().CommitFiles("Rename script `lint` to `go-lint` in test/something", manifestFile)
+
+	if err := codebase.MoveScript("lint", "go-lint", false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodebase_PromoteScript(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		repo:        repoMock,
+		rootPath:    "test-dir",
+	}
+
+	man := func() manifest.Manifest {
+		return manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test/something": {
+					Scripts: map[string][]string{
+						"test":  {"@go-test"},
+						"lint":  {"golint"},
+						"build": {"go build"},
+					},
+				},
+			},
+			Scripts: map[string][]string{
+				"go-test":  {"go test"},
+				"go-build": {"go build -v"},
+			},
+		}
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join("test-dir", metaDir, manifestFile)).
+		Times(6).
+		DoAndReturn(func(path string) (manifest.Manifest, error) { return man(), nil })
+
+	// not inside a project
+	if err := codebase.PromoteScript("lint", ""); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Error(err)
+	}
+
+	codebase.localPath = "test/something"
+
+	// script does not exist
+	if err := codebase.PromoteScript("go-lint", ""); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Error(err)
+	}
+
+	// script is already an alias
+	if err := codebase.PromoteScript("test", ""); !errors.Is(err, ErrScriptExist) {
+		t.Error(err)
+	}
+
+	// a different global script exist with the same name
+	if err := codebase.PromoteScript("build", "go-build"); !errors.Is(err, ErrScriptExist) {
+		t.Error(err)
+	}
+
+	// promote using the same name
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"test/something": {
+				Scripts: map[string][]string{
+					"test":  {"@go-test"},
+					"lint":  {"@lint"},
+					"build": {"go build"},
+				},
+			},
+		},
+		Scripts: map[string][]string{
+			"go-test":  {"go test"},
+			"go-build": {"go build -v"},
+			"lint":     {"golint"},
+		},
+	})
+	repoMock.EXPECT().CommitFiles("Promote script `lint` of test/something to global script `lint`", manifestFile)
+
+	if err := codebase.PromoteScript("lint", ""); err != nil {
+		t.Error(err)
+	}
+
+	// promote using custom name
+	manProviderMock.EXPECT().Write(filepath.Join("test-dir", metaDir, manifestFile), manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"test/something": {
+				Scripts: map[string][]string{
+					"test":  {"@go-test"},
+					"lint":  {"@go-lint"},
+					"build": {"go build"},
+				},
+			},
+		},
+		Scripts: map[string][]string{
+			"go-test":  {"go test"},
+			"go-build": {"go build -v"},
+			"go-lint":  {"golint"},
+		},
+	})
+	repoMock.EXPECT().CommitFiles("Promote script `lint` of test/something to global script `go-lint`", manifestFile)
+
+	if err := codebase.PromoteScript("lint", "go-lint"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodebase_Env(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+
+	dir := t.TempDir()
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		rootPath:    dir,
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(dir, metaDir, manifestFile)).
+		Times(3).
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"Work/api": {
+					Remote: "api.git",
+					Env:    map[string]string{"DATABASE_URL": "postgres://localhost/api"},
+				},
+			},
+			Env: map[string]string{"GOFLAGS": "-mod=mod", "SRCODE_ROOT": "overridden"},
+		}, nil)
+
+	// not inside a project
+	if _, err := codebase.Env(false); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Error(err)
+	}
+
+	codebase.localPath = "Work/api"
+
+	env, err := codebase.Env(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"GOFLAGS":        "-mod=mod",
+		"DATABASE_URL":   "postgres://localhost/api",
+		"SRCODE_ROOT":    dir,
+		"SRCODE_PROJECT": "Work/api",
+		"SRCODE_REMOTE":  "api.git",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v want %v", env, expected)
+	}
+
+	// .env file take precedence over the manifest
+	if err := os.MkdirAll(filepath.Join(dir, "Work", "api"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Work", "api", ".env"), []byte("DATABASE_URL=postgres://db/api\nTOKEN=42"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = codebase.Env(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected["DATABASE_URL"] = "postgres://db/api"
+	expected["TOKEN"] = "42"
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v want %v", env, expected)
+	}
+}
+
+func TestCodebase_InstallHook(t *testing.T) {
+	rootPath := t.TempDir()
+	hooksDir := filepath.Join(rootPath, "test", ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{rootPath: rootPath}
+
+	// the foreign hook should be backed up
+	if err := ioutil.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh\nnpx lint-staged"), 0750); err != nil {
+		t.FailNow()
+	}
+	if err := codebase.installHook("test", "pre-commit"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(hooksDir, "pre-commit"+chainedHookSuffix)); string(b) != "#!/bin/sh\nnpx lint-staged" {
+		t.Errorf("foreign hook not backed up: %s", b)
+	}
+	if !isHookShim(filepath.Join(hooksDir, "pre-commit")) {
+		t.Errorf("shim not installed")
+	}
+
+	// re-installing should keep the backup
+	if err := codebase.installHook("test", "pre-commit"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(hooksDir, "pre-commit"+chainedHookSuffix)); string(b) != "#!/bin/sh\nnpx lint-staged" {
+		t.Errorf("foreign hook overwritten: %s", b)
+	}
+
+	// a new foreign hook cannot be backed up
+	if err := ioutil.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh\nmake"), 0750); err != nil {
+		t.FailNow()
+	}
+	if err := codebase.installHook("test", "pre-commit"); !errors.Is(err, ErrHookExist) {
+		t.Errorf("got %v want %v", err, ErrHookExist)
+	}
+
+	// uninstall should restore the foreign hook
+	if err := os.Remove(filepath.Join(hooksDir, "pre-commit")); err != nil {
+		t.FailNow()
+	}
+	if err := codebase.installHook("test", "pre-commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := codebase.uninstallHook("test", "pre-commit"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(hooksDir, "pre-commit")); string(b) != "#!/bin/sh\nnpx lint-staged" {
+		t.Errorf("foreign hook not restored: %s", b)
+	}
+	if fileExists(filepath.Join(hooksDir, "pre-commit"+chainedHookSuffix)) {
+		t.Errorf("backup not removed")
+	}
+}
+
+func TestCodebase_MigrateManifest_LegacyHook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	rootPath := t.TempDir()
+	for _, project := range []string{"api", "web"} {
+		if err := os.MkdirAll(filepath.Join(rootPath, project, ".git", "hooks"), 0750); err != nil {
+			t.FailNow()
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(rootPath, metaDir), 0750); err != nil {
+		t.FailNow()
+	}
+
+	// manifest written by srcode 0.7.2: the pre-push hook of api is a copy of the `hook` script,
+	// which is stale since the script has been changed afterward
+	content := `{"projects": {
+  "api": {"remote": "api.git", "scripts": {"lint": ["golint", "go vet ./..."]}, "hook": "lint"},
+  "web": {"remote": "web.git"}
+}}`
+	if err := ioutil.WriteFile(filepath.Join(rootPath, metaDir, manifestFile), []byte(content), 0640); err != nil {
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "api", ".git", "hooks", "pre-push"), []byte("golint"), 0750); err != nil {
+		t.FailNow()
+	}
+	// a foreign hook of a project without legacy hook
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "web", ".git", "hooks", "pre-push"), []byte("#!/bin/sh\ngit lfs pre-push \"$@\""), 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{rootPath: rootPath, repo: repoMock, manProvider: &manifest.JSONProvider{}}
+
+	repoMock.EXPECT().CommitFiles(fmt.Sprintf("Migrate manifest from version 0 to %d", manifest.CurrentVersion), manifestFile).Return(nil)
+	if err := codebase.migrateManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale copy should be replaced, not chained (the lint script is executed by the shim)
+	if !isHookShim(filepath.Join(rootPath, "api", ".git", "hooks", "pre-push")) {
+		t.Errorf("shim not installed")
+	}
+	if fileExists(filepath.Join(rootPath, "api", ".git", "hooks", "pre-push"+chainedHookSuffix)) {
+		t.Errorf("the hook installed by srcode 0.7.2 should not be chained")
+	}
+
+	// the other hooks are left untouched
+	if isHookShim(filepath.Join(rootPath, "web", ".git", "hooks", "pre-push")) {
+		t.Errorf("the foreign hook should not be replaced")
+	}
+
+	// the migration is only applied once
+	if err := codebase.migrateManifest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCodebase_Hooks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	rootPath := t.TempDir()
+	hooksDir := filepath.Join(rootPath, "test", ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{
+		manProvider: manProviderMock,
+		repo:        repoMock,
+		rootPath:    rootPath,
+		localPath:   "test",
+	}
+
+	man := manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"test": {
+				Scripts: map[string][]string{"lint": {"golint"}, "fmt": {"gofmt"}},
+				Hooks: map[string][]string{
+					"pre-push":   {"lint"},
+					"pre-commit": {"fmt", "lint"},
+				},
+			},
+			"other": {},
+		},
+		Scripts: map[string][]string{"conventional-commits": {"exit 0"}},
+		DefaultHooks: []manifest.DefaultHook{
+			{Path: "**", Hooks: map[string][]string{"commit-msg": {"@conventional-commits"}}},
+		},
+	}
+	manProviderMock.EXPECT().
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
+		DoAndReturn(func(path string) (manifest.Manifest, error) { return man, nil })
+	manProviderMock.EXPECT().
+		Write(filepath.Join(rootPath, metaDir, manifestFile), gomock.Any()).
+		AnyTimes().
+		Do(func(path string, m manifest.Manifest) { man = m })
+
+	if err := ioutil.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh"), 0750); err != nil {
+		t.FailNow()
+	}
+	if err := codebase.installHook("test", "pre-commit"); err != nil {
+		t.FailNow()
+	}
+
+	hooks, err := codebase.Hooks(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []HookEntry{
+		{Project: "test", Type: "commit-msg", Scripts: []string{"@conventional-commits"}, Default: true},
+		{Project: "test", Type: "pre-commit", Scripts: []string{"fmt", "lint"}, Installed: true, Chained: true},
+		{Project: "test", Type: "pre-push", Scripts: []string{"lint"}},
+	}
+	if !reflect.DeepEqual(hooks, expected) {
+		t.Errorf("got %+v want %+v", hooks, expected)
+	}
+
+	hooks, err = codebase.Hooks(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append([]HookEntry{
+		{Project: "other", Type: "commit-msg", Scripts: []string{"@conventional-commits"}, Default: true},
+	}, expected...)
+	if !reflect.DeepEqual(hooks, expected) {
+		t.Errorf("got %+v want %+v", hooks, expected)
+	}
+
+	// removing a default hook should opt-out the project
+	repoMock.EXPECT().CommitFiles("Remove commit-msg hook from test", manifestFile)
+	if err := codebase.RmHook("commit-msg"); err != nil {
+		t.Fatal(err)
+	}
+	if hooks, exist := man.Projects["test"].Hooks["commit-msg"]; !exist || len(hooks) != 0 {
+		t.Errorf("project not opted-out: %v", man.Projects["test"].Hooks)
+	}
+	if err := codebase.RmHook("commit-msg"); !errors.Is(err, ErrHookNotFound) {
+		t.Errorf("got %v want %v", err, ErrHookNotFound)
+	}
+
+	if err := codebase.RmHook("post-merge"); !errors.Is(err, ErrHookNotFound) {
+		t.Errorf("got %v want %v", err, ErrHookNotFound)
+	}
+
+	repoMock.EXPECT().CommitFiles("Remove pre-commit hook from test", manifestFile)
+	if err := codebase.RmHook("pre-commit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := man.Projects["test"].Hooks["pre-commit"]; exist {
+		t.Errorf("hook not removed from manifest")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(hooksDir, "pre-commit")); string(b) != "#!/bin/sh" {
+		t.Errorf("foreign hook not restored: %s", b)
+	}
+
+	repoMock.EXPECT().CommitFiles("Remove pre-push hook from test", manifestFile)
+	if err := codebase.RmHook("pre-push"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(man.Projects["test"].Hooks, map[string][]string{"commit-msg": {}}) {
+		t.Errorf("got %v", man.Projects["test"].Hooks)
+	}
+}
+
+func TestCodebase_InstallHooks(t *testing.T) {
+	rootPath := t.TempDir()
+	hooksDir := filepath.Join(rootPath, "Work", "api", ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{rootPath: rootPath}
+
+	man := manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"Work/api": {Hooks: map[string][]string{"pre-commit": {"fmt"}}},
+		},
+		DefaultHooks: []manifest.DefaultHook{
+			{Path: "Work/**", Hooks: map[string][]string{"commit-msg": {"@conventional-commits"}, "pre-push": {"@lint"}}},
+		},
+	}
+
+	if err := codebase.installHooks(man, "Work/api"); err != nil {
+		t.Fatal(err)
+	}
+	for _, hookType := range []string{"commit-msg", "pre-push", "pre-commit"} {
+		if !isHookShim(filepath.Join(hooksDir, hookType)) {
+			t.Errorf("%s hook not installed", hookType)
+		}
+	}
+
+	// the hooks not set anymore should be removed
+	man.Projects["Work/api"] = manifest.Project{NoDefaultHooks: true}
+	if err := codebase.installHooks(man, "Work/api"); err != nil {
+		t.Fatal(err)
+	}
+	for _, hookType := range []string{"commit-msg", "pre-push", "pre-commit"} {
+		if fileExists(filepath.Join(hooksDir, hookType)) {
+			t.Errorf("%s hook not removed", hookType)
+		}
+	}
+}
+
+func TestCodebase_ExecHook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+
+	rootPath := t.TempDir()
+	hooksDir := filepath.Join(rootPath, "test", ".git", "hooks")
+	if err := os.MkdirAll(hooksDir, 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     rootPath,
+		localPath:    "test",
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"test": {
+					Scripts: map[string][]string{
+						"fmt":  {"echo fmt $1", "cat"},
+						"test": {"echo test $1", "cat", "exit 3"},
+						"lint": {"echo lint"},
+					},
+					Hooks: map[string][]string{"commit-msg": {"fmt", "test", "lint"}},
+					Env:   map[string]string{"GOFLAGS": "-mod=mod"},
+				},
+			},
+		}, nil)
+	repoProviderMock.EXPECT().Open(filepath.Join(rootPath, "test")).AnyTimes().Return(nil, errors.New("not a repository"))
+
+	// the chained hook should be executed first
+	if err := ioutil.WriteFile(filepath.Join(hooksDir, "commit-msg"+chainedHookSuffix), []byte("#!/bin/sh\necho chained $1"), 0750); err != nil {
+		t.FailNow()
+	}
+
+	// the scripts should be executed in order, with the input, and stop at first failure
+	b := &strings.Builder{}
+	err := codebase.ExecHook("commit-msg", []string{"MSG"}, strings.NewReader("input\n"), b)
+	if exitErr := (&exec.ExitError{}); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("got %v want exit code 3", err)
+	}
+	if b.String() != "chained MSG\nfmt MSG\ninput\ntest MSG\ninput\n" {
+		t.Errorf("got %s", b.String())
+	}
+
+	// no hook set: nothing to do
+	b.Reset()
+	if err := codebase.ExecHook("pre-push", nil, nil, b); err != nil || b.String() != "" {
+		t.Errorf("got %s (%v)", b.String(), err)
+	}
+}
+
+func TestCodebase_ExecHook_DefaultHook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+
+	rootPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootPath, "Work", "api", ".git", "hooks"), 0750); err != nil {
+		t.FailNow()
+	}
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     rootPath,
+		localPath:    "Work/api",
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{"Work/api": {}},
+			DefaultHooks: []manifest.DefaultHook{
+				{Path: "Work/**", Hooks: map[string][]string{"pre-push": {"@lint"}}},
+			},
+			Scripts:  map[string][]string{"lint": {"echo lint $1"}},
+			Timeouts: map[string]string{"lint": "1m"},
+		}, nil)
+	repoProviderMock.EXPECT().Open(filepath.Join(rootPath, "Work", "api")).AnyTimes().Return(nil, errors.New("not a repository"))
+
+	// the global script should be executed directly
+	b := &strings.Builder{}
+	if err := codebase.ExecHook("pre-push", []string{"origin"}, nil, b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "lint origin\n" {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestCodebase_Secrets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	dir := t.TempDir()
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		repo:         repoMock,
+		rootPath:     dir,
+		keyring:      secret.Keyring{Dir: t.TempDir()},
+	}
+
+	// use an in-memory manifest
+	man := manifest.Manifest{
+		Projects: map[string]manifest.Project{
+			"Work/api": {Remote: "api.git", Env: map[string]string{"TOKEN": "not-a-secret"}},
+		},
+	}
+	manProviderMock.EXPECT().
+		Read(filepath.Join(dir, metaDir, manifestFile)).
+		AnyTimes().
+		DoAndReturn(func(path string) (manifest.Manifest, error) { return man, nil })
+	manProviderMock.EXPECT().
+		Write(filepath.Join(dir, metaDir, manifestFile), gomock.Any()).
+		AnyTimes().
+		Do(func(path string, m manifest.Manifest) { man = m })
+
+	// not inside a project
+	if err := codebase.SetSecret("TOKEN", "42", false); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Error(err)
+	}
+
+	// set global secret should generate the codebase key
+	repoMock.EXPECT().CommitFiles("Set global secret `GITHUB_TOKEN`", manifestFile)
+	if err := codebase.SetSecret("GITHUB_TOKEN", "gh-42", true); err != nil {
+		t.Fatal(err)
+	}
+	if man.SecretsKey == "" {
+		t.Error("codebase key not generated")
+	}
+	if _, err := os.Stat(codebase.keyring.Path(man.SecretsKey)); err != nil {
+		t.Error(err)
+	}
+	if val := man.Secrets["GITHUB_TOKEN"]; val == "" || val == "gh-42" {
+		t.Errorf("secret not encrypted: %s", val)
+	}
+
+	codebase.localPath = "Work/api"
+
+	// set project secret should re-use the codebase key
+	publicKey := man.SecretsKey
+	repoMock.EXPECT().CommitFiles("Set secret `TOKEN` for Work/api", manifestFile)
+	if err := codebase.SetSecret("TOKEN", "api-42", false); err != nil {
+		t.Fatal(err)
+	}
+	if man.SecretsKey != publicKey {
+		t.Error("codebase key should not change")
+	}
+	if val := man.Projects["Work/api"].Secrets["TOKEN"]; val == "" || val == "api-42" {
+		t.Errorf("secret not encrypted: %s", val)
+	}
+
+	// get the secrets
+	if val, err := codebase.Secret("GITHUB_TOKEN", true); err != nil || val != "gh-42" {
+		t.Errorf("got %s (%v) want gh-42", val, err)
+	}
+	if val, err := codebase.Secret("TOKEN", false); err != nil || val != "api-42" {
+		t.Errorf("got %s (%v) want api-42", val, err)
+	}
+	if _, err := codebase.Secret("GITHUB_TOKEN", false); !errors.Is(err, manifest.ErrSecretNotFound) {
+		t.Error(err)
+	}
+
+	// secrets should only be part of env if requested
+	env, err := codebase.Env(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := env["GITHUB_TOKEN"]; exist || env["TOKEN"] != "not-a-secret" {
+		t.Errorf("secrets should not be part of env: %v", env)
+	}
+
+	env, err = codebase.Env(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GITHUB_TOKEN"] != "gh-42" || env["TOKEN"] != "api-42" {
+		t.Errorf("secrets should be part of env: %v", env)
+	}
+
+	// secrets should be injected in scripts
+	man.Projects["Work/api"] = manifest.Project{
+		Remote:  man.Projects["Work/api"].Remote,
+		Secrets: man.Projects["Work/api"].Secrets,
+		Scripts: map[string][]string{"print": {"echo $GITHUB_TOKEN $TOKEN"}},
+	}
+	repoProviderMock.EXPECT().
+		Open(filepath.Join(dir, "Work", "api")).
+		Return(nil, errors.New("not cloned"))
+	b := &strings.Builder{}
+	if err := codebase.Run("print", nil, nil, b); err != nil || b.String() != "gh-42 api-42\n" {
+		t.Errorf("got %s (%v)", b.String(), err)
+	}
+
+	// without the key, secrets cannot be decrypted
+	codebase.keyring = secret.Keyring{Dir: t.TempDir()}
+	if _, err := codebase.Secret("TOKEN", false); !errors.Is(err, secret.ErrKeyNotFound) {
+		t.Error(err)
+	}
+	if _, err := codebase.Env(true); !errors.Is(err, secret.ErrKeyNotFound) {
+		t.Error(err)
+	}
+
+	// but the scripts should still run, without the secrets
+	repoProviderMock.EXPECT().
+		Open(filepath.Join(dir, "Work", "api")).
+		Return(nil, errors.New("not cloned"))
+	b.Reset()
+	if err := codebase.Run("print", nil, nil, b); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(b.String(), "warning: the secrets are not available") || !strings.HasSuffix(b.String(), "without them\n\n") {
+		t.Errorf("wrong output: %s", b.String())
+	}
+
+	// remove the secrets
+	if err := codebase.RmSecret("GITHUB_TOKEN", false); !errors.Is(err, manifest.ErrSecretNotFound) {
+		t.Error(err)
+	}
+
+	repoMock.EXPECT().CommitFiles("Remove secret `TOKEN` from Work/api", manifestFile)
+	if err := codebase.RmSecret("TOKEN", false); err != nil {
+		t.Error(err)
+	}
+	if len(man.Projects["Work/api"].Secrets) != 0 {
+		t.Error("secret not removed")
+	}
+
+	repoMock.EXPECT().CommitFiles("Remove global secret `GITHUB_TOKEN`", manifestFile)
+	if err := codebase.RmSecret("GITHUB_TOKEN", true); err != nil {
+		t.Error(err)
+	}
+	if len(man.Secrets) != 0 {
+		t.Error("secret not removed")
+	}
+}
+
+func TestCodebase_Watch(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	manProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+
+	rootPath := t.TempDir()
+	for _, dir := range []string{"api/build", "api/pkg", "web"} {
+		if err := os.MkdirAll(filepath.Join(rootPath, "Work", dir), 0750); err != nil {
+			t.FailNow()
+		}
+	}
+
+	codebase := &codebase{
+		manProvider:  manProviderMock,
+		repoProvider: repoProviderMock,
+		rootPath:     rootPath,
+		localPath:    "Work/api",
+	}
+
+	manProviderMock.EXPECT().
+		Read(filepath.Join(rootPath, metaDir, manifestFile)).
+		AnyTimes().
+		Return(manifest.Manifest{
+			Projects: map[string]manifest.Project{
+				"Work/api": {Scripts: map[string][]string{"test": {"@test"}}},
+				"Work/web": {Scripts: map[string][]string{"test": {"@test"}}},
+			},
+			Scripts: map[string][]string{"test": {"test ! -f fail"}},
+		}, nil)
+
+	repoProviderMock.EXPECT().Open(gomock.Any()).AnyTimes().Return(repoMock, nil)
+	repoProviderMock.EXPECT().Exists(gomock.Any()).AnyTimes().Return(false)
+	repoMock.EXPECT().HeadCommit().AnyTimes().Return("0c35fd3", nil)
+	repoMock.EXPECT().IsIgnored(gomock.Any()).AnyTimes().DoAndReturn(func(path string) (bool, error) {
+		return strings.Contains(path, "build"), nil
+	})
+
+	// Make sure the script must exist
+	if err := codebase.Watch(context.Background(), "lint", nil, nil, ioutil.Discard, nil); !errors.Is(err, manifest.ErrScriptNotFound) {
+		t.Errorf("got: %v want: %v", err, manifest.ErrScriptNotFound)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan WatchResult, 10)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := codebase.Watch(ctx, "test", nil, []string{"Work/api", "Work/web"}, ioutil.Discard, func(res WatchResult) {
+			results <- res
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	nextResult := func() WatchResult {
+		select {
+		case res := <-results:
+			return res
+		case <-time.After(5 * time.Second):
+			t.Fatal("no script execution")
+		}
+
+		return WatchResult{}
+	}
+
+	// the script is executed once for each project when starting
+	if res1, res2 := nextResult(), nextResult(); res1.Err != nil || res2.Err != nil || res1.Project == res2.Project {
+		t.Errorf("invalid results: %+v %+v", res1, res2)
+	}
+
+	// only the changed project should be re-run
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "Work", "api", "pkg", "main.go"), []byte{}, 0640); err != nil {
+		t.FailNow()
+	}
+	if res := nextResult(); res.Project != "Work/api" || res.Err != nil {
+		t.Errorf("invalid result: %+v", res)
+	}
+
+	// the ignored files should not trigger an execution
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "Work", "web", "build"), []byte{}, 0640); err != nil {
+		t.FailNow()
+	}
+	select {
+	case res := <-results:
+		t.Errorf("unexpected result: %+v", res)
+	case <-time.After(3 * watchDebounce):
+	}
+
+	// the scripts of other projects are executed from their root directory
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "Work", "web", "fail"), []byte{}, 0640); err != nil {
+		t.FailNow()
+	}
+	if res := nextResult(); res.Project != "Work/web" || res.Err == nil {
+		t.Errorf("invalid result: %+v", res)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestCodebase_Cache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	// create the remotes
+	remotes := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		work := filepath.Join(dir, "work-"+name)
+		for _, args := range [][]string{
+			{"init", "--bare", filepath.Join(dir, name+".git")},
+			{"init", work},
+			{"-C", work, "-c", "user.name=srcode", "-c", "user.email=srcode@example.org", "commit", "--allow-empty", "-m", "Initial commit"},
+			{"-C", work, "push", filepath.Join(dir, name+".git"), "HEAD:refs/heads/main"},
+			{"-C", filepath.Join(dir, name+".git"), "symbolic-ref", "HEAD", "refs/heads/main"},
+		} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("%s: %s", err, out)
+			}
+		}
+
+		remotes[name] = "file://" + filepath.Join(dir, name+".git")
+	}
+
+	rootPath := filepath.Join(dir, "codebase")
+	if err := os.MkdirAll(filepath.Join(rootPath, metaDir), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repoProvider: repository.NewProvider(repository.ExecBackend),
+		manProvider:  &manifest.JSONProvider{},
+		cache:        mirrorCache{dir: filepath.Join(dir, "cache")},
+	}
+
+	man := manifest.Manifest{Projects: map[string]manifest.Project{"a": {Remote: remotes["a"]}}}
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mirror is created when cloning
+	if _, err := codebase.cloneProject("a", man.Projects["a"]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(codebase.cache.path(remotes["a"]), "HEAD")); err != nil {
+		t.Errorf("mirror should be created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "a", ".git", "objects", "info", "alternates")); err == nil {
+		t.Error("the clone should not depend on the mirror")
+	}
+
+	// the shallow clones are not cached
+	shallow := manifest.Project{Remote: remotes["b"], Clone: &manifest.CloneOptions{Depth: 1}}
+	if _, err := codebase.cloneProject("b", shallow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(codebase.cache.path(remotes["b"])); err == nil {
+		t.Error("mirror should not be created for shallow clones")
+	}
+
+	entries, err := codebase.UpdateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Remote != remotes["a"] || entries[0].Err != nil {
+		t.Errorf("wrong cache entries: %v", entries)
+	}
+
+	// the mirror of b is not used by any (existing) codebase
+	if _, err := codebase.cache.update(codebase.repoProvider, remotes["b"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := codebase.cache.register(filepath.Join(dir, "removed")); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := codebase.GCCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != remotes["b"] {
+		t.Errorf("wrong removed mirrors: %v", removed)
+	}
+	if _, err := os.Stat(codebase.cache.path(remotes["a"])); err != nil {
+		t.Errorf("mirror of a should be kept: %v", err)
+	}
+
+	roots, err := codebase.cache.codebases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0] != rootPath {
+		t.Errorf("wrong codebases: %v", roots)
+	}
+}
+
+func TestCodebase_Snapshot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	gitCmd := func(args ...string) {
+		args = append([]string{"-c", "user.name=srcode", "-c", "user.email=srcode@example.org"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	// create the remotes
+	remotes := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		work := filepath.Join(dir, "work-"+name)
+		gitCmd("init", "--bare", filepath.Join(dir, name+".git"))
+		gitCmd("init", work)
+		gitCmd("-C", work, "commit", "--allow-empty", "-m", "Initial commit")
+		gitCmd("-C", work, "push", filepath.Join(dir, name+".git"), "HEAD:refs/heads/main")
+		gitCmd("-C", filepath.Join(dir, name+".git"), "symbolic-ref", "HEAD", "refs/heads/main")
+
+		remotes[name] = "file://" + filepath.Join(dir, name+".git")
+	}
+
+	rootPath := filepath.Join(dir, "codebase")
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"user.name": "srcode", "user.email": "srcode@example.org"} {
+		if err := repo.SetConfig(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repo:         repo,
+		repoProvider: repoProvider,
+		manProvider:  &manifest.JSONProvider{},
+	}
+
+	man := manifest.Manifest{Projects: map[string]manifest.Project{
+		"a": {Remote: remotes["a"]},
+		"b": {Remote: remotes["b"]},
+	}}
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitFiles("Initial commit", manifestFile); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := map[string]repository.Repository{}
+	for path, project := range man.Projects {
+		if repos[path], err = codebase.cloneProject(path, project); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Save
+	v1, err := codebase.SaveSnapshot("v1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v1.Projects) != 2 || v1.Projects["a"].Branch != "main" || v1.Projects["a"].Remote != remotes["a"] {
+		t.Errorf("wrong snapshot: %v", v1)
+	}
+	if _, err := codebase.SaveSnapshot("v1", false); !errors.Is(err, ErrSnapshotExist) {
+		t.Errorf("got %v want %v", err, ErrSnapshotExist)
+	}
+	if _, err := codebase.SaveSnapshot("../v1", false); !errors.Is(err, ErrInvalidSnapshotName) {
+		t.Errorf("got %v want %v", err, ErrInvalidSnapshotName)
+	}
+	if dirty, err := repo.IsDirty(); err != nil || dirty {
+		t.Errorf("the snapshot should be committed (%v)", err)
+	}
+
+	// update project a
+	gitCmd("-C", filepath.Join(dir, "work-a"), "commit", "--allow-empty", "-m", "Second commit")
+	gitCmd("-C", filepath.Join(dir, "work-a"), "push", filepath.Join(dir, "a.git"), "HEAD:refs/heads/main")
+	if err := repos["a"].Pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := codebase.SaveSnapshot("v2", false); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := codebase.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "v2" || snapshots[1].Name != "v1" {
+		t.Errorf("wrong snapshots: %v", snapshots)
+	}
+
+	// Diff
+	diffs, err := codebase.DiffSnapshots("v1", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].Path != "a" || diffs[0].Err != nil {
+		t.Fatalf("wrong diffs: %v", diffs)
+	}
+	if len(diffs[0].Added) != 1 || diffs[0].Added[0].Subject != "Second commit" || len(diffs[0].Removed) != 0 {
+		t.Errorf("wrong diff: %v", diffs[0])
+	}
+	if _, err := codebase.DiffSnapshots("v1", "missing"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("got %v want %v", err, ErrSnapshotNotFound)
+	}
+
+	// Restore
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "a", "wip.txt"), []byte("wip"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codebase.RestoreSnapshot("v1"); !errors.Is(err, ErrProjectDirty) {
+		t.Errorf("got %v want %v", err, ErrProjectDirty)
+	}
+	if err := os.Remove(filepath.Join(rootPath, "a", "wip.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the missing projects are cloned back
+	if err := os.RemoveAll(filepath.Join(rootPath, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := codebase.RestoreSnapshot("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Err != nil || entries[1].Err != nil {
+		t.Errorf("wrong entries: %v", entries)
+	}
+
+	for path, project := range v1.Projects {
+		repo, err := repoProvider.Open(filepath.Join(rootPath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit, err := repo.HeadCommit(); err != nil || commit != project.Commit {
+			t.Errorf("wrong %s commit: %s (%v)", path, commit, err)
+		}
+	}
+}
+
+func TestCodebase_Topic(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	gitCmd := func(args ...string) {
+		args = append([]string{"-c", "user.name=srcode", "-c", "user.email=srcode@example.org"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	// create the remotes
+	remotes := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		work := filepath.Join(dir, "work-"+name)
+		gitCmd("init", "--bare", filepath.Join(dir, name+".git"))
+		gitCmd("init", work)
+		gitCmd("-C", work, "commit", "--allow-empty", "-m", "Initial commit")
+		gitCmd("-C", work, "push", filepath.Join(dir, name+".git"), "HEAD:refs/heads/main")
+		gitCmd("-C", filepath.Join(dir, name+".git"), "symbolic-ref", "HEAD", "refs/heads/main")
+
+		remotes[name] = "file://" + filepath.Join(dir, name+".git")
+	}
+
+	rootPath := filepath.Join(dir, "codebase")
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repo:         repo,
+		repoProvider: repoProvider,
+		manProvider:  &manifest.JSONProvider{},
+	}
+
+	man := manifest.Manifest{Projects: map[string]manifest.Project{
+		"a": {Remote: remotes["a"]},
+		"b": {Remote: remotes["b"], Branch: "main"},
+	}}
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := map[string]repository.Repository{}
+	for path, project := range man.Projects {
+		if repos[path], err = codebase.cloneProject(path, project); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a detached project without tracked branch is based on its commit
+	gitCmd("-C", filepath.Join(rootPath, "a"), "checkout", "--detach")
+	commit, err := repos["a"].HeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start
+	if _, err := codebase.StartTopic("feature", nil); !errors.Is(err, ErrNoProjectSelected) {
+		t.Errorf("got %v want %v", err, ErrNoProjectSelected)
+	}
+	if _, err := codebase.StartTopic("feature", []string{"c"}); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Errorf("got %v want %v", err, manifest.ErrNoProjectFound)
+	}
+
+	entries, err := codebase.StartTopic("feature", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Err != nil || entries[1].Err != nil {
+		t.Errorf("wrong entries: %v", entries)
+	}
+	for path, repo := range repos {
+		if head, err := repo.Head(); err != nil || head != "feature" {
+			t.Errorf("%s should be on feature (got %s, %v)", path, head, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(codebase.topicsPath(), ".gitignore")); err != nil {
+		t.Errorf("the topics should be ignored: %v", err)
+	}
+
+	// Status
+	gitCmd("-C", filepath.Join(rootPath, "a"), "commit", "--allow-empty", "-m", "Add feature")
+	if err := ioutil.WriteFile(filepath.Join(rootPath, "b", "README"), []byte("wip"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	topic, statuses, err := codebase.TopicStatus("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.Name != "feature" || len(statuses) != 2 {
+		t.Fatalf("wrong status: %v %v", topic, statuses)
+	}
+	if statuses[0].Path != "a" || statuses[0].Base != commit || len(statuses[0].Commits) != 1 ||
+		statuses[0].Commits[0].Subject != "Add feature" || statuses[0].Dirty {
+		t.Errorf("wrong status: %v", statuses[0])
+	}
+	if statuses[1].Path != "b" || len(statuses[1].Commits) != 0 || !statuses[1].Dirty {
+		t.Errorf("wrong status: %v", statuses[1])
+	}
+
+	// Finish with a dirty project
+	entries, err = codebase.FinishTopic("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Err != nil || !errors.Is(entries[1].Err, ErrProjectDirty) {
+		t.Errorf("wrong entries: %v", entries)
+	}
+	if _, _, err := codebase.TopicStatus("feature"); err != nil {
+		t.Errorf("the topic should be kept: %v", err)
+	}
+
+	// Switch
+	if err := os.Remove(filepath.Join(rootPath, "b", "README")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codebase.SwitchTopic("missing"); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("got %v want %v", err, ErrTopicNotFound)
+	}
+
+	entries, err = codebase.SwitchTopic("feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "a" || entries[0].Err != nil {
+		t.Errorf("wrong entries: %v", entries)
+	}
+
+	// Finish
+	if _, err := codebase.FinishTopic("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := repos["a"].Head(); err != nil || head != "HEAD" {
+		t.Errorf("a should be detached (got %s, %v)", head, err)
+	}
+	if head, err := repos["b"].Head(); err != nil || head != "main" {
+		t.Errorf("b should be on main (got %s, %v)", head, err)
+	}
+	if _, _, err := codebase.TopicStatus(""); !errors.Is(err, ErrNoCurrentTopic) {
+		t.Errorf("got %v want %v", err, ErrNoCurrentTopic)
+	}
+	if _, _, err := codebase.TopicStatus("feature"); !errors.Is(err, ErrTopicNotFound) {
+		t.Errorf("got %v want %v", err, ErrTopicNotFound)
+	}
+}
+
+func TestCodebase_Worktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	gitCmd := func(args ...string) {
+		args = append([]string{"-c", "user.name=srcode", "-c", "user.email=srcode@example.org"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	// create the remote
+	work := filepath.Join(dir, "work")
+	gitCmd("init", "--bare", filepath.Join(dir, "api.git"))
+	gitCmd("init", work)
+	gitCmd("-C", work, "commit", "--allow-empty", "-m", "Initial commit")
+	gitCmd("-C", work, "push", filepath.Join(dir, "api.git"), "HEAD:refs/heads/main")
+	gitCmd("-C", filepath.Join(dir, "api.git"), "symbolic-ref", "HEAD", "refs/heads/main")
+
+	rootPath := filepath.Join(dir, "codebase")
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repo:         repo,
+		repoProvider: repoProvider,
+		manProvider:  &manifest.JSONProvider{},
+	}
+
+	man := manifest.Manifest{Projects: map[string]manifest.Project{
+		"Work/api": {Remote: "file://" + filepath.Join(dir, "api.git")},
+	}}
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codebase.cloneProject("Work/api", man.Projects["Work/api"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the branches are pushed after the clone
+	gitCmd("-C", work, "push", filepath.Join(dir, "api.git"), "HEAD:refs/heads/review", "HEAD:refs/heads/fix/login")
+
+	// Add
+	if _, err := codebase.AddWorktree("Work/web", "review"); !errors.Is(err, manifest.ErrNoProjectFound) {
+		t.Errorf("got %v want %v", err, manifest.ErrNoProjectFound)
+	}
+
+	worktree, err := codebase.AddWorktree("Work/api", "review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if worktree.Path != filepath.Join("Work", "api@review") || worktree.Branch != "review" {
+		t.Errorf("wrong worktree: %v", worktree)
+	}
+	if !repository.IsWorktree(filepath.Join(rootPath, "Work", "api@review")) {
+		t.Error("the worktree should be created")
+	}
+	if _, err := codebase.AddWorktree("Work/api", "review"); !errors.Is(err, ErrWorktreeExist) {
+		t.Errorf("got %v want %v", err, ErrWorktreeExist)
+	}
+
+	// custom layout
+	man.WorktreeLayout = "{project}.worktrees/{branch}"
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+	if worktree, err := codebase.AddWorktree("Work/api", "fix/login"); err != nil || worktree.Path != filepath.Join("Work", "api.worktrees", "fix-login") {
+		t.Errorf("wrong worktree: %v (%v)", worktree, err)
+	}
+
+	// Worktrees
+	worktrees, err := codebase.Worktrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worktrees["Work/api"]) != 2 {
+		t.Fatalf("wrong worktrees: %v", worktrees)
+	}
+	if val := worktrees["Work/api"][0]; val.Path != filepath.Join("Work", "api.worktrees", "fix-login") || val.Branch != "fix/login" || val.Commit == "" {
+		t.Errorf("wrong worktree: %v", val)
+	}
+	if val := worktrees["Work/api"][1]; val.Path != filepath.Join("Work", "api@review") || val.Branch != "review" {
+		t.Errorf("wrong worktree: %v", val)
+	}
+
+	// Remove
+	if err := codebase.RemoveWorktree("Work/api", "missing", false); !errors.Is(err, ErrWorktreeNotFound) {
+		t.Errorf("got %v want %v", err, ErrWorktreeNotFound)
+	}
+	if err := codebase.RemoveWorktree("Work/api", "review", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "Work", "api@review")); !os.IsNotExist(err) {
+		t.Errorf("the worktree should be removed (%v)", err)
+	}
+	if worktrees, err := codebase.Worktrees(); err != nil || len(worktrees["Work/api"]) != 1 {
+		t.Errorf("wrong worktrees: %v (%v)", worktrees, err)
+	}
+}
+
+func TestCodebase_Backup(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	gitCmd := func(args ...string) string {
+		args = append([]string{"-c", "user.name=srcode", "-c", "user.email=srcode@example.org"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	// create the remote
+	work := filepath.Join(dir, "work")
+	gitCmd("init", "--bare", filepath.Join(dir, "api.git"))
+	gitCmd("init", work)
+	gitCmd("-C", work, "commit", "--allow-empty", "-m", "Initial commit")
+	gitCmd("-C", work, "push", filepath.Join(dir, "api.git"), "HEAD:refs/heads/main")
+	gitCmd("-C", filepath.Join(dir, "api.git"), "symbolic-ref", "HEAD", "refs/heads/main")
+
+	rootPath := filepath.Join(dir, "codebase")
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"user.name": "srcode", "user.email": "srcode@example.org"} {
+		if err := repo.SetConfig(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.AddRemote("origin", "git@example.org:me/codebase.git"); err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repo:         repo,
+		repoProvider: repoProvider,
+		manProvider:  &manifest.JSONProvider{},
+	}
+
+	remote := "file://" + filepath.Join(dir, "api.git")
+	man := manifest.Manifest{Projects: map[string]manifest.Project{
+		"Work/api": {Remote: remote, Config: map[string]string{"user.name": "srcode"}},
+	}}
+	if err := codebase.writeManifest(man); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitFiles("Initial commit", manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codebase.cloneProject("Work/api", man.Projects["Work/api"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unpushed work should be backed up
+	projectPath := filepath.Join(rootPath, "Work", "api")
+	gitCmd("-C", projectPath, "checkout", "-b", "wip")
+	gitCmd("-C", projectPath, "commit", "--allow-empty", "-m", "Work in progress")
+	gitCmd("-C", projectPath, "branch", "stale")
+
+	backupDir := filepath.Join(dir, "backup")
+	entries, err := codebase.Backup(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Err != nil || entries[0].Bundle != "projects/Work/api/000001.bundle" {
+		t.Errorf("wrong entries: %v", entries)
+	}
+
+	// nothing changed
+	if entries, err := codebase.Backup(backupDir); err != nil || len(entries) != 1 || entries[0].Bundle != "" {
+		t.Errorf("wrong entries: %v (%v)", entries, err)
+	}
+
+	// incremental backup
+	gitCmd("-C", projectPath, "commit", "--allow-empty", "-m", "More work")
+	gitCmd("-C", projectPath, "branch", "-D", "stale")
+	if entries, err := codebase.Backup(backupDir); err != nil || len(entries) != 1 || entries[0].Bundle != "projects/Work/api/000002.bundle" {
+		t.Errorf("wrong entries: %v (%v)", entries, err)
+	}
+
+	backup, err := readBackup(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Remote != "git@example.org:me/codebase.git" || len(backup.Meta.Bundles) != 1 || backup.Projects["Work/api"].Head != "wip" {
+		t.Errorf("wrong backup: %v", backup)
+	}
+
+	// Restore
+	provider := &provider{repoProvider: repoProvider, manifestProvider: &manifest.JSONProvider{}}
+	if _, err := provider.Restore(filepath.Join(dir, "missing"), filepath.Join(dir, "restored"), nil); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("got %v want %v", err, ErrBackupNotFound)
+	}
+	if _, err := provider.Restore(backupDir, rootPath, nil); !errors.Is(err, ErrCodebaseAlreadyExist) {
+		t.Errorf("got %v want %v", err, ErrCodebaseAlreadyExist)
+	}
+
+	restoredPath := filepath.Join(dir, "restored")
+	ch := make(chan ProjectEntry, 1)
+	restored, err := provider.Restore(backupDir, restoredPath, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := <-ch; entry.Path != "Work/api" {
+		t.Errorf("wrong restored project: %v", entry)
+	}
+
+	projects, err := restored.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredRepo := projects["Work/api"].Repository
+	if head, err := restoredRepo.Head(); err != nil || head != "wip" {
+		t.Errorf("wrong restored head: %s (%v)", head, err)
+	}
+	if dirty, err := restoredRepo.IsDirty(); err != nil || dirty {
+		t.Errorf("the restored project should be clean (%v)", err)
+	}
+	if url, err := restoredRepo.Remote("origin"); err != nil || url != remote {
+		t.Errorf("wrong restored remote: %s (%v)", url, err)
+	}
+	if val, err := restoredRepo.Config("user.name"); err != nil || val != "srcode" {
+		t.Errorf("wrong restored config: %s (%v)", val, err)
+	}
+	if subject := gitCmd("-C", filepath.Join(restoredPath, "Work", "api"), "log", "-1", "--format=%s"); subject != "More work" {
+		t.Errorf("wrong restored commit: %s", subject)
+	}
+
+	refs, err := restoredRepo.Refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(refs, backup.Projects["Work/api"].Refs) {
+		t.Errorf("wrong restored refs: %v", refs)
+	}
+	if _, exist := refs["refs/heads/stale"]; exist {
+		t.Error("the deleted branches should not be restored")
+	}
+
+	restoredMeta, err := repoProvider.Open(filepath.Join(restoredPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url, err := restoredMeta.Remote("origin"); err != nil || url != "git@example.org:me/codebase.git" {
+		t.Errorf("wrong restored codebase remote: %s (%v)", url, err)
+	}
+}
+
+func TestCodebase_Sources(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	rootPath := filepath.Join(dir, "codebase")
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetConfig("user.name", "srcode"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetConfig("user.email", "srcode@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:       rootPath,
+		repo:           repo,
+		repoProvider:   repoProvider,
+		manProvider:    &manifest.JSONProvider{},
+		sourceProvider: source.DefaultProvider,
+	}
+	if err := codebase.writeManifest(manifest.Manifest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// create the archive
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("sdk-1.0/README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("sdk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(dir, "sdk-1.0.zip")
+	if err := ioutil.WriteFile(archivePath, buf.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256.Sum256(buf.Bytes())
+
+	// Add
+	notes := manifest.Project{Source: &manifest.Source{Type: manifest.SourceSymlink, URL: filepath.Join(dir, "notes")}}
+	if _, err := codebase.Add("", notes); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(rootPath, "notes")); err != nil || target != filepath.Join(dir, "notes") {
+		t.Errorf("wrong symlink: %s (%v)", target, err)
+	}
+
+	sdk := manifest.Project{Source: &manifest.Source{
+		Type:     manifest.SourceArchive,
+		URL:      "file://" + archivePath,
+		Checksum: hex.EncodeToString(checksum[:]),
+	}}
+	if _, err := codebase.Add("Vendor/sdk", sdk); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(rootPath, "Vendor", "sdk", "README.md")); err != nil || string(b) != "sdk" {
+		t.Errorf("wrong archive content: %s (%v)", b, err)
+	}
+
+	sdk.Source.Checksum = "invalid"
+	if _, err := codebase.Add("Vendor/sdk-2", sdk); !errors.Is(err, source.ErrChecksumMismatch) {
+		t.Errorf("got %v want %v", err, source.ErrChecksumMismatch)
+	}
+
+	// Projects
+	projects, err := codebase.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects["notes"].Repository != nil || projects["Vendor/sdk"].Project.SourceType() != manifest.SourceArchive {
+		t.Errorf("wrong projects: %v", projects)
+	}
+
+	// the git operations are not available
+	if _, err := codebase.AddWorktree("notes", "main"); !errors.Is(err, ErrNotGitProject) {
+		t.Errorf("got %v want %v", err, ErrNotGitProject)
+	}
+	if _, err := codebase.StartTopic("feature", []string{"Vendor/sdk"}); !errors.Is(err, ErrNotGitProject) {
+		t.Errorf("got %v want %v", err, ErrNotGitProject)
+	}
+	if worktrees, err := codebase.Worktrees(); err != nil || len(worktrees) != 0 {
+		t.Errorf("wrong worktrees: %v (%v)", worktrees, err)
+	}
+	if snapshot, err := codebase.SaveSnapshot("sources", false); err != nil || len(snapshot.Projects) != 0 {
+		t.Errorf("wrong snapshot: %v (%v)", snapshot, err)
+	}
+}
+
+func TestCodebase_ConvertManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	for _, backend := range []string{repository.ExecBackend, repository.GoGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			rootPath := t.TempDir()
+			repoProvider := repository.NewProvider(backend)
+
+			repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetConfig("user.name", "srcode"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetConfig("user.email", "srcode@example.org"); err != nil {
+				t.Fatal(err)
+			}
+
+			codebase := &codebase{
+				rootPath:     rootPath,
+				repo:         repo,
+				repoProvider: repoProvider,
+				manProvider:  manifest.DefaultProvider,
+			}
+
+			man := manifest.Manifest{
+				Projects: map[string]manifest.Project{"Work/api": {Remote: "git@example.org:api.git"}},
+				Scripts:  map[string][]string{"test": {"go vet ./...", "go test ./..."}},
+			}
+			if err := codebase.writeManifest(man); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.CommitFiles("Initial commit", manifestFile); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := codebase.ConvertManifest("toml"); !errors.Is(err, manifest.ErrUnsupportedFormat) {
+				t.Errorf("got %v want %v", err, manifest.ErrUnsupportedFormat)
+			}
+			if _, err := codebase.ConvertManifest(manifest.FormatJSON); !errors.Is(err, ErrManifestFormat) {
+				t.Errorf("got %v want %v", err, ErrManifestFormat)
+			}
+
+			name, err := codebase.ConvertManifest(manifest.FormatYAML)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "manifest.yaml" || codebase.manifestName() != "manifest.yaml" {
+				t.Errorf("wrong manifest name: %s", name)
+			}
+			if _, err := os.Stat(filepath.Join(rootPath, metaDir, manifestFile)); !os.IsNotExist(err) {
+				t.Error("the previous manifest should be removed")
+			}
+			if dirty, err := repo.IsDirty(); err != nil || dirty {
+				t.Errorf("the conversion should be committed (%v)", err)
+			}
+
+			res, err := codebase.readManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res, man) {
+				t.Errorf("got %+v want %+v", res, man)
+			}
+
+			if _, err := codebase.ConvertManifest(manifest.FormatJSON); err != nil {
+				t.Fatal(err)
+			}
+			if res, err := codebase.readManifest(); err != nil || !reflect.DeepEqual(res, man) {
+				t.Errorf("got %+v want %+v (%v)", res, man, err)
+			}
+		})
+	}
+}
+
+func TestCodebase_SplitManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	for _, backend := range []string{repository.ExecBackend, repository.GoGitBackend} {
+		t.Run(backend, func(t *testing.T) {
+			rootPath := t.TempDir()
+			repoProvider := repository.NewProvider(backend)
+
+			repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetConfig("user.name", "srcode"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetConfig("user.email", "srcode@example.org"); err != nil {
+				t.Fatal(err)
+			}
+
+			codebase := &codebase{
+				rootPath:     rootPath,
+				repo:         repo,
+				repoProvider: repoProvider,
+				manProvider:  manifest.DefaultProvider,
+			}
+
+			man := manifest.Manifest{
+				Projects: map[string]manifest.Project{
+					"Work/api": {Remote: "git@example.org:api.git"},
+					"Work/web": {Remote: "git@example.org:web.git"},
+				},
+				Scripts: map[string][]string{"test": {"go vet ./...", "go test ./..."}},
+			}
+			if err := codebase.writeManifest(man); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.CommitFiles("Initial commit", manifestFile); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := codebase.SplitManifest(); err != nil {
+				t.Fatal(err)
+			}
+			if err := codebase.SplitManifest(); !errors.Is(err, ErrManifestLayout) {
+				t.Errorf("got %v want %v", err, ErrManifestLayout)
+			}
+			if dirty, err := repo.IsDirty(); err != nil || dirty {
+				t.Errorf("the split should be committed (%v)", err)
+			}
+			if _, err := os.Stat(filepath.Join(rootPath, metaDir, "projects", "Work", "api.json")); err != nil {
+				t.Error(err)
+			}
+
+			man.Layout = manifest.LayoutSplit
+			if res, err := codebase.readManifest(); err != nil || !reflect.DeepEqual(res, man) {
+				t.Errorf("got %+v want %+v (%v)", res, man, err)
+			}
+
+			// the removed projects files should be committed too
+			delete(man.Projects, "Work/web")
+			if err := codebase.writeManifest(man); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.CommitFiles("Remove Work/web", codebase.manifestCommitFiles()...); err != nil {
+				t.Fatal(err)
+			}
+			if dirty, err := repo.IsDirty(); err != nil || dirty {
+				t.Errorf("the removal should be committed (%v)", err)
+			}
+
+			// as well as the converted ones
+			if _, err := codebase.ConvertManifest(manifest.FormatYAML); err != nil {
+				t.Fatal(err)
+			}
+			if dirty, err := repo.IsDirty(); err != nil || dirty {
+				t.Errorf("the conversion should be committed (%v)", err)
+			}
+			if _, err := os.Stat(filepath.Join(rootPath, metaDir, "projects", "Work", "api.yaml")); err != nil {
+				t.Error(err)
+			}
+			if res, err := codebase.readManifest(); err != nil || !reflect.DeepEqual(res, man) {
+				t.Errorf("got %+v want %+v (%v)", res, man, err)
+			}
+		})
+	}
+}
+
+func TestCodebase_RegisterMergeDriver(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	rootPath := t.TempDir()
+	repoProvider := repository.NewProvider(repository.ExecBackend)
+
+	repo, err := repoProvider.Init(filepath.Join(rootPath, metaDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codebase := &codebase{
+		rootPath:     rootPath,
+		repo:         repo,
+		repoProvider: repoProvider,
+		manProvider:  manifest.DefaultProvider,
+	}
+
+	attributesPath := filepath.Join(rootPath, metaDir, ".git", "info", "attributes")
+	if err := ioutil.WriteFile(attributesPath, []byte("*.md text"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// registering twice should not duplicate the attributes
+	for i := 0; i < 2; i++ {
+		if err := codebase.registerMergeDriver(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	driver, err := repo.Config("merge.srcode-manifest.driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(driver, "' manifest merge --report '"+codebase.conflictsPath()+"' %O %A %B %P") {
+		t.Errorf("wrong driver: %s", driver)
+	}
+
+	b, err := ioutil.ReadFile(attributesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `*.md text
+/manifest.json merge=srcode-manifest
+/manifest.yaml merge=srcode-manifest
+/manifest.yml merge=srcode-manifest
+/projects/** merge=srcode-manifest
+/scripts/** merge=srcode-manifest
+`
+	if string(b) != want {
+		t.Errorf("got %s want %s", b, want)
+	}
+
+	if codebase.rebaseInProgress() {
+		t.Error("no rebase should be in progress")
+	}
+}
@@ -0,0 +1,340 @@
+package codebase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// hookShimMarker identify the hooks installed by srcode
+	hookShimMarker = "# srcode hook shim"
+	// chainedHookSuffix is the suffix of the pre-existing (foreign) hooks backup
+	chainedHookSuffix = ".srcode-chained"
+)
+
+var (
+	// ErrInvalidHookType is returned when the git hook type is not supported
+	ErrInvalidHookType = errors.New("invalid hook type")
+	// ErrHookNotFound is returned when the hook is not set
+	ErrHookNotFound = errors.New("no hook with the type found")
+	// ErrHookExist is returned when a foreign hook cannot be backed up
+	ErrHookExist = errors.New("a foreign hook already exist")
+
+	// hookTypes are the git hooks that can be set on a project
+	// see https://git-scm.com/docs/githooks
+	hookTypes = []string{
+		"applypatch-msg", "pre-applypatch", "post-applypatch",
+		"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+		"pre-rebase", "post-checkout", "post-merge", "pre-push", "post-rewrite",
+		"pre-auto-gc", "push-to-checkout", "reference-transaction", "sendemail-validate",
+	}
+)
+
+// HookEntry is a git hook of a project
+type HookEntry struct {
+	Project string
+	Type    string
+	Scripts []string
+	// Default is true if the hook is inherited from the codebase default hooks
+	Default bool
+	// Installed is true if the hook shim is installed in the .git/hooks directory
+	Installed bool
+	// Chained is true if a pre-existing hook is executed before the scripts
+	Chained bool
+}
+
+// IsValidHookType returns true if given git hook type is supported
+func IsValidHookType(hookType string) bool {
+	for _, t := range hookTypes {
+		if t == hookType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (codebase *codebase) Hooks(all bool) ([]HookEntry, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{codebase.localPath}
+	if all {
+		paths = nil
+		for path, project := range man.Projects {
+			if project.IsGit() {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	var hooks []HookEntry
+	for _, path := range paths {
+		projectHooks, err := man.GetHooks(path)
+		if err != nil {
+			return nil, err
+		}
+
+		hooksDir := codebase.hooksDir(path)
+		for hookType, scripts := range projectHooks {
+			_, isProjectHook := man.Projects[path].Hooks[hookType]
+
+			hooks = append(hooks, HookEntry{
+				Project:   path,
+				Type:      hookType,
+				Scripts:   scripts,
+				Default:   !isProjectHook,
+				Installed: isHookShim(filepath.Join(hooksDir, hookType)),
+				Chained:   fileExists(filepath.Join(hooksDir, hookType+chainedHookSuffix)),
+			})
+		}
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Project != hooks[j].Project {
+			return hooks[i].Project < hooks[j].Project
+		}
+
+		return hooks[i].Type < hooks[j].Type
+	})
+
+	return hooks, nil
+}
+
+func (codebase *codebase) RmHook(hookType string) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	project, exist := man.Projects[codebase.localPath]
+	if !exist {
+		return manifest.ErrNoProjectFound
+	}
+
+	hooks, err := man.GetHooks(codebase.localPath)
+	if err != nil {
+		return err
+	}
+
+	if _, exist := hooks[hookType]; !exist {
+		return fmt.Errorf("error while removing hook %s: %w", hookType, ErrHookNotFound)
+	}
+
+	if err := codebase.uninstallHook(codebase.localPath, hookType); err != nil {
+		return err
+	}
+
+	delete(project.Hooks, hookType)
+	man.Projects[codebase.localPath] = project
+
+	// the hook is inherited from the default ones: explicitly opt-out
+	if hooks, _ := man.GetHooks(codebase.localPath); len(hooks[hookType]) > 0 {
+		if project.Hooks == nil {
+			project.Hooks = map[string][]string{}
+		}
+		project.Hooks[hookType] = []string{}
+	}
+
+	if len(project.Hooks) == 0 {
+		project.Hooks = nil
+	}
+	man.Projects[codebase.localPath] = project
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles(
+		fmt.Sprintf("Remove %s hook from %s", hookType, codebase.localPath),
+		codebase.manifestCommitFiles()...,
+	)
+}
+
+func (codebase *codebase) ExecHook(hookType string, args []string, reader io.Reader, writer io.Writer) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	hooks, err := man.GetHooks(codebase.localPath)
+	if err != nil {
+		return err
+	}
+
+	// buffer the input to forward it to each script
+	var input []byte
+	if reader != nil {
+		if input, err = ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	}
+
+	// execute the pre-existing hook first
+	chainedPath := filepath.Join(codebase.hooksDir(codebase.localPath), hookType+chainedHookSuffix)
+	if fileExists(chainedPath) {
+		command := exec.Command(chainedPath, args...)
+		command.Stdin = bytes.NewReader(input)
+		command.Stdout = writer
+		command.Stderr = writer
+
+		if err := command.Run(); err != nil {
+			return err
+		}
+	}
+
+	for _, scriptName := range hooks[hookType] {
+		err := codebase.run(context.Background(), codebase.localPath, scriptName, args, bytes.NewReader(input), writer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// installHooks install the shims of the hooks of the project located at path
+// and remove the shims of the hooks that are not set anymore
+func (codebase *codebase) installHooks(man manifest.Manifest, path string) error {
+	hooks, err := man.GetHooks(path)
+	if err != nil {
+		return err
+	}
+
+	for _, hookType := range hookTypes {
+		if _, exist := hooks[hookType]; exist {
+			if err := codebase.installHook(path, hookType); err != nil {
+				return err
+			}
+		} else if isHookShim(filepath.Join(codebase.hooksDir(path), hookType)) {
+			if err := codebase.uninstallHook(path, hookType); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// installHook install the shim of the git hook of given type for the project located at path
+// If a foreign hook is already installed, it is backed up and executed before the scripts
+func (codebase *codebase) installHook(path, hookType string) error {
+	hooksDir := codebase.hooksDir(path)
+	hookPath := filepath.Join(hooksDir, hookType)
+
+	// remove the scripts installed by previous srcode versions
+	if err := os.RemoveAll(filepath.Join(hooksDir, hookType+".d")); err != nil {
+		return err
+	}
+
+	if fileExists(hookPath) && !isHookShim(hookPath) {
+		chainedPath := hookPath + chainedHookSuffix
+		if fileExists(chainedPath) {
+			return fmt.Errorf("unable to backup %s hook: %w", hookType, ErrHookExist)
+		}
+
+		if err := os.Rename(hookPath, chainedPath); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(hookPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0750)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.WriteString(f, getHookShimContent(hookType))
+	return err
+}
+
+// replaceLegacyHook replace the pre-push hook installed by srcode <= 0.7.2 for the project located at path
+// The hook was a copy of the (legacy) `hook` script, without marker: it is replaced whatever its content
+// since the copy is stale as soon as the script has changed
+func (codebase *codebase) replaceLegacyHook(path string) error {
+	hooksDir := codebase.hooksDir(path)
+
+	// the project is not cloned
+	if !fileExists(hooksDir) {
+		return nil
+	}
+
+	if err := os.Remove(filepath.Join(hooksDir, "pre-push")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return codebase.installHook(path, "pre-push")
+}
+
+// uninstallHook remove the shim of the git hook of given type for the project located at path
+// and restore the backed up hook (if any)
+func (codebase *codebase) uninstallHook(path, hookType string) error {
+	hookPath := filepath.Join(codebase.hooksDir(path), hookType)
+
+	if isHookShim(hookPath) {
+		if err := os.Remove(hookPath); err != nil {
+			return err
+		}
+	}
+
+	if chainedPath := hookPath + chainedHookSuffix; fileExists(chainedPath) && !fileExists(hookPath) {
+		if err := os.Rename(chainedPath, hookPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (codebase *codebase) hooksDir(path string) string {
+	return filepath.Join(codebase.rootPath, path, ".git", "hooks")
+}
+
+// getHookShimContent returns the content of the shim delegating the hook execution to srcode
+func getHookShimContent(hookType string) string {
+	lines := []string{
+		"#!/bin/sh",
+		hookShimMarker + " (generated by srcode, do not edit)",
+		"if ! command -v srcode >/dev/null 2>&1; then",
+		fmt.Sprintf(`	echo "srcode not found: unable to execute %s hook" >&2`, hookType),
+		"	exit 1",
+		"fi",
+		fmt.Sprintf(`exec srcode hook exec %s "$@"`, hookType),
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// isHookShim returns true if the hook at given path has been installed by srcode
+func isHookShim(path string) bool {
+	b, err := ioutil.ReadFile(path)
+	return err == nil && strings.Contains(string(b), hookShimMarker)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// renameHookScript rename given script in the hooks
+func renameHookScript(hooks map[string][]string, oldName, newName string) {
+	for _, scripts := range hooks {
+		for i, script := range scripts {
+			if script == oldName {
+				scripts[i] = newName
+			}
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package codebase
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// mergeDriver is the name of the git merge driver of the manifest files
+	mergeDriver = "srcode-manifest"
+	// conflictsFile is the report of the conflicts found by the merge driver (in the meta git directory)
+	conflictsFile = "srcode-conflicts"
+)
+
+var (
+	// ErrManifestFormat is returned when the manifest already use the requested format
+	ErrManifestFormat = errors.New("the manifest already use this format")
+	// ErrManifestLayout is returned when the manifest already use the requested layout
+	ErrManifestLayout = errors.New("the manifest already use this layout")
+	// ErrManifestConflict is returned when the manifest has been changed differently locally and remotely
+	ErrManifestConflict = errors.New("the manifest has been changed differently locally and remotely")
+	// ErrNoManifestConflict is returned when there is no manifest conflict to resolve
+	ErrNoManifestConflict = errors.New("no manifest conflict to resolve")
+	// ErrRebaseInProgress is returned when the meta repository is in the middle of a rebase not started by srcode
+	ErrRebaseInProgress = errors.New("a rebase is in progress in the meta repository")
+)
+
+func (codebase *codebase) ConvertManifest(format string) (string, error) {
+	var name string
+	switch format {
+	case manifest.FormatJSON:
+		name = manifestFile
+	case manifest.FormatYAML:
+		name = "manifest.yaml"
+	default:
+		return "", fmt.Errorf("unable to convert manifest to %s: %w", format, manifest.ErrUnsupportedFormat)
+	}
+
+	current := codebase.manifestName()
+	if manifest.FormatOf(current) == format {
+		return "", fmt.Errorf("unable to convert manifest %s to %s: %w", current, format, ErrManifestFormat)
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return "", err
+	}
+
+	if err := codebase.manProvider.Write(filepath.Join(codebase.rootPath, metaDir, name), man); err != nil {
+		return "", err
+	}
+
+	if err := os.Remove(filepath.Join(codebase.rootPath, metaDir, current)); err != nil {
+		return "", err
+	}
+
+	msg := fmt.Sprintf("Convert manifest to %s", format)
+	// the files of the split layout are converted too
+	if err := codebase.repo.CommitFiles(msg, append(codebase.manifestCommitFiles(), current)...); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (codebase *codebase) SplitManifest() error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	if man.Layout == manifest.LayoutSplit {
+		return fmt.Errorf("unable to split manifest %s: %w", codebase.manifestName(), ErrManifestLayout)
+	}
+
+	man.Layout = manifest.LayoutSplit
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	return codebase.repo.CommitFiles("Split manifest into one file per project", codebase.manifestCommitFiles()...)
+}
+
+// migrateManifest write back (and commit) the manifest created by an older version of srcode
+func (codebase *codebase) migrateManifest() error {
+	// nothing to migrate (yet)
+	if _, err := os.Stat(codebase.manifestPath()); err != nil {
+		return nil
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	if man.Version >= manifest.CurrentVersion {
+		return nil
+	}
+
+	previousVersion := man.Version
+	man.Version = manifest.CurrentVersion
+
+	// read before the manifest is written back
+	var migratedHooks []string
+	if previousVersion == 0 {
+		if migratedHooks, err = manifest.MigratedHooks(codebase.manifestPath()); err != nil {
+			return err
+		}
+	}
+
+	if err := codebase.writeManifest(man); err != nil {
+		return err
+	}
+
+	for _, path := range migratedHooks {
+		if err := codebase.replaceLegacyHook(path); err != nil {
+			return err
+		}
+	}
+
+	msg := fmt.Sprintf("Migrate manifest from version %d to %d", previousVersion, manifest.CurrentVersion)
+	return codebase.repo.CommitFiles(msg, codebase.manifestCommitFiles()...)
+}
+
+// registerMergeDriver register `srcode manifest merge` as git merge driver of the manifest files
+// The driver is stored in the local configuration of the meta repository (with the git attributes)
+// since it depends on the path of the srcode executable
+func (codebase *codebase) registerMergeDriver() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if err := codebase.repo.SetConfig("merge."+mergeDriver+".name", "srcode manifest merge driver"); err != nil {
+		return err
+	}
+
+	driver := fmt.Sprintf(
+		"%s manifest merge --report %s %%O %%A %%B %%P", shellQuote(executable), shellQuote(codebase.conflictsPath()),
+	)
+	if err := codebase.repo.SetConfig("merge."+mergeDriver+".driver", driver); err != nil {
+		return err
+	}
+
+	var patterns []string
+	for _, name := range manifestFiles {
+		patterns = append(patterns, "/"+name)
+	}
+	patterns = append(patterns, "/"+manifest.ProjectsDir+"/**", "/"+manifest.ScriptsDir+"/**")
+
+	// keep the existing attributes
+	attributesPath := filepath.Join(codebase.rootPath, metaDir, ".git", "info", "attributes")
+
+	b, err := ioutil.ReadFile(attributesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	content := string(b)
+	for _, pattern := range patterns {
+		line := fmt.Sprintf("%s merge=%s", pattern, mergeDriver)
+		if existing[line] {
+			continue
+		}
+
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += line + "\n"
+	}
+
+	if content == string(b) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(attributesPath), 0750); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(attributesPath, []byte(content), 0640)
+}
+
+// conflictsPath returns the path of the report of the conflicts found by the merge driver
+func (codebase *codebase) conflictsPath() string {
+	return filepath.Join(codebase.rootPath, metaDir, ".git", conflictsFile)
+}
+
+// manifestConflicts returns the error listing the conflicts reported by the merge driver while pulling (nil if none)
+// Since the pull is a rebase, ours are the remote changes and theirs the local ones
+func (codebase *codebase) manifestConflicts() error {
+	conflicts, err := manifest.ReadConflicts(codebase.conflictsPath())
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%w, the synchronization is stopped until they are resolved:%s", ErrManifestConflict, formatConflicts(conflicts),
+	)
+}
+
+func (codebase *codebase) ResolveManifest(local bool, names []string) ([]manifest.Conflict, error) {
+	conflicts, err := manifest.ReadConflicts(codebase.conflictsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if !codebase.rebaseInProgress() || len(conflicts) == 0 {
+		return nil, ErrNoManifestConflict
+	}
+
+	selected, remaining, err := selectConflicts(conflicts, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// the values are resolved file per file
+	var files []string
+	conflictsByFile := map[string][]manifest.Conflict{}
+	for _, conflict := range selected {
+		if _, exist := conflictsByFile[conflict.File]; !exist {
+			files = append(files, conflict.File)
+		}
+		conflictsByFile[conflict.File] = append(conflictsByFile[conflict.File], conflict)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(codebase.rootPath, metaDir, filepath.FromSlash(file))
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// since the pull is a rebase, theirs are the local values
+		if b, err = manifest.ResolveFile(file, b, conflictsByFile[file], local); err != nil {
+			return nil, err
+		}
+
+		if b == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else if err := ioutil.WriteFile(path, b, 0640); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Remove(codebase.conflictsPath()); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(remaining) > 0 {
+		if err := manifest.ReportConflicts(codebase.conflictsPath(), remaining); err != nil {
+			return nil, err
+		}
+
+		return remaining, nil
+	}
+
+	// all the conflicts are resolved: mark the files as merged (including the ones of the previous resolutions)
+	var unmerged strings.Builder
+	if err := codebase.repo.RawCmd([]string{"diff", "--name-only", "-z", "--diff-filter=U"}, nil, &unmerged); err != nil {
+		return nil, err
+	}
+
+	if files := strings.Split(strings.TrimSuffix(unmerged.String(), "\x00"), "\x00"); unmerged.Len() > 0 {
+		if err := codebase.repo.RawCmd(append([]string{"add", "-A", "--"}, files...), nil, ioutil.Discard); err != nil {
+			return nil, err
+		}
+	}
+
+	// then replay the next local commits
+
+	if err := codebase.repo.RawCmd([]string{"rebase", "--continue"}, []string{"GIT_EDITOR=true"}, ioutil.Discard); err != nil {
+		if !codebase.rebaseInProgress() {
+			return nil, err
+		}
+
+		// the next local commits are conflicting too
+		conflicts, readErr := manifest.ReadConflicts(codebase.conflictsPath())
+		if readErr != nil || len(conflicts) == 0 {
+			return nil, err
+		}
+
+		return conflicts, nil
+	}
+
+	return nil, nil
+}
+
+func (codebase *codebase) AbortSync() error {
+	if !codebase.rebaseInProgress() {
+		return ErrNoManifestConflict
+	}
+
+	if err := codebase.repo.RawCmd([]string{"rebase", "--abort"}, nil, ioutil.Discard); err != nil {
+		return err
+	}
+
+	if err := os.Remove(codebase.conflictsPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// selectConflicts returns the conflicts matching the names (all if none), and the other ones
+// a conflict is named by its path (e.g `scripts > test`), optionally prefixed by its file (e.g `manifest.json: scripts > test`)
+func selectConflicts(conflicts []manifest.Conflict, names []string) ([]manifest.Conflict, []manifest.Conflict, error) {
+	if len(names) == 0 {
+		return conflicts, nil, nil
+	}
+
+	var selected, remaining []manifest.Conflict
+	matched := map[string]bool{}
+
+	for _, conflict := range conflicts {
+		path := strings.Join(conflict.Path, " > ")
+		if path == "" {
+			path = "(document)"
+		}
+
+		found := false
+		for _, name := range names {
+			if name == path || name == conflict.File+": "+path || (len(conflict.Path) == 0 && name == conflict.File) {
+				matched[name] = true
+				found = true
+			}
+		}
+
+		if found {
+			selected = append(selected, conflict)
+		} else {
+			remaining = append(remaining, conflict)
+		}
+	}
+
+	for _, name := range names {
+		if !matched[name] {
+			return nil, nil, fmt.Errorf("unable to resolve %s: %w", name, ErrNoManifestConflict)
+		}
+	}
+
+	return selected, remaining, nil
+}
+
+// formatConflicts returns the description of the conflicts, one per line
+func formatConflicts(conflicts []manifest.Conflict) string {
+	var sb strings.Builder
+	for _, conflict := range conflicts {
+		_, _ = fmt.Fprintf(&sb, "\n%s: %s", conflict.File, conflict.Format("remote", "local"))
+	}
+
+	return sb.String()
+}
+
+// rebaseInProgress returns true if the meta repository has been left in the middle of a rebase
+func (codebase *codebase) rebaseInProgress() bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(codebase.rootPath, metaDir, ".git", dir)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shellQuote quote the value to be used in a shell command (the git merge driver)
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
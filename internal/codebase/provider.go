@@ -7,6 +7,8 @@ import (
 	"github.com/creekorful/srcode/internal/fs"
 	"github.com/creekorful/srcode/internal/manifest"
 	"github.com/creekorful/srcode/internal/repository"
+	"github.com/creekorful/srcode/internal/secret"
+	"github.com/creekorful/srcode/internal/source"
 	"golang.org/x/sync/errgroup"
 	"io/ioutil"
 	"os"
@@ -23,25 +25,37 @@ var (
 	// DefaultProvider is the default codebase provider
 	DefaultProvider = &provider{
 		repoProvider:     repository.DefaultProvider,
-		manifestProvider: &manifest.JSONProvider{},
+		manifestProvider: manifest.DefaultProvider,
+		sourceProvider:   source.DefaultProvider,
+		keyring:          secret.DefaultKeyring(),
+		cache:            defaultMirrorCache(),
 	}
 )
 
 const (
 	metaDir      = ".srcode"
 	manifestFile = "manifest.json"
+	dotEnvFile   = ".env"
 )
 
-// Provider is something that allows to Init, Open, or Clone a Codebase
+// manifestFiles are the supported manifest files (looked up in this order)
+var manifestFiles = []string{manifestFile, "manifest.yaml", "manifest.yml"}
+
+// Provider is something that allows to Init, Open, Clone or Restore a Codebase
 type Provider interface {
 	Init(path, remote string, importRepositories bool) (Codebase, error)
 	Open(path string) (Codebase, error)
 	Clone(url, path string, ch chan<- ProjectEntry) (Codebase, error)
+	// Restore recreate the codebase from the backup located in dir
+	Restore(dir, path string, ch chan<- ProjectEntry) (Codebase, error)
 }
 
 type provider struct {
 	repoProvider     repository.Provider
 	manifestProvider manifest.Provider
+	sourceProvider   source.Provider
+	keyring          secret.Keyring
+	cache            mirrorCache
 }
 
 func (provider *provider) Init(path, remote string, importRepositories bool) (Codebase, error) {
@@ -65,6 +79,7 @@ func (provider *provider) Init(path, remote string, importRepositories bool) (Co
 	}
 
 	man := manifest.Manifest{
+		Version:  manifest.CurrentVersion,
 		Projects: map[string]manifest.Project{},
 	}
 
@@ -75,6 +90,11 @@ func (provider *provider) Init(path, remote string, importRepositories bool) (Co
 				return nil
 			}
 
+			// the linked worktrees belong to an existing project
+			if repository.IsWorktree(innerPath) {
+				return filepath.SkipDir
+			}
+
 			if !provider.repoProvider.Exists(innerPath) {
 				return nil
 			}
@@ -129,11 +149,17 @@ func (provider *provider) Init(path, remote string, importRepositories bool) (Co
 		}
 	}
 
+	// the cache is best-effort
+	_ = provider.cache.register(path)
+
 	return &codebase{
-		rootPath:     path,
-		repoProvider: provider.repoProvider,
-		repo:         repo,
-		manProvider:  provider.manifestProvider,
+		rootPath:       path,
+		repoProvider:   provider.repoProvider,
+		repo:           repo,
+		manProvider:    provider.manifestProvider,
+		sourceProvider: provider.sourceProvider,
+		keyring:        provider.keyring,
+		cache:          provider.cache,
 	}, nil
 }
 
@@ -174,13 +200,26 @@ func (provider *provider) Open(path string) (Codebase, error) {
 		return nil, fmt.Errorf("error while opening codebase at %s: %w", path, err)
 	}
 
-	return &codebase{
-		rootPath:     rootPath,
-		localPath:    localPath,
-		repoProvider: provider.repoProvider,
-		repo:         repo,
-		manProvider:  provider.manifestProvider,
-	}, nil
+	// the cache is best-effort
+	_ = provider.cache.register(rootPath)
+
+	codebase := &codebase{
+		rootPath:       rootPath,
+		localPath:      localPath,
+		repoProvider:   provider.repoProvider,
+		repo:           repo,
+		manProvider:    provider.manifestProvider,
+		sourceProvider: provider.sourceProvider,
+		keyring:        provider.keyring,
+		cache:          provider.cache,
+	}
+
+	// the manifest created by an older version of srcode is upgraded to the current format
+	if err := codebase.migrateManifest(); err != nil {
+		return nil, fmt.Errorf("error while migrating manifest: %w", err)
+	}
+
+	return codebase, nil
 }
 
 func (provider *provider) Clone(url, path string, ch chan<- ProjectEntry) (Codebase, error) {
@@ -198,16 +237,22 @@ func (provider *provider) Clone(url, path string, ch chan<- ProjectEntry) (Codeb
 		return nil, fmt.Errorf("error while cloning codebase at %s: %w", path, ErrCodebaseAlreadyExist)
 	}
 
-	repo, err := provider.repoProvider.Clone(url, filepath.Join(path, metaDir))
+	repo, err := provider.repoProvider.Clone(url, filepath.Join(path, metaDir), repository.CloneOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("error while cloning codebase: %w", err)
 	}
 
+	// the cache is best-effort
+	_ = provider.cache.register(path)
+
 	codebase := &codebase{
-		rootPath:     path,
-		repoProvider: provider.repoProvider,
-		repo:         repo,
-		manProvider:  provider.manifestProvider,
+		rootPath:       path,
+		repoProvider:   provider.repoProvider,
+		repo:           repo,
+		manProvider:    provider.manifestProvider,
+		sourceProvider: provider.sourceProvider,
+		keyring:        provider.keyring,
+		cache:          provider.cache,
 	}
 
 	man, err := codebase.readManifest()
@@ -222,8 +267,11 @@ func (provider *provider) Clone(url, path string, ch chan<- ProjectEntry) (Codeb
 		project := project
 
 		g.Go(func() error {
-			_, err := provider.repoProvider.Clone(project.Remote, filepath.Join(path, projectPath))
-			if err != nil {
+			if project.IsGit() {
+				if _, err := codebase.cloneProject(projectPath, project); err != nil {
+					return err
+				}
+			} else if _, err := codebase.syncSource(projectPath, project); err != nil {
 				return err
 			}
 
@@ -249,6 +297,17 @@ func (provider *provider) Clone(url, path string, ch chan<- ProjectEntry) (Codeb
 	return codebase, nil
 }
 
+// findManifestFile returns the name of the manifest file of the codebase located at rootPath
+func findManifestFile(rootPath string) string {
+	for _, name := range manifestFiles {
+		if _, err := os.Stat(filepath.Join(rootPath, metaDir, name)); err == nil {
+			return name
+		}
+	}
+
+	return manifestFile
+}
+
 func codebaseExists(path string) (bool, error) {
 	_, err := os.Stat(filepath.Join(path, metaDir))
 	if err == nil {
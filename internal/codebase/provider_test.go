@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/creekorful/srcode/internal/manifest"
 	"github.com/creekorful/srcode/internal/manifest_mock"
+	"github.com/creekorful/srcode/internal/repository"
 	"github.com/creekorful/srcode/internal/repository_mock"
 	"github.com/golang/mock/gomock"
 	"io/ioutil"
@@ -90,6 +91,14 @@ func TestProvider_Init_WithImport(t *testing.T) {
 	if err := os.MkdirAll(filepath.Join(targetDir, "php"), 0750); err != nil {
 		t.Fatal(err)
 	}
+	// a linked worktree of the php project
+	if err := os.MkdirAll(filepath.Join(targetDir, "php@review"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	gitDir := "gitdir: " + filepath.Join(targetDir, "php", ".git", "worktrees", "php@review")
+	if err := ioutil.WriteFile(filepath.Join(targetDir, "php@review", ".git"), []byte(gitDir), 0640); err != nil {
+		t.Fatal(err)
+	}
 
 	// simulate opening of these projects
 	repoProviderMock.EXPECT().Exists(targetDir).Return(false)
@@ -267,6 +276,50 @@ func TestProvider_Open_InSubDirectory(t *testing.T) {
 	}
 }
 
+func TestProvider_Open_MigrateManifest(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	repoProviderMock := repository_mock.NewMockProvider(mockCtrl)
+	repoMock := repository_mock.NewMockRepository(mockCtrl)
+	manifestProviderMock := manifest_mock.NewMockProvider(mockCtrl)
+
+	provider := provider{repoProvider: repoProviderMock, manifestProvider: manifestProviderMock}
+
+	targetDir := filepath.Join(t.TempDir(), "test-directory")
+
+	// Simulate an existing codebase
+	if err := os.MkdirAll(filepath.Join(targetDir, metaDir), 0770); err != nil {
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(filepath.Join(targetDir, metaDir, manifestFile), []byte("{}"), 0640); err != nil {
+		t.FailNow()
+	}
+
+	legacy := manifest.Manifest{Projects: map[string]manifest.Project{"a": {Remote: "a.git"}}}
+	migrated := manifest.Manifest{Version: manifest.CurrentVersion, Projects: legacy.Projects}
+
+	// the legacy manifest is written back & committed
+	repoProviderMock.EXPECT().Open(filepath.Join(targetDir, metaDir)).Return(repoMock, nil)
+	manifestProviderMock.EXPECT().Read(filepath.Join(targetDir, metaDir, manifestFile)).Return(legacy, nil)
+	manifestProviderMock.EXPECT().Write(filepath.Join(targetDir, metaDir, manifestFile), migrated).Return(nil)
+	repoMock.EXPECT().CommitFiles(fmt.Sprintf("Migrate manifest from version 0 to %d", manifest.CurrentVersion), manifestFile).Return(nil)
+
+	if _, err := provider.Open(targetDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the up-to-date (or newer) manifests are left untouched
+	for _, version := range []int{manifest.CurrentVersion, manifest.CurrentVersion + 1} {
+		repoProviderMock.EXPECT().Open(filepath.Join(targetDir, metaDir)).Return(repoMock, nil)
+		manifestProviderMock.EXPECT().Read(filepath.Join(targetDir, metaDir, manifestFile)).Return(manifest.Manifest{Version: version}, nil)
+
+		if _, err := provider.Open(targetDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestProvider_Clone_CodebaseExist(t *testing.T) {
 	provider := provider{}
 
@@ -297,8 +350,8 @@ func TestProvider_Clone(t *testing.T) {
 
 	buffer := ""
 	ch := make(chan ProjectEntry)
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		for entry := range ch {
 			buffer = fmt.Sprintf("%s\n%s %s", buffer, entry.Path, entry.Project.Remote)
 		}
@@ -307,14 +360,14 @@ func TestProvider_Clone(t *testing.T) {
 
 	// Cloning has fail
 	repoProviderMock.EXPECT().
-		Clone("test-remote", filepath.Join(targetDir, metaDir)).
+		Clone("test-remote", filepath.Join(targetDir, metaDir), repository.CloneOptions{}).
 		Return(nil, errors.New("test error"))
 	if _, err := provider.Clone("test-remote", targetDir, nil); err == nil {
 		t.Fail()
 	}
 
 	repoProviderMock.EXPECT().
-		Clone("test-remote", filepath.Join(targetDir, metaDir)).
+		Clone("test-remote", filepath.Join(targetDir, metaDir), repository.CloneOptions{}).
 		Return(nil, nil)
 
 	// simulate codebase structure
@@ -336,7 +389,8 @@ func TestProvider_Clone(t *testing.T) {
 					Scripts: map[string][]string{
 						"lint-12": {"go lint"},
 					},
-					Hook: "lint-12",
+					Hooks: map[string][]string{"pre-push": {"lint-12"}},
+					Clone: &manifest.CloneOptions{Depth: 1, Submodules: true},
 				},
 				"test-another": {
 					Remote: "git@example.org:example/test.git",
@@ -344,7 +398,7 @@ func TestProvider_Clone(t *testing.T) {
 					Scripts: map[string][]string{
 						"lint-global": {"@global-lint"},
 					},
-					Hook: "lint-global",
+					Hooks: map[string][]string{"pre-push": {"lint-global"}},
 				},
 			},
 			Scripts: map[string][]string{
@@ -354,14 +408,14 @@ func TestProvider_Clone(t *testing.T) {
 	// We should clone the projects & configure them
 	repoMock := repository_mock.NewMockRepository(mockCtrl)
 	repoProviderMock.EXPECT().
-		Clone("https://example.org/test.git", filepath.Join(targetDir, "test", "12"))
+		Clone("https://example.org/test.git", filepath.Join(targetDir, "test", "12"), repository.CloneOptions{Depth: 1, Submodules: true})
 	repoProviderMock.EXPECT().
 		Open(filepath.Join(targetDir, "test", "12")).Return(repoMock, nil)
 	repoMock.EXPECT().SetConfig("user.name", "Aloïs Micard").Return(nil)
 
 	repoMock = repository_mock.NewMockRepository(mockCtrl)
 	repoProviderMock.EXPECT().
-		Clone("git@example.org:example/test.git", filepath.Join(targetDir, "test-another"))
+		Clone("git@example.org:example/test.git", filepath.Join(targetDir, "test-another"), repository.CloneOptions{})
 	repoProviderMock.EXPECT().
 		Open(filepath.Join(targetDir, "test-another")).Return(repoMock, nil)
 	repoMock.EXPECT().SetConfig("user.email", "alois@micard.lu").Return(nil)
@@ -396,7 +450,7 @@ func TestProvider_Clone(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	if string(b) != "go lint" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Fatalf("got: %s want: go lint", string(b))
 	}
 
@@ -405,7 +459,7 @@ func TestProvider_Clone(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	if string(b) != "golint -w" {
+	if string(b) != getHookShimContent("pre-push") {
 		t.Fatal()
 	}
 }
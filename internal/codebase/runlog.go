@@ -0,0 +1,172 @@
+package codebase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// runsDir is the directory (inside the meta directory) where the run logs are stored
+	runsDir = "runs"
+	// maxRunLogs is the number of run logs to keep
+	maxRunLogs = 100
+	// runOutputNotRecorded is the output recorded for the scripts executed in a terminal
+	runOutputNotRecorded = "(the output has not been recorded: the script has been executed in a terminal)\n"
+)
+
+var (
+	// ErrRunLogNotFound is returned when no run log exist with given id
+	ErrRunLogNotFound = errors.New("no run log found")
+)
+
+// RunLog is the record of a script execution
+type RunLog struct {
+	ID        string        `json:"id"`
+	Project   string        `json:"project"`
+	Script    string        `json:"script"`
+	Args      []string      `json:"args,omitempty"`
+	Head      string        `json:"head,omitempty"`
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exitCode"`
+}
+
+// Failed returns true if the script has failed
+func (runLog RunLog) Failed() bool {
+	return runLog.ExitCode != 0
+}
+
+func (codebase *codebase) RunLogs(scriptName string) ([]RunLog, error) {
+	logs, err := codebase.readRunLogs()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []RunLog
+	for _, runLog := range logs {
+		if scriptName != "" && runLog.Script != scriptName {
+			continue
+		}
+		if codebase.localPath != "" && runLog.Project != codebase.localPath {
+			continue
+		}
+
+		res = append(res, runLog)
+	}
+
+	return res, nil
+}
+
+func (codebase *codebase) RunOutput(id string) (string, error) {
+	// prevent path traversal
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		return "", ErrRunLogNotFound
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(codebase.runsPath(), id+".log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrRunLogNotFound
+		}
+
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// createRunLog create the files used to record the execution of a script
+// the output file is returned and must be closed by the caller
+func (codebase *codebase) createRunLog(runLog *RunLog) (*os.File, error) {
+	if err := os.MkdirAll(codebase.runsPath(), 0750); err != nil {
+		return nil, err
+	}
+
+	// make sure the run logs are never committed
+	ignoreFile := filepath.Join(codebase.runsPath(), ".gitignore")
+	if _, err := os.Stat(ignoreFile); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(ignoreFile, []byte("*\n"), 0640); err != nil {
+			return nil, err
+		}
+	}
+
+	// the project is part of the id since the same script may be executed concurrently in several projects
+	id := []string{runLog.StartedAt.UTC().Format("20060102T150405.000000")}
+	if runLog.Project != "" {
+		id = append(id, runLog.Project)
+	}
+	id = append(id, runLog.Script)
+	runLog.ID = strings.NewReplacer("/", "_", "\\", "_").Replace(strings.Join(id, "-"))
+
+	return os.OpenFile(filepath.Join(codebase.runsPath(), runLog.ID+".log"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+}
+
+// writeRunLog persist given run log and rotate the existing ones
+func (codebase *codebase) writeRunLog(runLog RunLog) error {
+	b, err := json.MarshalIndent(runLog, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the run logs may be read (rotated) concurrently: make sure they are never partially written
+	path := filepath.Join(codebase.runsPath(), runLog.ID+".json")
+	if err := ioutil.WriteFile(path+".tmp", b, 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// rotate the logs
+	logs, err := codebase.readRunLogs()
+	if err != nil {
+		return err
+	}
+
+	for i := maxRunLogs; i < len(logs); i++ {
+		_ = os.Remove(filepath.Join(codebase.runsPath(), logs[i].ID+".json"))
+		_ = os.Remove(filepath.Join(codebase.runsPath(), logs[i].ID+".log"))
+	}
+
+	return nil
+}
+
+// readRunLogs returns the existing run logs, the most recent first
+func (codebase *codebase) readRunLogs() ([]RunLog, error) {
+	files, err := filepath.Glob(filepath.Join(codebase.runsPath(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []RunLog
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var runLog RunLog
+		if err := json.Unmarshal(b, &runLog); err != nil {
+			return nil, fmt.Errorf("invalid run log %s: %w", file, err)
+		}
+
+		logs = append(logs, runLog)
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].StartedAt.After(logs[j].StartedAt)
+	})
+
+	return logs, nil
+}
+
+func (codebase *codebase) runsPath() string {
+	return filepath.Join(codebase.rootPath, metaDir, runsDir)
+}
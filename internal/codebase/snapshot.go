@@ -0,0 +1,356 @@
+package codebase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"github.com/creekorful/srcode/internal/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotsDir is the directory (inside the meta directory) where the snapshots are stored
+const snapshotsDir = "snapshots"
+
+var (
+	// ErrSnapshotNotFound is returned when no snapshot exist with given name
+	ErrSnapshotNotFound = errors.New("no snapshot found")
+	// ErrSnapshotExist is returned when a snapshot with the same name already exist
+	ErrSnapshotExist = errors.New("a snapshot with the same name already exist")
+	// ErrInvalidSnapshotName is returned when the snapshot name cannot be used as a file name
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// Snapshot is the state (HEAD commit & branch) of the codebase projects at a given time
+type Snapshot struct {
+	Name      string                     `json:"name"`
+	CreatedAt time.Time                  `json:"createdAt"`
+	Projects  map[string]SnapshotProject `json:"projects"`
+}
+
+// SnapshotProject is the state of a project
+type SnapshotProject struct {
+	Remote string `json:"remote"`
+	// Branch is the checked out branch (empty if HEAD is detached)
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit"`
+}
+
+// SnapshotEntry is the result of the restoration of a project
+type SnapshotEntry struct {
+	Path   string
+	Commit string
+	Err    error
+}
+
+// SnapshotDiff is the difference of a project between two snapshots
+type SnapshotDiff struct {
+	Path string
+	// From is the commit in the first snapshot (empty if the project has been added)
+	From string
+	// To is the commit in the second snapshot (empty if the project has been removed)
+	To string
+	// Added are the commits reachable from To but not from From
+	Added []repository.Commit
+	// Removed are the commits reachable from From but not from To
+	Removed []repository.Commit
+	Err     error
+}
+
+func (codebase *codebase) SaveSnapshot(name string, force bool) (Snapshot, error) {
+	if !isValidSnapshotName(name) {
+		return Snapshot{}, ErrInvalidSnapshotName
+	}
+
+	if _, err := os.Stat(codebase.snapshotPath(name)); err == nil && !force {
+		return Snapshot{}, fmt.Errorf("unable to save snapshot %s: %w", name, ErrSnapshotExist)
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot := Snapshot{
+		Name:      name,
+		CreatedAt: time.Now(),
+		Projects:  map[string]SnapshotProject{},
+	}
+
+	for path, project := range man.Projects {
+		// only the git repositories can be pinned to a commit
+		if !project.IsGit() {
+			continue
+		}
+
+		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		commit, err := repo.HeadCommit()
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		branch, err := repo.Head()
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if branch == "HEAD" {
+			branch = ""
+		}
+
+		snapshot.Projects[path] = SnapshotProject{Remote: project.Remote, Branch: branch, Commit: commit}
+	}
+
+	if err := os.MkdirAll(filepath.Join(codebase.rootPath, metaDir, snapshotsDir), 0750); err != nil {
+		return Snapshot{}, err
+	}
+
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if err := ioutil.WriteFile(codebase.snapshotPath(name), b, 0640); err != nil {
+		return Snapshot{}, err
+	}
+
+	msg := fmt.Sprintf("Save snapshot `%s`", name)
+	if err := codebase.repo.CommitFiles(msg, filepath.Join(snapshotsDir, name+".json")); err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (codebase *codebase) Snapshots() ([]Snapshot, error) {
+	files, err := filepath.Glob(filepath.Join(codebase.rootPath, metaDir, snapshotsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, file := range files {
+		snapshot, err := codebase.readSnapshot(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+func (codebase *codebase) RestoreSnapshot(name string) ([]SnapshotEntry, error) {
+	snapshot, err := codebase.readSnapshot(name)
+	if err != nil {
+		return nil, err
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure no work will be lost before touching anything
+	repos := map[string]repository.Repository{}
+	var dirtyPaths []string
+	for path := range snapshot.Projects {
+		repoPath := filepath.Join(codebase.rootPath, path)
+		if !codebase.repoProvider.Exists(repoPath) {
+			continue
+		}
+
+		repo, err := codebase.repoProvider.Open(repoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		dirty, err := repo.IsDirty()
+		if err != nil {
+			return nil, err
+		}
+		if dirty {
+			dirtyPaths = append(dirtyPaths, path)
+		}
+
+		repos[path] = repo
+	}
+
+	if len(dirtyPaths) > 0 {
+		sort.Strings(dirtyPaths)
+		return nil, fmt.Errorf("unable to restore snapshot %s: %w (%s)", name, ErrProjectDirty, strings.Join(dirtyPaths, ", "))
+	}
+
+	var entries []SnapshotEntry
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for path, snapshotProject := range snapshot.Projects {
+		wg.Add(1)
+		go func(path string, snapshotProject SnapshotProject) {
+			defer wg.Done()
+
+			err := codebase.restoreProject(man, path, snapshotProject, repos[path])
+
+			mutex.Lock()
+			entries = append(entries, SnapshotEntry{Path: path, Commit: snapshotProject.Commit, Err: err})
+			mutex.Unlock()
+		}(path, snapshotProject)
+	}
+	wg.Wait()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+func (codebase *codebase) DiffSnapshots(from, to string) ([]SnapshotDiff, error) {
+	fromSnapshot, err := codebase.readSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toSnapshot, err := codebase.readSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	for path := range fromSnapshot.Projects {
+		paths[path] = true
+	}
+	for path := range toSnapshot.Projects {
+		paths[path] = true
+	}
+
+	var diffs []SnapshotDiff
+	for path := range paths {
+		diff := SnapshotDiff{
+			Path: path,
+			From: fromSnapshot.Projects[path].Commit,
+			To:   toSnapshot.Projects[path].Commit,
+		}
+
+		if diff.From == diff.To {
+			continue
+		}
+
+		if diff.From != "" && diff.To != "" {
+			diff.Added, diff.Removed, diff.Err = codebase.diffCommits(path, diff.From, diff.To)
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+
+	return diffs, nil
+}
+
+// restoreProject checkout the project at the snapshot commit, cloning the project if needed
+func (codebase *codebase) restoreProject(man manifest.Manifest, path string, snapshotProject SnapshotProject, repo repository.Repository) error {
+	if repo == nil {
+		project, exist := man.Projects[path]
+		if !exist {
+			project = manifest.Project{Remote: snapshotProject.Remote}
+		}
+
+		cloned, err := codebase.cloneProject(path, project)
+		if err != nil {
+			return err
+		}
+		repo = cloned
+
+		if exist {
+			if err := codebase.configureProject(man, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the commit may not have been fetched yet
+	if err := repo.Checkout(snapshotProject.Commit); err != nil {
+		if fetchErr := repo.Fetch("origin"); fetchErr != nil {
+			return err
+		}
+
+		return repo.Checkout(snapshotProject.Commit)
+	}
+
+	return nil
+}
+
+// diffCommits returns the commits added & removed between from and to
+func (codebase *codebase) diffCommits(path, from, to string) ([]repository.Commit, []repository.Commit, error) {
+	repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	added, err := repo.Commits(from, to)
+	if err != nil {
+		// the commits may not have been fetched yet
+		if fetchErr := repo.Fetch("origin"); fetchErr != nil {
+			return nil, nil, err
+		}
+
+		if added, err = repo.Commits(from, to); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	removed, err := repo.Commits(to, from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return added, removed, nil
+}
+
+func (codebase *codebase) readSnapshot(name string) (Snapshot, error) {
+	if !isValidSnapshotName(name) {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	b, err := ioutil.ReadFile(codebase.snapshotPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Snapshot{}, fmt.Errorf("unable to read snapshot %s: %w", name, ErrSnapshotNotFound)
+		}
+
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("invalid snapshot %s: %w", name, err)
+	}
+
+	return snapshot, nil
+}
+
+func (codebase *codebase) snapshotPath(name string) string {
+	return filepath.Join(codebase.rootPath, metaDir, snapshotsDir, name+".json")
+}
+
+// isValidSnapshotName returns true if the name can be safely used as a file name
+func isValidSnapshotName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\")
+}
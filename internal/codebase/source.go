@@ -0,0 +1,41 @@
+package codebase
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"path/filepath"
+)
+
+// ErrNotGitProject is returned when a git operation is requested on a project which is not a git repository
+var ErrNotGitProject = errors.New("the project is not a git repository")
+
+// syncSource materialise (or update) the non-git project at given path
+// It returns false if the project was already up-to-date
+func (codebase *codebase) syncSource(path string, project manifest.Project) (bool, error) {
+	src, err := codebase.sourceProvider.Source(project.SourceType())
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := src.Sync(*project.Source, filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return false, fmt.Errorf("unable to sync %s: %w", path, err)
+	}
+
+	return updated, nil
+}
+
+// gitProject returns an error if the project at given path is not a git repository
+func gitProject(man manifest.Manifest, path string) error {
+	project, exist := man.Projects[path]
+	if !exist {
+		return manifest.ErrNoProjectFound
+	}
+
+	if !project.IsGit() {
+		return fmt.Errorf("unable to use %s: %w", path, ErrNotGitProject)
+	}
+
+	return nil
+}
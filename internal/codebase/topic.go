@@ -0,0 +1,327 @@
+package codebase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/repository"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// topicsDir is the directory (inside the meta directory) where the topics are stored
+	topicsDir = "topics"
+	// topicsFile is the file (inside the topics directory) containing the topics state
+	topicsFile = "topics.json"
+)
+
+var (
+	// ErrTopicNotFound is returned when no topic exist with given name
+	ErrTopicNotFound = errors.New("no topic found")
+	// ErrNoCurrentTopic is returned when no topic is specified and none is in progress
+	ErrNoCurrentTopic = errors.New("no topic in progress")
+	// ErrNoProjectSelected is returned when a topic is started without projects
+	ErrNoProjectSelected = errors.New("no project selected")
+)
+
+// Topic is a branch spanning multiple projects
+type Topic struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Projects map the project path to the branch (or commit if detached) it was on when the topic was started
+	Projects map[string]string `json:"projects"`
+}
+
+// TopicProjectStatus is the status of a project of a topic
+type TopicProjectStatus struct {
+	Path string
+	// Head is the branch the project is currently on
+	Head string
+	// Base is the branch (or commit if detached) the project was on when the topic was started
+	Base string
+	// Commits are the commits of the topic branch not in the base branch
+	Commits []repository.Commit
+	Dirty   bool
+	Err     error
+}
+
+// topicsState is the local (i.e never committed) state of the topics
+type topicsState struct {
+	Current string           `json:"current,omitempty"`
+	Topics  map[string]Topic `json:"topics,omitempty"`
+}
+
+func (codebase *codebase) StartTopic(name string, paths []string) ([]CheckoutEntry, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// default to the current project
+	if len(paths) == 0 {
+		if _, exist := man.Projects[codebase.localPath]; !exist {
+			return nil, ErrNoProjectSelected
+		}
+		paths = []string{"."}
+	}
+
+	for i, path := range paths {
+		paths[i] = filepath.Join(codebase.localPath, path)
+		if err := gitProject(man, paths[i]); err != nil {
+			return nil, fmt.Errorf("unable to start topic %s in %s: %w", name, paths[i], err)
+		}
+	}
+
+	state, err := codebase.readTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	// starting an existing topic add the projects to it
+	topic, exist := state.Topics[name]
+	if !exist {
+		topic = Topic{Name: name, CreatedAt: time.Now(), Projects: map[string]string{}}
+	}
+
+	var entries []CheckoutEntry
+	for _, path := range paths {
+		if _, exist := topic.Projects[path]; exist {
+			continue
+		}
+
+		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+		if err != nil {
+			return nil, err
+		}
+
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		// on detached HEAD, the topic is based on the tracked branch (or on the commit if none)
+		base := head
+		if head == "HEAD" {
+			if base = man.Projects[path].Branch; base == "" {
+				if base, err = repo.HeadCommit(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		entry := CheckoutEntry{Path: path, Head: head, Branch: name, Err: repo.CreateBranch(name)}
+		if entry.Err == nil {
+			topic.Projects[path] = base
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(topic.Projects) > 0 {
+		state.Topics[name] = topic
+		state.Current = name
+	}
+
+	if err := codebase.writeTopics(state); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (codebase *codebase) TopicStatus(name string) (Topic, []TopicProjectStatus, error) {
+	state, err := codebase.readTopics()
+	if err != nil {
+		return Topic{}, nil, err
+	}
+
+	topic, err := state.topic(name)
+	if err != nil {
+		return Topic{}, nil, err
+	}
+
+	var statuses []TopicProjectStatus
+	for _, path := range topic.paths() {
+		status := TopicProjectStatus{Path: path, Base: topic.Projects[path]}
+		status.Head, status.Commits, status.Dirty, status.Err = codebase.topicProjectStatus(path, topic.Name, status.Base)
+
+		statuses = append(statuses, status)
+	}
+
+	return topic, statuses, nil
+}
+
+func (codebase *codebase) SwitchTopic(name string) ([]CheckoutEntry, error) {
+	state, err := codebase.readTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	topic, err := state.topic(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := codebase.checkoutProjects(topic.paths(), func(path string) string {
+		return topic.Name
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state.Current = topic.Name
+	if err := codebase.writeTopics(state); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (codebase *codebase) FinishTopic(name string) ([]CheckoutEntry, error) {
+	state, err := codebase.readTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	topic, err := state.topic(name)
+	if err != nil {
+		return nil, err
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// return to the tracked branch, or to the one the project was on
+	entries, err := codebase.checkoutProjects(topic.paths(), func(path string) string {
+		if branch := man.Projects[path].Branch; branch != "" {
+			return branch
+		}
+		return topic.Projects[path]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the topic until every project has been switched back
+	for _, entry := range entries {
+		if entry.Err != nil {
+			return entries, nil
+		}
+	}
+
+	delete(state.Topics, topic.Name)
+	if state.Current == topic.Name {
+		state.Current = ""
+	}
+
+	if err := codebase.writeTopics(state); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (codebase *codebase) topicProjectStatus(path, branch, base string) (string, []repository.Commit, bool, error) {
+	repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	dirty, err := repo.IsDirty()
+	if err != nil {
+		return head, nil, false, err
+	}
+
+	commits, err := repo.Commits(base, branch)
+	if err != nil {
+		return head, nil, dirty, err
+	}
+
+	return head, commits, dirty, nil
+}
+
+func (codebase *codebase) readTopics() (topicsState, error) {
+	state := topicsState{Topics: map[string]Topic{}}
+
+	b, err := ioutil.ReadFile(filepath.Join(codebase.topicsPath(), topicsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("invalid topics file: %w", err)
+	}
+
+	if state.Topics == nil {
+		state.Topics = map[string]Topic{}
+	}
+
+	return state, nil
+}
+
+func (codebase *codebase) writeTopics(state topicsState) error {
+	if err := os.MkdirAll(codebase.topicsPath(), 0750); err != nil {
+		return err
+	}
+
+	// make sure the topics are never committed
+	ignoreFile := filepath.Join(codebase.topicsPath(), ".gitignore")
+	if _, err := os.Stat(ignoreFile); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(ignoreFile, []byte("*\n"), 0640); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(codebase.topicsPath(), topicsFile), b, 0640)
+}
+
+func (codebase *codebase) topicsPath() string {
+	return filepath.Join(codebase.rootPath, metaDir, topicsDir)
+}
+
+// topic returns the topic with given name (or the current one if name is empty)
+func (state topicsState) topic(name string) (Topic, error) {
+	if name == "" {
+		if state.Current == "" {
+			return Topic{}, ErrNoCurrentTopic
+		}
+		name = state.Current
+	}
+
+	topic, exist := state.Topics[name]
+	if !exist {
+		return Topic{}, fmt.Errorf("unable to find topic %s: %w", name, ErrTopicNotFound)
+	}
+
+	return topic, nil
+}
+
+// paths returns the sorted paths of the topic projects
+func (topic Topic) paths() []string {
+	var paths []string
+	for path := range topic.Projects {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,237 @@
+package codebase
+
+import (
+	"context"
+	"fmt"
+	"github.com/creekorful/srcode/internal/repository"
+	"github.com/fsnotify/fsnotify"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchDebounce is the time to wait after the last change before running the script
+// this allows to handle burst of changes (checkout, formatting, ...) as a single one
+const watchDebounce = 300 * time.Millisecond
+
+// WatchResult is the result of a script execution triggered by a change
+type WatchResult struct {
+	Project  string
+	Duration time.Duration
+	Err      error
+}
+
+// watchedProject is a project whose working tree is being watched
+type watchedProject struct {
+	path string
+	dir  string
+	repo repository.Repository
+
+	// the in-flight execution
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (codebase *codebase) Watch(ctx context.Context, scriptName string, args []string, projects []string, writer io.Writer, callback func(WatchResult)) error {
+	if len(projects) == 0 {
+		projects = []string{codebase.localPath}
+	}
+
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error while creating watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	var watched []*watchedProject
+	for _, path := range projects {
+		// make sure the script exist before starting
+		if _, err := man.GetScript(path, scriptName); err != nil {
+			return fmt.Errorf("error while watching %s: %w", path, err)
+		}
+
+		// the ignored files are resolved using git
+		if err := gitProject(man, path); err != nil {
+			return fmt.Errorf("error while watching %s: %w", path, err)
+		}
+
+		dir := filepath.Join(codebase.rootPath, path)
+		repo, err := codebase.repoProvider.Open(dir)
+		if err != nil {
+			return err
+		}
+
+		project := &watchedProject{path: path, dir: dir, repo: repo}
+		if err := codebase.watchDir(watcher, project, dir); err != nil {
+			return fmt.Errorf("error while watching %s: %w", path, err)
+		}
+
+		watched = append(watched, project)
+	}
+
+	// make sure the project are matched from the deepest one
+	sort.Slice(watched, func(i, j int) bool {
+		return len(watched[i].dir) > len(watched[j].dir)
+	})
+
+	results := make(chan WatchResult)
+
+	// wait for the in-flight execution of given project (if any) to finish
+	// the results received in the meantime are still reported
+	wait := func(project *watchedProject, report bool) {
+		if project.cancel == nil {
+			return
+		}
+
+		project.cancel()
+		for {
+			select {
+			case res := <-results:
+				if report {
+					callback(res)
+				}
+			case <-project.done:
+				project.cancel = nil
+				return
+			}
+		}
+	}
+
+	start := func(project *watchedProject) {
+		wait(project, true)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		project.cancel = cancel
+		project.done = make(chan struct{})
+
+		go func(done chan struct{}) {
+			defer close(done)
+
+			startedAt := time.Now()
+			err := codebase.run(runCtx, project.path, scriptName, args, nil, writer)
+
+			// the execution has been cancelled by a new change: nothing to report
+			if runCtx.Err() != nil {
+				return
+			}
+
+			results <- WatchResult{Project: project.path, Duration: time.Since(startedAt), Err: err}
+		}(project.done)
+	}
+
+	// Run the script once before waiting for changes
+	for _, project := range watched {
+		start(project)
+	}
+
+	changes := map[*watchedProject][]string{}
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			project := findWatchedProject(watched, event.Name)
+			if project == nil {
+				continue
+			}
+
+			// watch the new directories
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					_ = codebase.watchDir(watcher, project, event.Name)
+				}
+			}
+
+			changes[project] = append(changes[project], event.Name)
+			debounce.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			return fmt.Errorf("error while watching: %w", err)
+		case <-debounce.C:
+			for project, paths := range changes {
+				if project.hasChanged(paths) {
+					start(project)
+				}
+			}
+
+			changes = map[*watchedProject][]string{}
+		case res := <-results:
+			callback(res)
+		case <-ctx.Done():
+			for _, project := range watched {
+				wait(project, false)
+			}
+
+			return nil
+		}
+	}
+}
+
+// watchDir add given directory of the project (and its sub directories) to the watcher
+// the ignored directories, and the nested Git repositories are skipped
+func (codebase *codebase) watchDir(watcher *fsnotify.Watcher, project *watchedProject, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path != project.dir {
+			if info.Name() == ".git" || codebase.repoProvider.Exists(path) || repository.IsWorktree(path) {
+				return filepath.SkipDir
+			}
+
+			if ignored, err := project.repo.IsIgnored(path); err == nil && ignored {
+				return filepath.SkipDir
+			}
+		}
+
+		return watcher.Add(path)
+	})
+}
+
+// hasChanged returns true if at least one of given paths is not ignored
+func (project *watchedProject) hasChanged(paths []string) bool {
+	for _, path := range paths {
+		rel, err := filepath.Rel(project.dir, path)
+		if err != nil || rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+			continue
+		}
+
+		if ignored, err := project.repo.IsIgnored(path); err != nil || !ignored {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findWatchedProject returns the project containing given path
+func findWatchedProject(projects []*watchedProject, path string) *watchedProject {
+	for _, project := range projects {
+		if path == project.dir || strings.HasPrefix(path, project.dir+string(filepath.Separator)) {
+			return project
+		}
+	}
+
+	return nil
+}
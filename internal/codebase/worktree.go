@@ -0,0 +1,154 @@
+package codebase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultWorktreeLayout is the layout used when the manifest doesn't define one (e.g Work/api@feature-x)
+const defaultWorktreeLayout = "{project}@{branch}"
+
+var (
+	// ErrWorktreeNotFound is returned when the project has no worktree for given branch
+	ErrWorktreeNotFound = errors.New("no worktree found")
+	// ErrWorktreeExist is returned when the worktree path is already used
+	ErrWorktreeExist = errors.New("a file already exist at the worktree path")
+)
+
+// Worktree is a linked worktree of a project
+type Worktree struct {
+	// Path is the path of the worktree, relative to the codebase root (absolute if outside the codebase)
+	Path string
+	// Branch is the checked out branch (empty if HEAD is detached)
+	Branch string
+	Commit string
+}
+
+func (codebase *codebase) AddWorktree(path, branch string) (Worktree, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	path = filepath.Join(codebase.localPath, path)
+	if err := gitProject(man, path); err != nil {
+		return Worktree{}, err
+	}
+
+	worktreePath := getWorktreePath(man.WorktreeLayout, path, branch)
+	fullPath := filepath.Join(codebase.rootPath, worktreePath)
+	if _, err := os.Stat(fullPath); err == nil {
+		return Worktree{}, fmt.Errorf("unable to add worktree at %s: %w", worktreePath, ErrWorktreeExist)
+	}
+
+	repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	// the branch may not have been fetched yet
+	if err := repo.AddWorktree(fullPath, branch); err != nil {
+		if fetchErr := repo.Fetch("origin"); fetchErr != nil {
+			return Worktree{}, err
+		}
+
+		if err := repo.AddWorktree(fullPath, branch); err != nil {
+			return Worktree{}, err
+		}
+	}
+
+	return Worktree{Path: worktreePath, Branch: branch}, nil
+}
+
+func (codebase *codebase) Worktrees() (map[string][]Worktree, error) {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	// git returns the real path of the worktrees
+	rootPath, err := filepath.EvalSymlinks(codebase.rootPath)
+	if err != nil {
+		rootPath = codebase.rootPath
+	}
+
+	worktrees := map[string][]Worktree{}
+	for path, project := range man.Projects {
+		if !project.IsGit() {
+			continue
+		}
+
+		repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+		if err != nil {
+			return nil, err
+		}
+
+		repoWorktrees, err := repo.Worktrees()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repoWorktree := range repoWorktrees {
+			worktree := Worktree{Path: repoWorktree.Path, Branch: repoWorktree.Branch, Commit: repoWorktree.Commit}
+			if rel, err := filepath.Rel(rootPath, worktree.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				worktree.Path = rel
+			}
+
+			worktrees[path] = append(worktrees[path], worktree)
+		}
+
+		sort.Slice(worktrees[path], func(i, j int) bool {
+			return worktrees[path][i].Path < worktrees[path][j].Path
+		})
+	}
+
+	return worktrees, nil
+}
+
+func (codebase *codebase) RemoveWorktree(path, branch string, force bool) error {
+	man, err := codebase.readManifest()
+	if err != nil {
+		return err
+	}
+
+	path = filepath.Join(codebase.localPath, path)
+	if err := gitProject(man, path); err != nil {
+		return err
+	}
+
+	repo, err := codebase.repoProvider.Open(filepath.Join(codebase.rootPath, path))
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := repo.Worktrees()
+	if err != nil {
+		return err
+	}
+
+	for _, worktree := range worktrees {
+		if worktree.Branch == branch {
+			return repo.RemoveWorktree(worktree.Path, force)
+		}
+	}
+
+	return fmt.Errorf("unable to remove worktree %s of %s: %w", branch, path, ErrWorktreeNotFound)
+}
+
+// getWorktreePath returns the path (relative to the codebase root) of the project worktree
+func getWorktreePath(layout, path, branch string) string {
+	if layout == "" {
+		layout = defaultWorktreeLayout
+	}
+
+	replacer := strings.NewReplacer(
+		"{project}", filepath.Base(path),
+		"{branch}", strings.ReplaceAll(branch, "/", "-"),
+	)
+
+	return filepath.Join(filepath.Dir(path), replacer.Replace(layout))
+}
@@ -0,0 +1,116 @@
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadFile parse the dotenv file located at given path
+func ReadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse the dotenv content from given reader
+// The following syntax is supported:
+//
+//	# comment
+//	KEY=value
+//	export KEY=value
+//	KEY="value with \n escaped chars" # comment
+//	KEY='raw value'
+func Parse(reader io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// skip blank lines & comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid line %d: missing =", lineNumber)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			return nil, fmt.Errorf("invalid line %d: missing key", lineNumber)
+		}
+
+		value, err := parseValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %s", lineNumber, err)
+		}
+
+		env[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch value[0] {
+	case '\'':
+		end := strings.Index(value[1:], "'")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+
+		return value[1 : end+1], nil
+	case '"':
+		b := strings.Builder{}
+		for i := 1; i < len(value); i++ {
+			switch value[i] {
+			case '"':
+				return b.String(), nil
+			case '\\':
+				if i+1 < len(value) {
+					i++
+					switch value[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(value[i])
+					}
+				}
+			default:
+				b.WriteByte(value[i])
+			}
+		}
+
+		return "", fmt.Errorf("unterminated quoted value")
+	default:
+		// strip inline comment
+		if idx := strings.Index(value, " #"); idx != -1 {
+			value = value[:idx]
+		}
+
+		return strings.TrimSpace(value), nil
+	}
+}
@@ -0,0 +1,65 @@
+package dotenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := `
+# a comment
+GOFLAGS=-mod=mod
+export DATABASE_URL=postgres://localhost/test # inline comment
+  EMPTY=
+SINGLE='raw # value \n'
+DOUBLE="multi\nline \"quoted\""
+`
+
+	env, err := Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"GOFLAGS":      "-mod=mod",
+		"DATABASE_URL": "postgres://localhost/test",
+		"EMPTY":        "",
+		"SINGLE":       "raw # value \\n",
+		"DOUBLE":       "multi\nline \"quoted\"",
+	}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v want %v", env, expected)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, content := range []string{"INVALID", "=value", "KEY='unterminated", `KEY="unterminated`} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("%s should fails", content)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := ioutil.WriteFile(path, []byte("KEY=value\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(env, map[string]string{"KEY": "value"}) {
+		t.Errorf("got %v", env)
+	}
+
+	if _, err := ReadFile(filepath.Join(t.TempDir(), ".env")); err == nil {
+		t.Fail()
+	}
+}
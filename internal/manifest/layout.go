@@ -0,0 +1,245 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// LayoutSingle store the whole manifest in a single file (the default)
+	LayoutSingle = ""
+	// LayoutSplit store each project & each global script in its own file, next to the manifest
+	// i.e projects/<path>.<ext> and scripts/<name>.<ext>, reducing the merge conflicts
+	LayoutSplit = "split"
+
+	// ProjectsDir is the directory (relative to the manifest) containing the projects of the split layout
+	ProjectsDir = "projects"
+	// ScriptsDir is the directory (relative to the manifest) containing the global scripts of the split layout
+	ScriptsDir = "scripts"
+)
+
+// ErrUnsupportedLayout is returned when the manifest layout is not supported
+var ErrUnsupportedLayout = errors.New("unsupported manifest layout")
+
+// document is the kind of document encoded by a codec
+type document int
+
+const (
+	manifestDocument document = iota
+	projectDocument
+	scriptDocument
+)
+
+// codec encode & decode the documents of a manifest format, using their json representation
+type codec interface {
+	encode(val interface{}, doc document) ([]byte, error)
+	decode(b []byte, doc document) (interface{}, error)
+}
+
+// read the manifest at given path, assembling it from its files if the layout is split
+func read(path string, c codec) (Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	val, err := c.decode(b, manifestDocument)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	raw, ok := val.(map[string]interface{})
+	if val != nil && !ok {
+		return Manifest{}, fmt.Errorf("invalid manifest %s", path)
+	}
+
+	if raw != nil && raw["layout"] == LayoutSplit {
+		dir, ext := filepath.Dir(path), filepath.Ext(path)
+
+		if err := readDocuments(filepath.Join(dir, ProjectsDir), ext, projectDocument, c, raw, "projects"); err != nil {
+			return Manifest{}, err
+		}
+		if err := readDocuments(filepath.Join(dir, ScriptsDir), ext, scriptDocument, c, raw, "scripts"); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	// decode through json to share the json tags & the migrations
+	b, err = json.Marshal(raw)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return decode(b)
+}
+
+// write the manifest at given path, splitting it into its files if the layout is split
+func write(path string, man Manifest, c codec) error {
+	if err := checkVersion(man); err != nil {
+		return err
+	}
+
+	switch man.Layout {
+	case LayoutSingle:
+	case LayoutSplit:
+		dir, ext := filepath.Dir(path), filepath.Ext(path)
+
+		projects := map[string]interface{}{}
+		for projectPath, project := range man.Projects {
+			projects[projectPath] = project
+		}
+		if err := writeDocuments(filepath.Join(dir, ProjectsDir), ext, projectDocument, c, projects); err != nil {
+			return err
+		}
+
+		scripts := map[string]interface{}{}
+		for name, script := range man.Scripts {
+			scripts[name] = script
+		}
+		if err := writeDocuments(filepath.Join(dir, ScriptsDir), ext, scriptDocument, c, scripts); err != nil {
+			return err
+		}
+
+		man.Projects = nil
+		man.Scripts = nil
+	default:
+		return fmt.Errorf("unable to write manifest %s with layout %s: %w", path, man.Layout, ErrUnsupportedLayout)
+	}
+
+	b, err := c.encode(man, manifestDocument)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0640)
+}
+
+// readDocuments read the documents of the directory into raw[key], indexed by their path (without extension)
+// the documents already present in the manifest (i.e added by hand) are kept, unless overridden by a file
+func readDocuments(dir, ext string, doc document, c codec, raw map[string]interface{}, key string) error {
+	docs, _ := raw[key].(map[string]interface{})
+	if docs == nil {
+		docs = map[string]interface{}{}
+	}
+
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(file) != ext {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		val, err := c.decode(b, doc)
+		if err != nil {
+			return fmt.Errorf("error while reading %s: %w", file, err)
+		}
+
+		docs[filepath.ToSlash(strings.TrimSuffix(rel, ext))] = val
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(docs) > 0 {
+		raw[key] = docs
+	}
+
+	return nil
+}
+
+// writeDocuments write the documents in the directory, and remove the ones no longer present
+// the directory itself is kept, even when empty, to allow committing the removals
+func writeDocuments(dir, ext string, doc document, c codec, docs map[string]interface{}) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	files := map[string]bool{}
+	for name, val := range docs {
+		if !validName(name) {
+			return fmt.Errorf("unable to write %s: invalid name", name)
+		}
+
+		file := filepath.Join(dir, filepath.FromSlash(name)+ext)
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return err
+		}
+
+		b, err := c.encode(val, doc)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(file, b, 0640); err != nil {
+			return err
+		}
+
+		files[file] = true
+	}
+
+	// remove the stale documents (including the ones of another format) & the emptied directories
+	var dirs []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if file != dir {
+				dirs = append(dirs, file)
+			}
+			return nil
+		}
+
+		if FormatOf(file) != "" && !files[file] {
+			return os.Remove(file)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// deepest directories first
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, d := range dirs {
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			if err := os.Remove(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validName returns true if the document name is a clean relative path, not escaping its directory
+func validName(name string) bool {
+	clean := path.Clean(name)
+	return clean == name && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../") && !path.IsAbs(clean)
+}
@@ -0,0 +1,170 @@
+package manifest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitLayout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	m := Manifest{
+		Version: CurrentVersion,
+		Layout:  LayoutSplit,
+		Projects: map[string]Project{
+			"Work/api":     {Remote: "git@example.org:api.git", Scripts: map[string][]string{"test": {"go test ./..."}}},
+			"Work/api/web": {Remote: "git@example.org:web.git"},
+			"notes":        {Source: &Source{Type: SourceSymlink, URL: "~/notes"}},
+		},
+		Scripts: map[string][]string{"lint": {"golint"}},
+		Env:     map[string]string{"GOPATH": "~/go"},
+	}
+
+	p := JSONProvider{}
+	if err := p.Write(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"projects/Work/api.json", "projects/Work/api/web.json", "projects/notes.json", "scripts/lint.json"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file))); err != nil {
+			t.Errorf("%s should have been written: %s", file, err)
+		}
+	}
+
+	// the root manifest should not contain the projects & the scripts
+	root, err := decode(mustRead(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Projects) != 0 || len(root.Scripts) != 0 || root.Env["GOPATH"] != "~/go" {
+		t.Errorf("wrong root manifest: %v", root)
+	}
+
+	res, err := p.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, res) {
+		t.Errorf("got %v want %v", res, m)
+	}
+
+	// removed projects & scripts should have their files removed
+	delete(m.Projects, "Work/api")
+	delete(m.Projects, "Work/api/web")
+	m.Scripts = nil
+	if err := p.Write(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"projects/Work", "scripts/lint.json"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file))); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", file)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ScriptsDir)); err != nil {
+		t.Errorf("the scripts directory should have been kept: %s", err)
+	}
+
+	res, err = p.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, res) {
+		t.Errorf("got %v want %v", res, m)
+	}
+}
+
+func TestSplitLayout_YAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.yaml")
+
+	content := `version: 1
+layout: split
+projects:
+  bar:
+    remote: bar.git
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ProjectsDir), 0750); err != nil {
+		t.Fatal(err)
+	}
+	project := `remote: foo.git
+scripts:
+  test: |-
+    go vet ./...
+    go test ./...
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, ProjectsDir, "foo.yaml"), []byte(project), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// file of another format: ignored
+	if err := ioutil.WriteFile(filepath.Join(dir, ProjectsDir, "baz.json"), []byte(`{"remote": "baz.git"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	p := YAMLProvider{}
+
+	// the projects added by hand in the root manifest are kept
+	res, err := p.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Manifest{
+		Version: 1,
+		Layout:  LayoutSplit,
+		Projects: map[string]Project{
+			"foo": {Remote: "foo.git", Scripts: map[string][]string{"test": {"go vet ./...", "go test ./..."}}},
+			"bar": {Remote: "bar.git"},
+		},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %v want %v", res, want)
+	}
+
+	// and moved into their own file on write
+	if err := p.Write(path, res); err != nil {
+		t.Fatal(err)
+	}
+	if b := mustRead(t, path); string(b) != "version: 1\nlayout: split\n" {
+		t.Errorf("wrong root manifest: %s", b)
+	}
+	if b := mustRead(t, filepath.Join(dir, ProjectsDir, "foo.yaml")); string(b) != project {
+		t.Errorf("wrong project file: %s", b)
+	}
+	if b := mustRead(t, filepath.Join(dir, ProjectsDir, "bar.yaml")); string(b) != "remote: bar.git\n" {
+		t.Errorf("wrong project file: %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ProjectsDir, "baz.json")); !os.IsNotExist(err) {
+		t.Errorf("baz.json should have been removed")
+	}
+}
+
+func TestSplitLayout_Invalid(t *testing.T) {
+	p := JSONProvider{}
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	if err := p.Write(path, Manifest{Layout: "foo"}); !errors.Is(err, ErrUnsupportedLayout) {
+		t.Errorf("got %v want ErrUnsupportedLayout", err)
+	}
+
+	m := Manifest{Layout: LayoutSplit, Projects: map[string]Project{"../foo": {Remote: "foo.git"}}}
+	if err := p.Write(path, m); err == nil {
+		t.Errorf("project escaping the projects directory should be refused")
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
@@ -2,7 +2,11 @@ package manifest
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
 var (
@@ -10,20 +14,146 @@ var (
 	ErrNoProjectFound = errors.New("no project exist at given path")
 	// ErrScriptNotFound is returned when given script is not found
 	ErrScriptNotFound = errors.New("no script with the name found")
+	// ErrSecretNotFound is returned when given secret is not found
+	ErrSecretNotFound = errors.New("no secret with the name found")
 )
 
 // Manifest is the representation of the codebase
 type Manifest struct {
-	Projects map[string]Project  `json:"projects,omitempty"`
-	Scripts  map[string][]string `json:"scripts,omitempty"`
+	// Version is the version of the manifest format (0 if the manifest predates the versioning)
+	Version int `json:"version,omitempty"`
+	// Layout is the storage layout of the manifest: a single file (the default) or LayoutSplit
+	Layout      string               `json:"layout,omitempty"`
+	Projects    map[string]Project   `json:"projects,omitempty"`
+	Scripts     map[string][]string  `json:"scripts,omitempty"`
+	Timeouts    map[string]string    `json:"timeouts,omitempty"`
+	Env         map[string]string    `json:"env,omitempty"`
+	Directories map[string]Directory `json:"directories,omitempty"`
+	// SecretsKey is the public key used to encrypt the secrets
+	SecretsKey string            `json:"secretsKey,omitempty"`
+	Secrets    map[string]string `json:"secrets,omitempty"`
+	// DefaultHooks are the hooks applied to the projects matching the rules
+	DefaultHooks []DefaultHook `json:"defaultHooks,omitempty"`
+	// WorktreeLayout is the path of the worktrees, relative to the parent directory of their project
+	// {project} is replaced by the project directory name, and {branch} by the branch name
+	WorktreeLayout string `json:"worktreeLayout,omitempty"`
 }
 
+const (
+	// SourceGit is a git repository cloned from the project remote (the default)
+	SourceGit = "git"
+	// SourceArchive is an archive (tar, tar.gz or zip) extracted in the project directory
+	SourceArchive = "archive"
+	// SourceSymlink is a symbolic link to a local directory
+	SourceSymlink = "symlink"
+)
+
 // Project is a Codebase project
 type Project struct {
-	Remote  string              `json:"remote"`
+	// Remote is the remote of the git repository (empty if the project has another source)
+	Remote string `json:"remote,omitempty"`
+	// Source is the source of the project when it is not a git repository
+	Source *Source `json:"source,omitempty"`
+	// Remotes are the additional remotes of the project (e.g `upstream` for a fork), indexed by their name
+	Remotes map[string]string `json:"remotes,omitempty"`
+	// Branch is the branch the project should be on (default to the remote HEAD)
+	Branch  string              `json:"branch,omitempty"`
 	Config  map[string]string   `json:"config,omitempty"`
 	Scripts map[string][]string `json:"scripts,omitempty"`
-	Hook    string              `json:"hook,omitempty"`
+	// Hooks map the git hook type (pre-commit, pre-push, ...) to the scripts to execute
+	Hooks    map[string][]string `json:"hooks,omitempty"`
+	Timeouts map[string]string   `json:"timeouts,omitempty"`
+	Env      map[string]string   `json:"env,omitempty"`
+	Secrets  map[string]string   `json:"secrets,omitempty"`
+	Tags     []string            `json:"tags,omitempty"`
+	// NoDefaultHooks opt-out the project from the codebase default hooks
+	NoDefaultHooks bool `json:"noDefaultHooks,omitempty"`
+	// Clone are the options used when cloning the project
+	Clone *CloneOptions `json:"clone,omitempty"`
+}
+
+// Source is the source of a project which is not a git repository
+type Source struct {
+	// Type is the type of the source (archive, symlink)
+	Type string `json:"type"`
+	// URL is the location of the archive (file://, http:// or https://), or the path of the symlink target
+	URL string `json:"url"`
+	// Checksum is the sha256 checksum of the archive
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// SourceType returns the type of the project source
+func (p Project) SourceType() string {
+	if p.Source == nil || p.Source.Type == "" {
+		return SourceGit
+	}
+
+	return p.Source.Type
+}
+
+// IsGit returns true if the project is a git repository
+func (p Project) IsGit() bool {
+	return p.SourceType() == SourceGit
+}
+
+// Location returns the remote of the project, or the URL of its source
+func (p Project) Location() string {
+	if p.IsGit() {
+		return p.Remote
+	}
+
+	return p.Source.URL
+}
+
+// CloneOptions are the options used when cloning a project
+type CloneOptions struct {
+	// Depth create a shallow clone truncated to the number of commits
+	Depth int `json:"depth,omitempty"`
+	// Filter is the partial clone filter (e.g `blob:none`)
+	Filter string `json:"filter,omitempty"`
+	// SingleBranch only fetch the history of the project branch
+	SingleBranch bool `json:"singleBranch,omitempty"`
+	// Submodules initialize and clone the submodules
+	Submodules bool `json:"submodules,omitempty"`
+	// SkipLFS prevent the LFS files from being downloaded (they can be fetched using `git lfs pull`)
+	SkipLFS bool `json:"skipLfs,omitempty"`
+}
+
+// DefaultHook is a rule assigning hooks to the projects
+// matching the path glob (e.g `Work/**`) and/or having the tag
+type DefaultHook struct {
+	Path  string              `json:"path,omitempty"`
+	Tag   string              `json:"tag,omitempty"`
+	Hooks map[string][]string `json:"hooks"`
+}
+
+// Match returns true if the rule applies to the project at given path
+func (dh DefaultHook) Match(projectPath string, project Project) bool {
+	if dh.Path == "" && dh.Tag == "" {
+		return false
+	}
+
+	if dh.Path != "" && !matchPath(dh.Path, projectPath) {
+		return false
+	}
+
+	if dh.Tag != "" {
+		for _, tag := range project.Tags {
+			if tag == dh.Tag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Directory is a codebase directory configuration
+// it applies to every project located under the directory
+type Directory struct {
+	Env map[string]string `json:"env,omitempty"`
 }
 
 // GetScript is an helper method to retrieve project script
@@ -34,6 +164,16 @@ func (m *Manifest) GetScript(projectPath, scriptName string) ([]string, error) {
 		return nil, ErrNoProjectFound
 	}
 
+	// Direct reference to a global script
+	if strings.HasPrefix(scriptName, "@") {
+		scriptVal, exist := m.Scripts[strings.TrimPrefix(scriptName, "@")]
+		if !exist {
+			return nil, ErrScriptNotFound
+		}
+
+		return scriptVal, nil
+	}
+
 	// Check if script is defined locally
 	scriptVal, exist := project.Scripts[scriptName]
 	if !exist {
@@ -50,3 +190,199 @@ func (m *Manifest) GetScript(projectPath, scriptName string) ([]string, error) {
 
 	return scriptVal, nil
 }
+
+// GetScriptReferences returns the path of the projects whose scripts are aliases
+// of the global script with given name, or whose hooks are using it directly
+func (m *Manifest) GetScriptReferences(globalScriptName string) []string {
+	var paths []string
+
+	for path, project := range m.Projects {
+		// the global script may be used directly as hook
+		hooks, _ := m.GetHooks(path)
+
+		if isAliased(project.Scripts, globalScriptName) || isReferenced(hooks, globalScriptName) {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// isAliased returns true if one of the scripts is an alias of given global script
+func isAliased(scripts map[string][]string, globalScriptName string) bool {
+	for _, scriptVal := range scripts {
+		if len(scriptVal) == 1 && scriptVal[0] == "@"+globalScriptName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReferenced returns true if one of the hooks is using given global script
+func isReferenced(hooks map[string][]string, globalScriptName string) bool {
+	for _, scripts := range hooks {
+		for _, item := range scripts {
+			if item == "@"+globalScriptName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetScriptTimeout is an helper method to retrieve project script timeout
+// A project timeout take precedence over the timeout of the aliased global script
+// A zero duration is returned if the script has no timeout
+func (m *Manifest) GetScriptTimeout(projectPath, scriptName string) (time.Duration, error) {
+	// Retrieve project
+	project, exist := m.Projects[projectPath]
+	if !exist {
+		return 0, ErrNoProjectFound
+	}
+
+	var timeout string
+
+	if strings.HasPrefix(scriptName, "@") {
+		// Direct reference to a global script: use global timeout
+		globalName := strings.TrimPrefix(scriptName, "@")
+		if _, exist := m.Scripts[globalName]; !exist {
+			return 0, ErrScriptNotFound
+		}
+
+		timeout, exist = m.Timeouts[globalName]
+	} else {
+		var scriptVal []string
+		if scriptVal, exist = project.Scripts[scriptName]; !exist {
+			return 0, ErrScriptNotFound
+		}
+
+		timeout, exist = project.Timeouts[scriptName]
+
+		// It's a script alias: use global timeout
+		if !exist && len(scriptVal) == 1 && strings.HasPrefix(scriptVal[0], "@") {
+			timeout, exist = m.Timeouts[strings.TrimPrefix(scriptVal[0], "@")]
+		}
+	}
+
+	if !exist {
+		return 0, nil
+	}
+
+	val, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout for script %s: %w", scriptName, err)
+	}
+
+	return val, nil
+}
+
+// GetEnv is an helper method to retrieve project environment variables
+// The variables are merged in the following order (last one wins):
+// global, directories (from the outermost to the innermost), project
+func (m *Manifest) GetEnv(projectPath string) (map[string]string, error) {
+	project, exist := m.Projects[projectPath]
+	if !exist {
+		return nil, ErrNoProjectFound
+	}
+
+	env := map[string]string{}
+	for key, value := range m.Env {
+		env[key] = value
+	}
+
+	// Collect the directories containing the project
+	var dirs []string
+	for dir := range m.Directories {
+		if strings.HasPrefix(projectPath, strings.TrimSuffix(dir, "/")+"/") {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	// Sort them from the outermost to the innermost
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(strings.TrimSuffix(dirs[i], "/"), "/") < strings.Count(strings.TrimSuffix(dirs[j], "/"), "/")
+	})
+
+	for _, dir := range dirs {
+		for key, value := range m.Directories[dir].Env {
+			env[key] = value
+		}
+	}
+
+	for key, value := range project.Env {
+		env[key] = value
+	}
+
+	return env, nil
+}
+
+// GetHooks returns the hooks of the project located at given path
+// The default hooks are applied first (in order), then overridden by the project hooks
+// A project hook without script disable the default hook with the same type
+func (m *Manifest) GetHooks(projectPath string) (map[string][]string, error) {
+	project, exist := m.Projects[projectPath]
+	if !exist {
+		return nil, ErrNoProjectFound
+	}
+
+	hooks := map[string][]string{}
+
+	if !project.NoDefaultHooks {
+		for _, defaultHook := range m.DefaultHooks {
+			if !defaultHook.Match(projectPath, project) {
+				continue
+			}
+
+			for hookType, scripts := range defaultHook.Hooks {
+				hooks[hookType] = scripts
+			}
+		}
+	}
+
+	for hookType, scripts := range project.Hooks {
+		if len(scripts) == 0 {
+			delete(hooks, hookType)
+			continue
+		}
+
+		hooks[hookType] = scripts
+	}
+
+	return hooks, nil
+}
+
+// matchPath returns true if given project path match the glob pattern
+// the pattern use the path.Match syntax, plus `**` matching any number of directories
+func matchPath(pattern, projectPath string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(projectPath, "/"))
+}
+
+func matchSegments(patterns, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+
+	if patterns[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patterns[1:], segments[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	if matched, err := path.Match(patterns[0], segments[0]); err != nil || !matched {
+		return false
+	}
+
+	return matchSegments(patterns[1:], segments[1:])
+}
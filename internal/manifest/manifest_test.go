@@ -1,8 +1,11 @@
 package manifest
 
 import (
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestManifest_GetScript(t *testing.T) {
@@ -30,3 +33,259 @@ func TestManifest_GetScript(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestManifest_GetScriptTimeout(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{
+			"project-1": {
+				Scripts:  map[string][]string{"test": {"test-local"}, "lint": {"lint-local"}},
+				Timeouts: map[string]string{"test": "10s"},
+			},
+			"project-2": {
+				Scripts:  map[string][]string{"test": {"@test-global"}, "build": {"@build-global"}},
+				Timeouts: map[string]string{"build": "2m"},
+			},
+			"project-3": {
+				Scripts:  map[string][]string{"test": {"test-local"}},
+				Timeouts: map[string]string{"test": "invalid"},
+			},
+		},
+		Scripts:  map[string][]string{"test-global": {"test-global-42"}, "build-global": {"build-global"}},
+		Timeouts: map[string]string{"test-global": "1h", "build-global": "1m"},
+	}
+
+	if _, err := m.GetScriptTimeout("test", "test"); err != ErrNoProjectFound {
+		t.Fail()
+	}
+
+	if _, err := m.GetScriptTimeout("project-1", "test-12"); err != ErrScriptNotFound {
+		t.Fail()
+	}
+
+	if val, err := m.GetScriptTimeout("project-1", "test"); err != nil || val != 10*time.Second {
+		t.Fail()
+	}
+
+	if val, err := m.GetScriptTimeout("project-1", "lint"); err != nil || val != 0 {
+		t.Fail()
+	}
+
+	if val, err := m.GetScriptTimeout("project-2", "test"); err != nil || val != time.Hour {
+		t.Fail()
+	}
+
+	// project timeout take precedence
+	if val, err := m.GetScriptTimeout("project-2", "build"); err != nil || val != 2*time.Minute {
+		t.Fail()
+	}
+
+	if _, err := m.GetScriptTimeout("project-3", "test"); err == nil {
+		t.Fail()
+	}
+
+	// direct reference to a global script (e.g from a default hook)
+	if val, err := m.GetScriptTimeout("project-1", "@test-global"); err != nil || val != time.Hour {
+		t.Fail()
+	}
+
+	if _, err := m.GetScriptTimeout("project-1", "@unknown"); err != ErrScriptNotFound {
+		t.Fail()
+	}
+}
+
+func TestManifest_GetScriptReferences(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{
+			"project-1": {Scripts: map[string][]string{"test": {"@test-global"}, "lint": {"@lint"}}},
+			"project-2": {Scripts: map[string][]string{"test": {"test-local"}}},
+			"project-3": {Scripts: map[string][]string{"check": {"@test-global"}}},
+			"project-4": {Hooks: map[string][]string{"pre-push": {"@lint"}}},
+			"Work/api":  {},
+		},
+		Scripts: map[string][]string{"test-global": {"test-global-42"}, "lint": {"lint"}, "unused": {"unused"}, "fmt": {"fmt"}},
+		DefaultHooks: []DefaultHook{
+			{Path: "Work/**", Hooks: map[string][]string{"pre-commit": {"@fmt"}}},
+		},
+	}
+
+	if refs := m.GetScriptReferences("test-global"); !reflect.DeepEqual(refs, []string{"project-1", "project-3"}) {
+		t.Errorf("got %v", refs)
+	}
+
+	if refs := m.GetScriptReferences("lint"); !reflect.DeepEqual(refs, []string{"project-1", "project-4"}) {
+		t.Errorf("got %v", refs)
+	}
+
+	if refs := m.GetScriptReferences("fmt"); !reflect.DeepEqual(refs, []string{"Work/api"}) {
+		t.Errorf("got %v", refs)
+	}
+
+	if refs := m.GetScriptReferences("unused"); len(refs) != 0 {
+		t.Errorf("got %v", refs)
+	}
+}
+
+func TestManifest_GetEnv(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{
+			"Work/Api/server": {Env: map[string]string{"DATABASE_URL": "postgres://localhost/server"}},
+			"Work/web":        {},
+			"Personal/blog":   {},
+		},
+		Env: map[string]string{"GOFLAGS": "-mod=mod", "DATABASE_URL": "postgres://localhost"},
+		Directories: map[string]Directory{
+			"Work/Api": {Env: map[string]string{"GOFLAGS": "-mod=vendor", "API": "true"}},
+			"Work":     {Env: map[string]string{"GOFLAGS": "-mod=readonly", "WORK": "true"}},
+			"Wor":      {Env: map[string]string{"WOR": "true"}},
+		},
+	}
+
+	if _, err := m.GetEnv("test"); err != ErrNoProjectFound {
+		t.Fail()
+	}
+
+	env, err := m.GetEnv("Work/Api/server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"GOFLAGS":      "-mod=vendor",
+		"DATABASE_URL": "postgres://localhost/server",
+		"API":          "true",
+		"WORK":         "true",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v want %v", env, expected)
+	}
+
+	env, err = m.GetEnv("Work/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]string{
+		"GOFLAGS":      "-mod=readonly",
+		"DATABASE_URL": "postgres://localhost",
+		"WORK":         "true",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %v want %v", env, expected)
+	}
+
+	env, err = m.GetEnv("Personal/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(env, m.Env) {
+		t.Errorf("got %v want %v", env, m.Env)
+	}
+}
+
+func TestProject_Source(t *testing.T) {
+	var p Project
+	if err := json.Unmarshal([]byte(`{"remote": "test.git"}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SourceType() != SourceGit || !p.IsGit() || p.Location() != "test.git" {
+		t.Errorf("got %+v", p)
+	}
+
+	p = Project{}
+	if err := json.Unmarshal([]byte(`{"source": {"type": "archive", "url": "https://example.org/sdk.tar.gz", "checksum": "abcd"}}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SourceType() != SourceArchive || p.IsGit() || p.Location() != "https://example.org/sdk.tar.gz" {
+		t.Errorf("got %+v", p)
+	}
+
+	// the remote should not be written for the other sources
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"remote"`) {
+		t.Errorf("got %s", b)
+	}
+}
+
+func TestManifest_GetScript_GlobalReference(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{"project-1": {}},
+		Scripts:  map[string][]string{"lint": {"golint"}},
+	}
+
+	if val, err := m.GetScript("project-1", "@lint"); err != nil || !reflect.DeepEqual(val, []string{"golint"}) {
+		t.Errorf("got %v (%v)", val, err)
+	}
+	if _, err := m.GetScript("project-1", "@test"); err != ErrScriptNotFound {
+		t.Errorf("got %v want %v", err, ErrScriptNotFound)
+	}
+	if _, err := m.GetScript("project-2", "@lint"); err != ErrNoProjectFound {
+		t.Errorf("got %v want %v", err, ErrNoProjectFound)
+	}
+}
+
+func TestManifest_GetHooks(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{
+			"Work/api":        {},
+			"Work/web/front":  {Hooks: map[string][]string{"pre-push": {"test"}}},
+			"Work/legacy":     {NoDefaultHooks: true, Hooks: map[string][]string{"pre-commit": {"fmt"}}},
+			"Work/docs":       {Hooks: map[string][]string{"pre-push": {}}},
+			"Personal/blog":   {Tags: []string{"go"}},
+			"Personal/sketch": {},
+		},
+		DefaultHooks: []DefaultHook{
+			{Path: "Work/**", Hooks: map[string][]string{"commit-msg": {"@conventional-commits"}, "pre-push": {"@lint"}}},
+			{Tag: "go", Hooks: map[string][]string{"pre-push": {"@go-lint"}}},
+			{Path: "Work/web/*", Hooks: map[string][]string{"commit-msg": {"@web-commits"}}},
+		},
+	}
+
+	tests := map[string]map[string][]string{
+		"Work/api":        {"commit-msg": {"@conventional-commits"}, "pre-push": {"@lint"}},
+		"Work/web/front":  {"commit-msg": {"@web-commits"}, "pre-push": {"test"}},
+		"Work/legacy":     {"pre-commit": {"fmt"}},
+		"Work/docs":       {"commit-msg": {"@conventional-commits"}},
+		"Personal/blog":   {"pre-push": {"@go-lint"}},
+		"Personal/sketch": {},
+	}
+
+	for path, expected := range tests {
+		hooks, err := m.GetHooks(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(hooks, expected) {
+			t.Errorf("%s: got %v want %v", path, hooks, expected)
+		}
+	}
+
+	if _, err := m.GetHooks("Work/unknown"); err != ErrNoProjectFound {
+		t.Errorf("got %v want %v", err, ErrNoProjectFound)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"Work/**", "Work/api", true},
+		{"Work/**", "Work/web/front", true},
+		{"Work/**", "Personal/blog", false},
+		{"Work/*", "Work/web/front", false},
+		{"**/api", "Work/api", true},
+		{"**/api", "Work/v2/api", true},
+		{"**/api", "Work/api-v2", false},
+		{"Work/*/front", "Work/web/front", true},
+		{"**", "anything/here", true},
+	}
+
+	for _, test := range tests {
+		if matchPath(test.pattern, test.path) != test.match {
+			t.Errorf("matchPath(%s, %s) should be %v", test.pattern, test.path, test.match)
+		}
+	}
+}
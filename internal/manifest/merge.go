@@ -0,0 +1,386 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Conflict is a value changed differently on both sides of a merge
+type Conflict struct {
+	// File is the path of the merged file, relative to the manifest directory (empty when merging manifests)
+	File string `json:"file,omitempty"`
+	// Path is the path of the value, e.g [projects Work/api scripts test] (empty for the whole document)
+	Path []string `json:"path,omitempty"`
+	// Base, Ours & Theirs are the json representation of the value (nil if absent)
+	Base   interface{} `json:"base,omitempty"`
+	Ours   interface{} `json:"ours,omitempty"`
+	Theirs interface{} `json:"theirs,omitempty"`
+}
+
+func (c Conflict) String() string {
+	return c.Format("ours", "theirs")
+}
+
+// Format returns the description of the conflict, using given names for ours & theirs
+// e.g when rebasing, ours are the upstream changes and theirs the local ones
+func (c Conflict) Format(ours, theirs string) string {
+	path := strings.Join(c.Path, " > ")
+	if path == "" {
+		path = "(document)"
+	}
+
+	labels := []string{"base", ours, theirs}
+	values := []interface{}{c.Base, c.Ours, c.Theirs}
+
+	width := 0
+	for _, label := range labels {
+		if len(label)+1 > width {
+			width = len(label) + 1
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(path)
+	for i, label := range labels {
+		_, _ = fmt.Fprintf(&sb, "\n  %-*s %s", width, label+":", formatValue(values[i]))
+	}
+
+	return sb.String()
+}
+
+// ReportConflicts append the conflicts to the report file at given path (one json conflict per line)
+func ReportConflicts(path string, conflicts []Conflict) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, conflict := range conflicts {
+		b, err := json.Marshal(conflict)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// ReadConflicts read the conflicts of the report file at given path (none if the file does not exist)
+func ReadConflicts(path string) ([]Conflict, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var conflicts []Conflict
+
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var conflict Conflict
+		if err := json.Unmarshal(line, &conflict); err != nil {
+			return nil, fmt.Errorf("invalid conflicts report %s: %w", path, err)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, nil
+}
+
+// Merge merge the changes made on ours & theirs since base (three-way merge)
+// The manifests are merged per key (projects, project fields, scripts, env...): only the values changed
+// differently on both sides (e.g the same script) are conflicting, in which case the value of ours is kept
+func Merge(base, ours, theirs Manifest) (Manifest, []Conflict, error) {
+	var res Manifest
+	conflicts, err := mergeDocuments(base, ours, theirs, &res)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+
+	return res, conflicts, nil
+}
+
+// MergeFile merge the versions of the manifest file at given path, relative to the manifest directory
+// The file may be the manifest itself, or a project / script file of the split layout
+// It is used as git merge driver, the conflicting values being kept from ours
+func MergeFile(path string, base, ours, theirs []byte) ([]byte, []Conflict, error) {
+	c, err := codecOf(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to merge %s: %w", path, err)
+	}
+
+	doc := documentOf(path)
+
+	var values []interface{}
+	for _, b := range [][]byte{base, ours, theirs} {
+		val, err := decodeDocument(b, doc, c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to merge %s: %w", path, err)
+		}
+		values = append(values, val)
+	}
+
+	res := newDocument(doc)
+	conflicts, err := mergeDocuments(values[0], values[1], values[2], res)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if man, ok := res.(*Manifest); ok {
+		if err := checkVersion(*man); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	b, err := c.encode(res, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range conflicts {
+		conflicts[i].File = filepath.ToSlash(path)
+	}
+
+	return b, conflicts, nil
+}
+
+// ResolveFile set the conflicting values of the manifest file at given path (relative to the manifest directory)
+// to the ones of theirs (or of ours), b being the content written by MergeFile
+// nil is returned when the resolved document is absent (i.e the file has to be removed)
+func ResolveFile(path string, b []byte, conflicts []Conflict, theirs bool) ([]byte, error) {
+	c, err := codecOf(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s: %w", path, err)
+	}
+
+	doc := documentOf(path)
+
+	val, err := decodeDocument(b, doc, c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s: %w", path, err)
+	}
+
+	for _, conflict := range conflicts {
+		value := conflict.Ours
+		if theirs {
+			value = conflict.Theirs
+		}
+
+		val = setValue(val, conflict.Path, value)
+	}
+
+	if val == nil {
+		return nil, nil
+	}
+
+	b, err = json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	res := newDocument(doc)
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, err
+	}
+
+	return c.encode(res, doc)
+}
+
+// setValue set the value located at path in the json document, removing it if nil
+func setValue(doc interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		obj = map[string]interface{}{}
+	}
+
+	if child := setValue(obj[path[0]], path[1:], value); child != nil {
+		obj[path[0]] = child
+	} else {
+		delete(obj, path[0])
+	}
+
+	return obj
+}
+
+// documentOf returns the kind of the document at given path, relative to the manifest directory
+func documentOf(path string) document {
+	path = filepath.ToSlash(path)
+
+	switch {
+	case strings.HasPrefix(path, ProjectsDir+"/"):
+		return projectDocument
+	case strings.HasPrefix(path, ScriptsDir+"/"):
+		return scriptDocument
+	default:
+		return manifestDocument
+	}
+}
+
+// newDocument returns a pointer to a new value of the document
+func newDocument(doc document) interface{} {
+	switch doc {
+	case projectDocument:
+		return &Project{}
+	case scriptDocument:
+		return &[]string{}
+	default:
+		return &Manifest{}
+	}
+}
+
+// decodeDocument decode the document, normalized through its type (i.e migrated, unknown fields removed)
+// an empty document (e.g the file has been added on both sides) is decoded as nil
+func decodeDocument(b []byte, doc document, c codec) (interface{}, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+
+	val, err := c.decode(b, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = json.Marshal(val); err != nil {
+		return nil, err
+	}
+
+	var typed interface{}
+	if doc == manifestDocument {
+		man, err := decode(b)
+		if err != nil {
+			return nil, err
+		}
+		typed = man
+	} else {
+		typed = newDocument(doc)
+		if err := json.Unmarshal(b, typed); err != nil {
+			return nil, err
+		}
+	}
+
+	return toRaw(typed)
+}
+
+// mergeDocuments merge the documents using their json representation, and decode the result into res
+func mergeDocuments(base, ours, theirs interface{}, res interface{}) ([]Conflict, error) {
+	var values []interface{}
+	for _, val := range []interface{}{base, ours, theirs} {
+		raw, err := toRaw(val)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, raw)
+	}
+
+	var conflicts []Conflict
+	merged := mergeValues(nil, values[0], values[1], values[2], &conflicts)
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// mergeValues merge the json values: the objects changed on both sides are merged key by key
+// the other values changed differently on both sides are conflicting, and the value of ours is kept
+func mergeValues(path []string, base, ours, theirs interface{}, conflicts *[]Conflict) interface{} {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	case reflect.DeepEqual(base, theirs):
+		return ours
+	}
+
+	baseObj, baseOk := base.(map[string]interface{})
+	oursObj, oursOk := ours.(map[string]interface{})
+	theirsObj, theirsOk := theirs.(map[string]interface{})
+
+	if oursOk && theirsOk && (baseOk || base == nil) {
+		keys := map[string]bool{}
+		for _, obj := range []map[string]interface{}{baseObj, oursObj, theirsObj} {
+			for key := range obj {
+				keys[key] = true
+			}
+		}
+
+		var sortedKeys []string
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		merged := map[string]interface{}{}
+		for _, key := range sortedKeys {
+			keyPath := append(append([]string{}, path...), key)
+			if val := mergeValues(keyPath, baseObj[key], oursObj[key], theirsObj[key], conflicts); val != nil {
+				merged[key] = val
+			}
+		}
+
+		return merged
+	}
+
+	*conflicts = append(*conflicts, Conflict{Path: path, Base: base, Ours: ours, Theirs: theirs})
+	return ours
+}
+
+// toRaw returns the json representation of the value (nil stay nil)
+func toRaw(val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+func formatValue(val interface{}) string {
+	if val == nil {
+		return "(none)"
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+
+	return string(b)
+}
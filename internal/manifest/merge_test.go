@@ -0,0 +1,244 @@
+package manifest
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := Manifest{
+		Version: CurrentVersion,
+		Projects: map[string]Project{
+			"api": {Remote: "api.git", Scripts: map[string][]string{"test": {"go test ./..."}}},
+			"web": {Remote: "web.git"},
+		},
+		Scripts: map[string][]string{"lint": {"golint"}},
+	}
+
+	ours := Manifest{
+		Version: CurrentVersion,
+		Projects: map[string]Project{
+			"api": {Remote: "api.git", Branch: "develop", Scripts: map[string][]string{"test": {"go test -race ./..."}}},
+			"web": {Remote: "web.git"},
+			"cli": {Remote: "cli.git"},
+		},
+		Scripts: map[string][]string{"lint": {"golint"}, "fmt": {"gofmt -l ."}},
+	}
+
+	theirs := Manifest{
+		Version: CurrentVersion,
+		Projects: map[string]Project{
+			"api": {Remote: "api.git", Scripts: map[string][]string{"test": {"go test ./..."}, "vet": {"go vet ./..."}}},
+			"doc": {Remote: "doc.git"},
+		},
+		Scripts: map[string][]string{"lint": {"golangci-lint run"}},
+	}
+
+	res, conflicts, err := Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	want := Manifest{
+		Version: CurrentVersion,
+		Projects: map[string]Project{
+			"api": {
+				Remote:  "api.git",
+				Branch:  "develop",
+				Scripts: map[string][]string{"test": {"go test -race ./..."}, "vet": {"go vet ./..."}},
+			},
+			"cli": {Remote: "cli.git"},
+			"doc": {Remote: "doc.git"},
+		},
+		Scripts: map[string][]string{"lint": {"golangci-lint run"}, "fmt": {"gofmt -l ."}},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v want %+v", res, want)
+	}
+
+	// the same script changed differently
+	theirs.Projects["api"].Scripts["test"] = []string{"go test -v ./..."}
+	theirs.Projects["cli"] = Project{Remote: "git@example.org:cli.git"}
+
+	res, conflicts, err = Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("got %d conflicts want 2: %v", len(conflicts), conflicts)
+	}
+
+	wantConflict := Conflict{
+		Path:   []string{"projects", "api", "scripts", "test"},
+		Base:   []interface{}{"go test ./..."},
+		Ours:   []interface{}{"go test -race ./..."},
+		Theirs: []interface{}{"go test -v ./..."},
+	}
+	if !reflect.DeepEqual(conflicts[0], wantConflict) {
+		t.Errorf("got %+v want %+v", conflicts[0], wantConflict)
+	}
+	if conflicts[1].String() != `projects > cli > remote
+  base:   (none)
+  ours:   "cli.git"
+  theirs: "git@example.org:cli.git"` {
+		t.Errorf("wrong conflict: %s", conflicts[1])
+	}
+
+	// ours is kept
+	if res.Projects["api"].Scripts["test"][0] != "go test -race ./..." || res.Projects["cli"].Remote != "cli.git" {
+		t.Errorf("wrong result: %+v", res)
+	}
+}
+
+func TestMergeFile(t *testing.T) {
+	base := []byte(`{"version": 1, "projects": {"api": {"remote": "api.git"}}}`)
+	ours := []byte(`{"version": 1, "projects": {"api": {"remote": "api.git"}, "web": {"remote": "web.git"}}}`)
+	theirs := []byte(`{"version": 1, "projects": {"api": {"remote": "api.git", "branch": "develop"}}}`)
+
+	b, conflicts, err := MergeFile("manifest.json", base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	want := `{
+  "version": 1,
+  "projects": {
+    "api": {
+      "remote": "api.git",
+      "branch": "develop"
+    },
+    "web": {
+      "remote": "web.git"
+    }
+  }
+}`
+	if string(b) != want {
+		t.Errorf("got %s want %s", b, want)
+	}
+
+	// project file of the split layout, added on both sides
+	ours = []byte("remote: api.git\nscripts:\n  test: go test ./...\n")
+	theirs = []byte("remote: api.git\nscripts:\n  lint: |-\n    go vet ./...\n    golint\n")
+
+	b, conflicts, err = MergeFile("projects/Work/api.yaml", nil, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	want = "remote: api.git\nscripts:\n  lint: |-\n    go vet ./...\n    golint\n  test: go test ./...\n"
+	if string(b) != want {
+		t.Errorf("got %s want %s", b, want)
+	}
+
+	// script file of the split layout
+	_, conflicts, err = MergeFile("scripts/test.json", []byte(`["go test"]`), []byte(`["go test -v"]`), []byte(`["go test -race"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || len(conflicts[0].Path) != 0 || conflicts[0].File != "scripts/test.json" {
+		t.Errorf("wrong conflicts: %v", conflicts)
+	}
+
+	if _, _, err := MergeFile("manifest.toml", nil, nil, nil); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v want %v", err, ErrUnsupportedFormat)
+	}
+	if _, _, err := MergeFile("manifest.json", base, []byte(`{"version": 42}`), base); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("got %v want %v", err, ErrNewerVersion)
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	base := []byte(`{"projects": {"api": {"remote": "api.git", "branch": "main"}}, "scripts": {"test": ["go test"]}}`)
+	ours := []byte(`{"projects": {"api": {"remote": "api.git", "branch": "develop"}}, "scripts": {"test": ["go test -v"]}}`)
+	theirs := []byte(`{"projects": {"api": {"remote": "api.git"}}, "scripts": {"test": ["go test -race"]}}`)
+
+	merged, conflicts, err := MergeFile("manifest.json", base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("wrong conflicts: %v", conflicts)
+	}
+
+	// the values of theirs replace the ones of ours kept by the merge (the absent ones are removed)
+	b, err := ResolveFile("manifest.json", merged, conflicts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	man, err := decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if man.Projects["api"].Branch != "" || !reflect.DeepEqual(man.Scripts["test"], []string{"go test -race"}) {
+		t.Errorf("wrong resolved manifest: %s", b)
+	}
+
+	// the values of ours are kept
+	if b, err = ResolveFile("manifest.json", merged, conflicts[:1], false); err != nil || string(b) != string(merged) {
+		t.Errorf("got %s (%v) want %s", b, err, merged)
+	}
+
+	// the script file removed by theirs is removed
+	_, conflicts, err = MergeFile("scripts/test.yaml", []byte("go test\n"), []byte("go test -v\n"), nil)
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("wrong conflicts: %v (%v)", conflicts, err)
+	}
+	if b, err := ResolveFile("scripts/test.yaml", []byte("go test -v\n"), conflicts, true); err != nil || b != nil {
+		t.Errorf("got %s (%v) want nil", b, err)
+	}
+
+	if _, err := ResolveFile("manifest.toml", nil, nil, true); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestConflict_Format(t *testing.T) {
+	c := Conflict{Path: []string{"projects", "api", "branch"}, Base: "main", Ours: "develop", Theirs: nil}
+
+	want := `projects > api > branch
+  base:   "main"
+  remote: "develop"
+  local:  (none)`
+	if val := c.Format("remote", "local"); val != want {
+		t.Errorf("got %s want %s", val, want)
+	}
+}
+
+func TestReportConflicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conflicts")
+
+	// no report
+	if conflicts, err := ReadConflicts(path); err != nil || conflicts != nil {
+		t.Errorf("got %v (%v) want no conflicts", conflicts, err)
+	}
+
+	first := []Conflict{{File: "manifest.json", Path: []string{"env", "GOFLAGS"}, Base: "-mod=mod", Ours: "-v", Theirs: "-x"}}
+	second := []Conflict{{File: "scripts/test.json", Ours: []interface{}{"go test -v"}, Theirs: []interface{}{"go test -race"}}}
+
+	// the conflicts are appended
+	for _, conflicts := range [][]Conflict{first, second} {
+		if err := ReportConflicts(path, conflicts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conflicts, err := ReadConflicts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(first, second...); !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("got %v want %v", conflicts, want)
+	}
+}
@@ -0,0 +1,158 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// CurrentVersion is the version of the manifest format supported by this version of srcode
+const CurrentVersion = 1
+
+// ErrNewerVersion is returned when writing a manifest created by a newer version of srcode
+var ErrNewerVersion = errors.New("the manifest has been created by a newer version of srcode, please upgrade srcode")
+
+// migrations are the manifest migrations: migrations[i] upgrade the (raw) manifest from the version i to i+1
+// they are applied on the json representation of the manifest, to not lose the fields removed from the types
+var migrations = []func(man map[string]interface{}) error{
+	// 0 -> 1: the pre-push `hook` of the projects is replaced by the `hooks` (0.7.x)
+	migrateProjectsHook,
+}
+
+// decode the manifest from its json representation, applying the migrations if needed
+// The version of the manifest is kept, allowing to know if the manifest should be written back
+func decode(b []byte) (Manifest, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return Manifest{}, err
+	}
+
+	version, err := rawVersion(raw)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	for ; version < CurrentVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return Manifest{}, fmt.Errorf("error while migrating manifest to version %d: %w", version+1, err)
+		}
+	}
+
+	if b, err = json.Marshal(raw); err != nil {
+		return Manifest{}, err
+	}
+
+	var res Manifest
+	if err := json.Unmarshal(b, &res); err != nil {
+		return Manifest{}, err
+	}
+
+	return res, nil
+}
+
+// checkVersion make sure the manifest can be written by this version of srcode
+func checkVersion(man Manifest) error {
+	if man.Version > CurrentVersion {
+		return fmt.Errorf("unable to write manifest version %d (supported: %d): %w", man.Version, CurrentVersion, ErrNewerVersion)
+	}
+
+	return nil
+}
+
+func rawVersion(raw map[string]interface{}) (int, error) {
+	val, exist := raw["version"]
+	if !exist {
+		return 0, nil
+	}
+
+	version, ok := val.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid manifest version %v", val)
+	}
+
+	return int(version), nil
+}
+
+func migrateProjectsHook(man map[string]interface{}) error {
+	projects, _ := man["projects"].(map[string]interface{})
+	for _, val := range projects {
+		project, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		hook, exist := project["hook"]
+		if !exist {
+			continue
+		}
+		delete(project, "hook")
+
+		if hook == "" {
+			continue
+		}
+
+		hooks, _ := project["hooks"].(map[string]interface{})
+		if hooks == nil {
+			hooks = map[string]interface{}{}
+		}
+		if _, exist := hooks["pre-push"]; !exist {
+			hooks["pre-push"] = []interface{}{hook}
+		}
+		project["hooks"] = hooks
+	}
+
+	return nil
+}
+
+// MigratedHooks returns the paths of the projects of the manifest file at given path whose pre-push `hook`
+// (srcode <= 0.7.2) is moved to the `hooks` by the migration to the version 1
+func MigratedHooks(path string) ([]string, error) {
+	c, err := codecOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := c.decode(b, manifestDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	// use the json representation to share the version parsing
+	if b, err = json.Marshal(val); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	version, err := rawVersion(raw)
+	if err != nil || version >= 1 {
+		return nil, err
+	}
+
+	var paths []string
+
+	projects, _ := raw["projects"].(map[string]interface{})
+	for projectPath, val := range projects {
+		project, _ := val.(map[string]interface{})
+		hook, _ := project["hook"].(string)
+		hooks, _ := project["hooks"].(map[string]interface{})
+
+		if _, exist := hooks["pre-push"]; hook != "" && !exist {
+			paths = append(paths, projectPath)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
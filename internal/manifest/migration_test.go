@@ -0,0 +1,111 @@
+package manifest
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	// legacy manifest
+	man, err := decode([]byte(`{"projects": {"a": {"remote": "a.git", "hook": "lint"}, "b": {"remote": "b.git", "hook": ""}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Manifest{
+		Projects: map[string]Project{
+			"a": {Remote: "a.git", Hooks: map[string][]string{"pre-push": {"lint"}}},
+			"b": {Remote: "b.git"},
+		},
+	}
+	if !reflect.DeepEqual(man, want) {
+		t.Errorf("got %+v want %+v", man, want)
+	}
+
+	// the new field has precedence over the deprecated one
+	man, err = decode([]byte(`{"projects": {"a": {"remote": "a.git", "hook": "lint", "hooks": {"pre-push": ["test", "lint"]}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(man.Projects["a"].Hooks, map[string][]string{"pre-push": {"test", "lint"}}) {
+		t.Errorf("got %+v", man.Projects["a"].Hooks)
+	}
+
+	// the migrations are not applied on the current version
+	man, err = decode([]byte(`{"version": 1, "projects": {"a": {"remote": "a.git"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if man.Version != 1 || man.Projects["a"].Remote != "a.git" {
+		t.Errorf("got %+v", man)
+	}
+
+	// the newer manifests can be read
+	man, err = decode([]byte(`{"version": 42, "projects": {"a": {"remote": "a.git"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if man.Version != 42 {
+		t.Errorf("got %+v", man)
+	}
+
+	if _, err := decode([]byte(`{"version": "1"}`)); err == nil {
+		t.Error("invalid version should fail")
+	}
+	if _, err := decode([]byte(`{"version": -1}`)); err == nil {
+		t.Error("invalid version should fail")
+	}
+}
+
+func TestProviders_NewerVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"manifest.json", "manifest.yaml"} {
+		path := filepath.Join(dir, name)
+
+		if err := DefaultProvider.Write(path, Manifest{Version: CurrentVersion + 1}); !errors.Is(err, ErrNewerVersion) {
+			t.Errorf("got %v want %v", err, ErrNewerVersion)
+		}
+		if _, err := ioutil.ReadFile(path); err == nil {
+			t.Errorf("the manifest should not be written")
+		}
+
+		if err := DefaultProvider.Write(path, Manifest{Version: CurrentVersion}); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	if len(migrations) != CurrentVersion {
+		t.Errorf("got %d migrations for version %d", len(migrations), CurrentVersion)
+	}
+}
+
+func TestMigratedHooks(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"manifest.json": `{"projects": {"b": {"hook": "lint"}, "a": {"hook": "test"}, "c": {"hook": ""}, "d": {"hook": "lint", "hooks": {"pre-push": ["test"]}}}}`,
+		"v1.json":       `{"version": 1, "projects": {"a": {"hook": "lint"}}}`,
+		"manifest.yaml": "projects:\n  a:\n    hook: lint\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if paths, err := MigratedHooks(filepath.Join(dir, "manifest.json")); err != nil || !reflect.DeepEqual(paths, []string{"a", "b"}) {
+		t.Errorf("got %v (%v) want [a b]", paths, err)
+	}
+	if paths, err := MigratedHooks(filepath.Join(dir, "v1.json")); err != nil || len(paths) != 0 {
+		t.Errorf("got %v (%v) want no paths", paths, err)
+	}
+	if paths, err := MigratedHooks(filepath.Join(dir, "manifest.yaml")); err != nil || !reflect.DeepEqual(paths, []string{"a"}) {
+		t.Errorf("got %v (%v) want [a]", paths, err)
+	}
+}
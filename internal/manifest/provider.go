@@ -2,11 +2,34 @@ package manifest
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
 )
 
 //go:generate mockgen -destination=../manifest_mock/manifest_mock.go -package=manifest_mock . Provider
 
+const (
+	// FormatJSON is the json manifest format
+	FormatJSON = "json"
+	// FormatYAML is the yaml manifest format
+	FormatYAML = "yaml"
+)
+
+var (
+	// ErrUnsupportedFormat is returned when the manifest file format is not supported
+	ErrUnsupportedFormat = errors.New("unsupported manifest format")
+
+	// DefaultProvider is the default manifest provider, choosing the format using the file extension
+	DefaultProvider = NewProvider(map[string]Provider{
+		".json": &JSONProvider{},
+		".yaml": &YAMLProvider{},
+		".yml":  &YAMLProvider{},
+	})
+)
+
 // Provider is something that allows to Read or Write a Manifest
 type Provider interface {
 	Read(path string) (Manifest, error)
@@ -18,23 +41,239 @@ type JSONProvider struct {
 }
 
 func (jp *JSONProvider) Read(path string) (Manifest, error) {
-	var res Manifest
-	b, err := ioutil.ReadFile(path)
+	return read(path, jp)
+}
+
+func (jp *JSONProvider) Write(path string, manifest Manifest) error {
+	return write(path, manifest, jp)
+}
+
+func (jp *JSONProvider) encode(val interface{}, doc document) ([]byte, error) {
+	return json.MarshalIndent(val, "", "  ")
+}
+
+func (jp *JSONProvider) decode(b []byte, doc document) (interface{}, error) {
+	var val interface{}
+	if err := json.Unmarshal(b, &val); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// YAMLProvider is a provider that use a yaml file as storage for the Manifest
+// The manifest is encoded using its json representation, and the scripts are written as (block) scalars
+type YAMLProvider struct {
+}
+
+func (yp *YAMLProvider) Read(path string) (Manifest, error) {
+	return read(path, yp)
+}
+
+func (yp *YAMLProvider) Write(path string, manifest Manifest) error {
+	return write(path, manifest, yp)
+}
+
+func (yp *YAMLProvider) encode(val interface{}, doc document) ([]byte, error) {
+	b, err := json.Marshal(val)
 	if err != nil {
-		return Manifest{}, err
+		return nil, err
+	}
+
+	// json is valid yaml: the keys order (i.e fields order then sorted map keys) is kept
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+
+	root := node.Content[0]
+	resetStyle(root)
+	walkScripts(root, doc, scriptToScalar)
+
+	var sb strings.Builder
+	encoder := yaml.NewEncoder(&sb)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return []byte(sb.String()), nil
+}
+
+func (yp *YAMLProvider) decode(b []byte, doc document) (interface{}, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+
+	// empty file
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+
+	walkScripts(node.Content[0], doc, scriptToSequence)
+
+	var val interface{}
+	if err := node.Decode(&val); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// FormatOf returns the format of the manifest file (empty if not supported)
+func FormatOf(path string) string {
+	switch filepath.Ext(path) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return ""
+	}
+}
+
+// codecOf returns the codec of the manifest file at given path, chosen using its extension
+func codecOf(path string) (codec, error) {
+	switch FormatOf(path) {
+	case FormatJSON:
+		return &JSONProvider{}, nil
+	case FormatYAML:
+		return &YAMLProvider{}, nil
+	default:
+		return nil, ErrUnsupportedFormat
 	}
-	if err := json.Unmarshal(b, &res); err != nil {
+}
+
+type extProvider struct {
+	providers map[string]Provider
+}
+
+// NewProvider returns a provider delegating to given providers, indexed by the manifest file extension
+func NewProvider(providers map[string]Provider) Provider {
+	return &extProvider{providers: providers}
+}
+
+func (ep *extProvider) Read(path string) (Manifest, error) {
+	provider, err := ep.provider(path)
+	if err != nil {
 		return Manifest{}, err
 	}
 
-	return res, nil
+	return provider.Read(path)
 }
 
-func (jp *JSONProvider) Write(path string, manifest Manifest) error {
-	b, err := json.MarshalIndent(manifest, "", "  ")
+func (ep *extProvider) Write(path string, manifest Manifest) error {
+	provider, err := ep.provider(path)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(path, b, 0640)
+	return provider.Write(path, manifest)
+}
+
+func (ep *extProvider) provider(path string) (Provider, error) {
+	provider, exist := ep.providers[filepath.Ext(path)]
+	if !exist {
+		return nil, fmt.Errorf("unable to use manifest %s: %w", path, ErrUnsupportedFormat)
+	}
+
+	return provider, nil
+}
+
+// resetStyle clear the (json) style of the nodes, letting the encoder use the yaml one
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// walkScripts call fn on the scripts of the document (global ones & projects ones)
+func walkScripts(root *yaml.Node, doc document, fn func(script *yaml.Node)) {
+	switch doc {
+	case manifestDocument:
+		if scripts := mappingValue(root, "scripts"); scripts != nil {
+			walkMapping(scripts, fn)
+		}
+
+		if projects := mappingValue(root, "projects"); projects != nil {
+			walkMapping(projects, func(project *yaml.Node) {
+				walkScripts(project, projectDocument, fn)
+			})
+		}
+	case projectDocument:
+		if scripts := mappingValue(root, "scripts"); scripts != nil {
+			walkMapping(scripts, fn)
+		}
+	case scriptDocument:
+		fn(root)
+	}
+}
+
+// walkMapping call fn on the values of the mapping node
+func walkMapping(node *yaml.Node, fn func(value *yaml.Node)) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 1; i < len(node.Content); i += 2 {
+		fn(node.Content[i])
+	}
+}
+
+// mappingValue returns the value of the key of the mapping node (nil if not found)
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// scriptToScalar write the script lines as a single (block) scalar
+// the script is kept as a sequence if it can't be converted back losslessly
+func scriptToScalar(script *yaml.Node) {
+	if script.Kind != yaml.SequenceNode || len(script.Content) == 0 {
+		return
+	}
+
+	var lines []string
+	for _, line := range script.Content {
+		if line.Kind != yaml.ScalarNode || strings.Contains(line.Value, "\n") {
+			return
+		}
+		lines = append(lines, line.Value)
+	}
+	if lines[len(lines)-1] == "" {
+		return
+	}
+
+	*script = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.Join(lines, "\n")}
+	if len(lines) > 1 {
+		script.Style = yaml.LiteralStyle
+	}
+}
+
+// scriptToSequence split the (block) scalar script into its lines
+func scriptToSequence(script *yaml.Node) {
+	if script.Kind != yaml.ScalarNode {
+		return
+	}
+
+	sequence := yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, line := range strings.Split(strings.TrimSuffix(script.Value, "\n"), "\n") {
+		sequence.Content = append(sequence.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: line})
+	}
+
+	*script = sequence
 }
@@ -2,9 +2,11 @@ package manifest
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -65,3 +67,138 @@ func TestJSONProvider_Write(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestYAMLProvider_Read(t *testing.T) {
+	content := `projects:
+  Work/api:
+    remote: git@example.org:api.git
+    scripts:
+      test: |
+        go vet ./...
+        go test ./...
+      lint: golint
+    hook: lint
+scripts:
+  build:
+    - go build ./...
+    - echo done
+env:
+  DEBUG: "true"
+`
+
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.FailNow()
+	}
+
+	p := YAMLProvider{}
+
+	res, err := p.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Manifest{
+		Projects: map[string]Project{
+			"Work/api": {
+				Remote:  "git@example.org:api.git",
+				Scripts: map[string][]string{"test": {"go vet ./...", "go test ./..."}, "lint": {"golint"}},
+				Hooks:   map[string][]string{"pre-push": {"lint"}},
+			},
+		},
+		Scripts: map[string][]string{"build": {"go build ./...", "echo done"}},
+		Env:     map[string]string{"DEBUG": "true"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v want %+v", res, want)
+	}
+}
+
+func TestYAMLProvider_Write(t *testing.T) {
+	m := Manifest{
+		Projects: map[string]Project{
+			"Work/api": {
+				Remote:  "git@example.org:api.git",
+				Scripts: map[string][]string{"test": {"go vet ./...", "go test ./..."}, "true": {"true"}},
+				Hooks:   map[string][]string{"pre-push": {}},
+				Clone:   &CloneOptions{Depth: 1},
+			},
+			"Vendor/sdk": {Source: &Source{Type: SourceArchive, URL: "https://example.org/sdk.zip", Checksum: "abcd"}},
+		},
+		Scripts: map[string][]string{
+			"multi-line": {"echo 'a\nb'", "echo c"},
+			"blank":      {"echo a", ""},
+			"empty":      {},
+		},
+		Timeouts: map[string]string{"test": "10s"},
+		Env:      map[string]string{"EMPTY": "", "NUMBER": "42", "BOOL": "yes"},
+	}
+
+	p := YAMLProvider{}
+
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := p.Write(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.FailNow()
+	}
+
+	// the multi-line scripts are written as block scalars
+	if !strings.Contains(string(b), "      test: |-\n        go vet ./...\n        go test ./...\n") {
+		t.Errorf("wrong output:\n%s", b)
+	}
+
+	// the round-trip is lossless
+	res, err := p.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, m) {
+		t.Errorf("got %+v want %+v", res, m)
+	}
+
+	// the output is deterministic
+	for i := 0; i < 5; i++ {
+		if err := p.Write(path, res); err != nil {
+			t.Fatal(err)
+		}
+		if b2, err := ioutil.ReadFile(path); err != nil || string(b2) != string(b) {
+			t.Errorf("got:\n%s\nwant:\n%s", b2, b)
+		}
+	}
+}
+
+func TestDefaultProvider(t *testing.T) {
+	m := Manifest{Projects: map[string]Project{"12": {Remote: "remote"}}}
+	dir := t.TempDir()
+
+	for _, name := range []string{"manifest.json", "manifest.yaml", "manifest.yml"} {
+		path := filepath.Join(dir, name)
+		if err := DefaultProvider.Write(path, m); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := DefaultProvider.Read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, m) {
+			t.Errorf("got %+v want %+v", res, m)
+		}
+	}
+
+	// the json manifest is written using the json provider
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json")); err != nil || !json.Valid(b) {
+		t.Errorf("invalid json manifest: %s", b)
+	}
+
+	if err := DefaultProvider.Write(filepath.Join(dir, "manifest.toml"), m); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v want %v", err, ErrUnsupportedFormat)
+	}
+	if FormatOf("manifest.yml") != FormatYAML || FormatOf("manifest.json") != FormatJSON || FormatOf("manifest.toml") != "" {
+		t.Error("wrong format")
+	}
+}
@@ -0,0 +1,35 @@
+package manifest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Type string `json:"type"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Properties["version"].Type != "integer" || schema.Properties["projects"].Type != "object" {
+		t.Errorf("wrong schema: %s", b)
+	}
+
+	// the published schema should be up-to-date
+	published, err := ioutil.ReadFile("../../manifest.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(b) {
+		t.Error("manifest.schema.json is outdated, run go generate ./...")
+	}
+}
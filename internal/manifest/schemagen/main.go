@@ -0,0 +1,24 @@
+// schemagen write the JSON Schema of the manifest to the given file
+package main
+
+import (
+	"github.com/creekorful/srcode/internal/manifest"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("correct usage: schemagen <path>")
+	}
+
+	b, err := manifest.Schema()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(os.Args[1], b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/cmd"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrNotSupported is returned when the operation is not supported by the backend
+	ErrNotSupported = errors.New("operation not supported")
+
+	// ErrNotARepository is returned when the directory is not a git repository
+	ErrNotARepository = errors.New("not a git repository")
+	// ErrAuthentication is returned when the authentication against the remote failed
+	ErrAuthentication = errors.New("authentication failed")
+	// ErrNetwork is returned when the remote cannot be reached
+	ErrNetwork = errors.New("network error")
+	// ErrConflict is returned when the local and remote histories cannot be reconciled
+	ErrConflict = errors.New("conflict")
+	// ErrRemoteNotFound is returned when the remote (or the remote repository) does not exist
+	ErrRemoteNotFound = errors.New("remote not found")
+	// ErrNoUpstream is returned when the remote branch does not exist
+	ErrNoUpstream = errors.New("no upstream branch")
+	// ErrEmptyBundle is returned when creating a bundle without new objects
+	ErrEmptyBundle = errors.New("empty bundle")
+
+	// errorPatterns are the stderr patterns used to classify the git errors, in order of precedence
+	// they match the messages of git (and of the ssh / http transports) rather than generic words,
+	// e.g a local `Permission denied` (EACCES) is not an authentication error
+	errorPatterns = []struct {
+		kind     error
+		patterns []string
+	}{
+		{ErrNotARepository, []string{"not a git repository"}},
+		{ErrAuthentication, []string{
+			"authentication failed", "permission denied (publickey", "permission denied, please try again",
+			"could not read username", "could not read password",
+			"invalid username or password", "host key verification failed", "terminal prompts disabled",
+			"returned error: 401", "returned error: 403", "authentication required", "authorization failed",
+		}},
+		{ErrRemoteNotFound, []string{
+			"no such remote", "does not appear to be a git repository", "repository not found",
+			"' does not exist", "returned error: 404", "remote not found",
+		}},
+		{ErrNetwork, []string{
+			"could not resolve host", "connection refused", "connection timed out", "operation timed out",
+			"network is unreachable", "no route to host", "the remote end hung up unexpectedly", "unable to access",
+		}},
+		{ErrNoUpstream, []string{"no upstream", "no tracking information", "couldn't find remote ref"}},
+		{ErrConflict, []string{
+			"conflict (", "merge conflict in", "unresolved conflict", "could not apply", "[rejected]",
+			"non-fast-forward", "(fetch first)", "unmerged files", "unmerged paths",
+		}},
+		{ErrEmptyBundle, []string{"refusing to create empty bundle"}},
+	}
+)
+
+// GitError is returned when a git operation fails
+// It can be matched against the sentinel errors (ErrNotARepository, ErrAuthentication, ...) using errors.Is
+type GitError struct {
+	// Args are the arguments of the git command (or of its equivalent for the go-git backend)
+	Args []string
+	Dir  string
+	// ExitCode is the exit code of the git command, -1 if the command has not been executed
+	ExitCode int
+	Stderr   string
+	// Err is the original error
+	Err error
+
+	kind error
+}
+
+func (e *GitError) Error() string {
+	msg := fmt.Sprintf("error while running `git %s`", strings.Join(e.Args, " "))
+	if e.Dir != "" {
+		msg += fmt.Sprintf(" in %s", e.Dir)
+	}
+	if e.Stderr != "" {
+		msg += fmt.Sprintf(": %s", e.Stderr)
+	}
+
+	return msg
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the class of the error
+func (e *GitError) Is(target error) bool {
+	return e.kind != nil && e.kind == target
+}
+
+// newGitError wrap the error returned by the execution of the git command
+func newGitError(args []string, dir string, err error) error {
+	gitErr := &GitError{Args: args, Dir: dir, ExitCode: cmd.GetExitCode(err), Err: err}
+
+	var execErr *cmd.ExecError
+	if errors.As(err, &execErr) {
+		gitErr.Stderr = execErr.Stderr
+	}
+
+	gitErr.kind = classifyMessage(gitErr.Stderr)
+
+	return gitErr
+}
+
+// newGoGitError wrap the error returned by go-git
+func newGoGitError(args []string, dir string, err error) error {
+	return &GitError{Args: args, Dir: dir, ExitCode: -1, Stderr: err.Error(), Err: err, kind: classifyGoGitError(err)}
+}
+
+func classifyGoGitError(err error) error {
+	switch {
+	case errors.Is(err, git.ErrRepositoryNotExists):
+		return ErrNotARepository
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return ErrAuthentication
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, git.ErrRemoteNotFound):
+		return ErrRemoteNotFound
+	case errors.Is(err, git.ErrNonFastForwardUpdate):
+		return ErrConflict
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrNetwork
+	}
+
+	return classifyMessage(err.Error())
+}
+
+// classifyMessage returns the class of the error based on its message (nil if unknown)
+func classifyMessage(msg string) error {
+	msg = strings.ToLower(msg)
+
+	for _, class := range errorPatterns {
+		for _, pattern := range class.patterns {
+			if strings.Contains(msg, pattern) {
+				return class.kind
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"github.com/creekorful/srcode/internal/cmd"
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyMessage(t *testing.T) {
+	cases := map[string]error{
+		"fatal: not a git repository (or any of the parent directories): .git":                                    ErrNotARepository,
+		"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.":           ErrAuthentication,
+		"fatal: Authentication failed for 'https://github.com/creekorful/srcode.git/'":                            ErrAuthentication,
+		"fatal: unable to access 'https://github.com/creekorful/srcode.git/': Could not resolve host: github.com": ErrNetwork,
+		"fatal: 'upstream' does not appear to be a git repository":                                                ErrRemoteNotFound,
+		"error: No such remote 'upstream'":                                                                        ErrRemoteNotFound,
+		"remote: Repository not found.\nfatal: repository 'https://github.com/x/y.git/' not found":                ErrRemoteNotFound,
+		"fatal: couldn't find remote ref feature":                                                                 ErrNoUpstream,
+		"fatal: The current branch feature has no upstream branch.":                                               ErrNoUpstream,
+		" ! [rejected]        main -> main (fetch first)":                                                         ErrConflict,
+		"error: could not apply 1a2b3c... Update main.go":                                                         ErrConflict,
+		"fatal: Refusing to create empty bundle.":                                                                 ErrEmptyBundle,
+		"fatal: something unexpected happened":                                                                    nil,
+		// the generic words should not be enough
+		"fatal: could not create work tree dir 'api': Permission denied":          nil,
+		"error: unable to create file main.go: Permission denied":                 nil,
+		"error: pathspec 'conflicts.go' did not match any file(s) known to git":   nil,
+		"fatal: invalid reference: fix-conflict":                                  nil,
+		"user@example.org: Permission denied (publickey,password).":               ErrAuthentication,
+		"fatal: could not read Username for 'https://github.com': No such device": ErrAuthentication,
+		"CONFLICT (content): Merge conflict in main.go":                           ErrConflict,
+		"error: Pulling is not possible because you have unmerged files.":         ErrConflict,
+	}
+
+	for msg, expected := range cases {
+		if val := classifyMessage(msg); val != expected {
+			t.Errorf("wrong class for %s: %v (expected %v)", msg, val, expected)
+		}
+	}
+}
+
+func TestNewGitError(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	command := exec.Command("git", "rev-parse", "HEAD")
+	command.Dir = t.TempDir()
+
+	_, execErr := cmd.ExecWithOutput(command)
+	if execErr == nil {
+		t.Skip("the temporary directory is inside a git repository")
+	}
+
+	err := newGitError([]string{"rev-parse", "HEAD"}, command.Dir, execErr)
+
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		t.Fatal("expected git error")
+	}
+	if gitErr.ExitCode != 128 {
+		t.Errorf("wrong exit code: %d", gitErr.ExitCode)
+	}
+	if gitErr.Stderr == "" {
+		t.Error("missing stderr")
+	}
+	if !errors.Is(err, ErrNotARepository) {
+		t.Errorf("expected not a repository error, got: %v", err)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Error("the original error should be kept")
+	}
+}
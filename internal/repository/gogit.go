@@ -0,0 +1,954 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// localScheme is the scheme of the local remotes served by the in-process transport
+const localScheme = "srcode-file"
+
+var installLocalTransport sync.Once
+
+type goGitProvider struct {
+}
+
+func (ggp *goGitProvider) Init(path string) (Repository, error) {
+	repo, err := git.PlainInit(path, false)
+	if err != nil {
+		return nil, newGoGitError([]string{"init", path}, "", err)
+	}
+
+	// honor init.defaultBranch like `git init` does
+	if cfg, err := config.LoadConfig(config.GlobalScope); err == nil {
+		if branch := cfg.Raw.Section("init").Option("defaultBranch"); branch != "" {
+			head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+			if err := repo.Storer.SetReference(head); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &goGitRepository{path: path, repo: repo}, nil
+}
+
+func (ggp *goGitProvider) Open(path string) (Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, newGoGitError([]string{"rev-parse", "--git-dir"}, path, err)
+	}
+
+	return &goGitRepository{path: path, repo: repo}, nil
+}
+
+// Clone does not support partial clones. The LFS files are never downloaded since go-git doesn't run the filters
+// Alternates are not supported either: the reference repository is cloned, then updated from the remote
+func (ggp *goGitProvider) Clone(url, path string, options CloneOptions) (Repository, error) {
+	args := []string{"clone", url, path}
+
+	if options.Filter != "" {
+		return nil, newGoGitError(args, "", fmt.Errorf("partial clone: %w", ErrNotSupported))
+	}
+
+	cloneOptions := &git.CloneOptions{
+		URL:          url,
+		Depth:        options.Depth,
+		SingleBranch: options.SingleBranch,
+	}
+
+	useReference := options.Reference != "" && fileExists(options.Reference)
+	if useReference {
+		cloneOptions.URL = options.Reference
+	}
+
+	// like git, the depth is ignored for the local remotes (not supported by the in-process server)
+	if served := localURL(cloneOptions.URL); served != cloneOptions.URL {
+		cloneOptions.URL = served
+		cloneOptions.Depth = 0
+	}
+	if options.Branch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(options.Branch)
+	}
+	if options.Submodules {
+		cloneOptions.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+
+	repo, err := git.PlainClone(path, false, cloneOptions)
+
+	// same behavior as git: cloning an empty repository is not an error
+	if err == transport.ErrEmptyRemoteRepository {
+		if repo, err = git.PlainInit(path, false); err == nil {
+			_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+		}
+	}
+	if err != nil {
+		return nil, newGoGitError(args, "", err)
+	}
+
+	r := &goGitRepository{path: path, repo: repo}
+
+	// use the remote instead of the reference repository (or of the in-process URL) from now on
+	if cloneOptions.URL != url {
+		if err := r.setRemoteURL(git.DefaultRemoteName, url); err != nil {
+			return nil, newGoGitError(args, "", err)
+		}
+	}
+	if useReference {
+		if err := r.Fetch(git.DefaultRemoteName); err != nil {
+			return nil, err
+		}
+	}
+
+	// make sure the LFS files are not downloaded when using git afterward
+	if options.SkipLFS {
+		if err := skipLFSSmudge(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (ggp *goGitProvider) Exists(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ".git"))
+	return err == nil && !IsWorktree(path)
+}
+
+func (ggp *goGitProvider) Mirror(url, path string) (Repository, error) {
+	args := []string{"clone", "--mirror", url, path}
+
+	repo, err := git.PlainInit(path, true)
+	if err != nil {
+		return nil, newGoGitError(args, "", err)
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{url},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+	})
+	if err != nil {
+		return nil, newGoGitError(args, "", err)
+	}
+
+	r := &goGitRepository{path: path, repo: repo}
+	if err := r.Fetch(git.DefaultRemoteName); err != nil {
+		return nil, err
+	}
+
+	// use the same HEAD as the remote
+	remote, err := r.remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, newGoGitError(args, "", err)
+	}
+
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return nil, newGoGitError(args, "", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			if err := repo.Storer.SetReference(ref); err != nil {
+				return nil, newGoGitError(args, "", err)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+type goGitRepository struct {
+	path string
+	repo *git.Repository
+}
+
+func (ggr *goGitRepository) CommitFiles(message string, files ...string) error {
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return newGoGitError([]string{"add"}, ggr.path, err)
+	}
+
+	for _, file := range files {
+		if _, err := worktree.Add(file); err != nil {
+			return newGoGitError([]string{"add", file}, ggr.path, err)
+		}
+	}
+
+	// go-git doesn't stage the files removed from the added directories
+	status, err := worktree.Status()
+	if err != nil {
+		return newGoGitError([]string{"add"}, ggr.path, err)
+	}
+
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Deleted || !inFiles(path, files) {
+			continue
+		}
+
+		if _, err := worktree.Add(path); err != nil {
+			return newGoGitError([]string{"add", path}, ggr.path, err)
+		}
+	}
+
+	if _, err := worktree.Commit(message, &git.CommitOptions{}); err != nil {
+		return newGoGitError([]string{"commit", "-m", message}, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) Push(repo, refspec string) error {
+	args := []string{"push", repo, refspec}
+
+	remote, err := ggr.remote(repo)
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	err = remote.Push(&git.PushOptions{
+		RemoteName: repo,
+		RefSpecs:   []config.RefSpec{toRefSpec(refspec)},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	return nil
+}
+
+// Pull only fast-forward the branch: when the histories have diverged, the local commits are rebased
+// using the git binary, the operation not being supported otherwise
+func (ggr *goGitRepository) Pull(repo, refspec string) error {
+	args := []string{"pull", repo, refspec}
+
+	if err := ggr.Fetch(repo); err != nil {
+		return err
+	}
+
+	ref, err := ggr.repo.Reference(plumbing.NewRemoteReferenceName(repo, refspec), true)
+	if err == plumbing.ErrReferenceNotFound {
+		err = fmt.Errorf("couldn't find remote ref %s: %w", refspec, err)
+	}
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	head, err := ggr.repo.Head()
+	switch {
+	case err == plumbing.ErrReferenceNotFound:
+		// unborn branch: start it from the remote branch
+		if err := ggr.setHead(ref.Hash()); err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+	case err != nil:
+		return newGoGitError(args, ggr.path, err)
+	default:
+		upToDate, err := ggr.isAncestor(ref.Hash(), head.Hash())
+		if err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+		if upToDate {
+			return nil
+		}
+
+		fastForward, err := ggr.isAncestor(head.Hash(), ref.Hash())
+		if err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+		if !fastForward {
+			if _, err := exec.LookPath("git"); err != nil {
+				return newGoGitError(args, ggr.path, fmt.Errorf("the histories have diverged, rebasing the local commits requires the git binary: %w", ErrNotSupported))
+			}
+
+			return (&gitWrapperRepository{path: ggr.path}).Pull(repo, refspec)
+		}
+	}
+
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Mode: git.MergeReset, Commit: ref.Hash()}); err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) Fetch(repo string) error {
+	args := []string{"fetch", "--prune", repo}
+
+	remote, err := ggr.remote(repo)
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	err = remote.Fetch(&git.FetchOptions{RemoteName: repo, Force: true})
+	if err != nil && err != git.NoErrAlreadyUpToDate && err != transport.ErrEmptyRemoteRepository {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	if err := ggr.prune(remote); err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	return nil
+}
+
+// prune remove the remote branches (i.e refs/remotes/<repo>/*) deleted remotely
+func (ggr *goGitRepository) prune(remote *git.Remote) error {
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return err
+	}
+
+	remoteRefs := map[plumbing.ReferenceName]bool{}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			remoteRefs[plumbing.NewRemoteReferenceName(remote.Config().Name, ref.Name().Short())] = true
+		}
+	}
+
+	localRefs, err := ggr.repo.References()
+	if err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("refs/remotes/%s/", remote.Config().Name)
+
+	var deleted []plumbing.ReferenceName
+	err = localRefs.ForEach(func(ref *plumbing.Reference) error {
+		// the remote HEAD is a symbolic reference to one of the remote branches
+		if ref.Type() == plumbing.SymbolicReference || !strings.HasPrefix(ref.Name().String(), prefix) {
+			return nil
+		}
+
+		if !remoteRefs[ref.Name()] {
+			deleted = append(deleted, ref.Name())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range deleted {
+		if err := ggr.repo.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checkout only look for the remote branch in origin
+func (ggr *goGitRepository) Checkout(ref string) error {
+	args := []string{"checkout", ref}
+
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(ref)
+	if _, err := ggr.repo.Reference(branch, false); err == nil {
+		if err := worktree.Checkout(&git.CheckoutOptions{Branch: branch}); err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+
+		return nil
+	}
+
+	// same behavior as `git checkout`: create the branch tracking the remote one
+	if remoteRef, err := ggr.repo.Reference(plumbing.NewRemoteReferenceName("origin", ref), true); err == nil {
+		if err := worktree.Checkout(&git.CheckoutOptions{Branch: branch, Hash: remoteRef.Hash(), Create: true}); err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+
+		if err := ggr.repo.CreateBranch(&config.Branch{Name: ref, Remote: "origin", Merge: branch}); err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+
+		return nil
+	}
+
+	// not a branch: detach HEAD at the commit
+	hash, err := ggr.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return newGoGitError(args, ggr.path, fmt.Errorf("pathspec '%s' did not match any file(s) known to git", ref))
+	}
+
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash}); err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) Reset(ref string) error {
+	args := []string{"reset", "--hard", ref}
+
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	hash, err := ggr.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: *hash, Mode: git.HardReset}); err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) CreateBranch(name string) error {
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return newGoGitError([]string{"checkout", "-b", name}, ggr.path, err)
+	}
+
+	// the branch starts at HEAD: keep the index & working tree untouched
+	err = worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(name), Create: true, Keep: true})
+	if err != nil {
+		return newGoGitError([]string{"checkout", "-b", name}, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) Commits(from, to string) ([]Commit, error) {
+	args := []string{"log", fmt.Sprintf("%s..%s", from, to)}
+
+	var hashes []*plumbing.Hash
+	for _, rev := range []string{from, to} {
+		hash, err := ggr.repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, newGoGitError(args, ggr.path, fmt.Errorf("bad revision '%s': %w", rev, err))
+		}
+		hashes = append(hashes, hash)
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	iter, err := ggr.repo.Log(&git.LogOptions{From: *hashes[0]})
+	if err != nil {
+		return nil, newGoGitError(args, ggr.path, err)
+	}
+	if err := iter.ForEach(func(c *object.Commit) error {
+		excluded[c.Hash] = true
+		return nil
+	}); err != nil {
+		return nil, newGoGitError(args, ggr.path, err)
+	}
+
+	iter, err = ggr.repo.Log(&git.LogOptions{From: *hashes[1], Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, newGoGitError(args, ggr.path, err)
+	}
+
+	var commits []Commit
+	if err := iter.ForEach(func(c *object.Commit) error {
+		if !excluded[c.Hash] {
+			subject := strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0]
+			commits = append(commits, Commit{Hash: c.Hash.String(), Subject: subject})
+		}
+		return nil
+	}); err != nil {
+		return nil, newGoGitError(args, ggr.path, err)
+	}
+
+	return commits, nil
+}
+
+func (ggr *goGitRepository) AddRemote(name, url string) error {
+	if _, err := ggr.repo.CreateRemote(&config.RemoteConfig{Name: name, URLs: []string{url}}); err != nil {
+		return newGoGitError([]string{"remote", "add", name, url}, ggr.path, err)
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) RemoveRemote(name string) error {
+	args := []string{"remote", "remove", name}
+
+	if err := ggr.repo.DeleteRemote(name); err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	// same behavior as git: the remote branches are removed too
+	refs, err := ggr.repo.References()
+	if err != nil {
+		return newGoGitError(args, ggr.path, err)
+	}
+
+	prefix := fmt.Sprintf("refs/remotes/%s/", name)
+
+	var deleted []plumbing.ReferenceName
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), prefix) {
+			deleted = append(deleted, ref.Name())
+		}
+		return nil
+	})
+
+	for _, ref := range deleted {
+		if err := ggr.repo.Storer.RemoveReference(ref); err != nil {
+			return newGoGitError(args, ggr.path, err)
+		}
+	}
+
+	return nil
+}
+
+func (ggr *goGitRepository) Remote(name string) (string, error) {
+	remote, err := ggr.repo.Remote(name)
+	if err != nil {
+		return "", newGoGitError([]string{"remote", "get-url", name}, ggr.path, err)
+	}
+
+	return remote.Config().URLs[0], nil
+}
+
+// Config returns the value of given key, looking up the repository configuration first
+// then the global and system ones
+func (ggr *goGitRepository) Config(key string) (string, error) {
+	section, subsection, option, err := splitConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	for _, scope := range []config.Scope{config.LocalScope, config.GlobalScope, config.SystemScope} {
+		cfg, err := ggr.repo.ConfigScoped(scope)
+		if err != nil {
+			return "", err
+		}
+
+		s := cfg.Raw.Section(section)
+		if subsection != "" {
+			if !s.HasSubsection(subsection) {
+				continue
+			}
+			if value := s.Subsection(subsection).Option(option); value != "" {
+				return value, nil
+			}
+		} else if value := s.Option(option); value != "" {
+			return value, nil
+		}
+	}
+
+	return "", fmt.Errorf("no config value found for %s", key)
+}
+
+func (ggr *goGitRepository) SetConfig(key, value string) error {
+	section, subsection, option, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := ggr.repo.Config()
+	if err != nil {
+		return err
+	}
+
+	if subsection != "" {
+		cfg.Raw.Section(section).Subsection(subsection).SetOption(option, value)
+	} else {
+		cfg.Raw.Section(section).SetOption(option, value)
+	}
+
+	// the typed sections (remote, branch, ...) are marshalled from their fields: reload them from the raw config
+	var buf bytes.Buffer
+	if err := format.NewEncoder(&buf).Encode(cfg.Raw); err != nil {
+		return err
+	}
+
+	cfg = config.NewConfig()
+	if err := cfg.Unmarshal(buf.Bytes()); err != nil {
+		return err
+	}
+
+	return ggr.repo.Storer.SetConfig(cfg)
+}
+
+// RawCmd has no pure-Go equivalent: the git binary is used if available
+func (ggr *goGitRepository) RawCmd(args []string, env []string, writer io.Writer) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("error while running git %s: %w", strings.Join(args, " "), ErrNotSupported)
+	}
+
+	return (&gitWrapperRepository{path: ggr.path}).RawCmd(args, env, writer)
+}
+
+// Worktrees are read from the administrative files (.git/worktrees/<name>) since go-git doesn't support them
+func (ggr *goGitRepository) Worktrees() ([]Worktree, error) {
+	args := []string{"worktree", "list", "--porcelain"}
+
+	dirs, err := ioutil.ReadDir(filepath.Join(ggr.path, ".git", "worktrees"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, newGoGitError(args, ggr.path, err)
+	}
+
+	var worktrees []Worktree
+	for _, dir := range dirs {
+		adminDir := filepath.Join(ggr.path, ".git", "worktrees", dir.Name())
+
+		// gitdir contains the path of the .git file of the worktree
+		gitDir, err := ioutil.ReadFile(filepath.Join(adminDir, "gitdir"))
+		if err != nil {
+			continue
+		}
+
+		head, err := ioutil.ReadFile(filepath.Join(adminDir, "HEAD"))
+		if err != nil {
+			return nil, newGoGitError(args, ggr.path, err)
+		}
+
+		worktree := Worktree{Path: filepath.Dir(strings.TrimSpace(string(gitDir)))}
+
+		if ref := strings.TrimSpace(string(head)); strings.HasPrefix(ref, "ref: ") {
+			name := plumbing.ReferenceName(strings.TrimPrefix(ref, "ref: "))
+			worktree.Branch = name.Short()
+
+			if ref, err := ggr.repo.Reference(name, true); err == nil {
+				worktree.Commit = ref.Hash().String()
+			}
+		} else {
+			worktree.Commit = ref
+		}
+
+		worktrees = append(worktrees, worktree)
+	}
+
+	return worktrees, nil
+}
+
+// AddWorktree has no pure-Go equivalent: the git binary is used if available
+func (ggr *goGitRepository) AddWorktree(path, branch string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return newGoGitError([]string{"worktree", "add", path, branch}, ggr.path, ErrNotSupported)
+	}
+
+	return (&gitWrapperRepository{path: ggr.path}).AddWorktree(path, branch)
+}
+
+// RemoveWorktree has no pure-Go equivalent: the git binary is used if available
+func (ggr *goGitRepository) RemoveWorktree(path string, force bool) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return newGoGitError([]string{"worktree", "remove", path}, ggr.path, ErrNotSupported)
+	}
+
+	return (&gitWrapperRepository{path: ggr.path}).RemoveWorktree(path, force)
+}
+
+func (ggr *goGitRepository) Refs() (map[string]string, error) {
+	iter, err := ggr.repo.References()
+	if err != nil {
+		return nil, newGoGitError([]string{"for-each-ref"}, ggr.path, err)
+	}
+
+	refs := map[string]string{}
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && ref.Name() != plumbing.HEAD {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+		return nil
+	}); err != nil {
+		return nil, newGoGitError([]string{"for-each-ref"}, ggr.path, err)
+	}
+
+	return refs, nil
+}
+
+func (ggr *goGitRepository) UpdateRef(name, hash string) error {
+	if hash == "" {
+		if err := ggr.repo.Storer.RemoveReference(plumbing.ReferenceName(name)); err != nil {
+			return newGoGitError([]string{"update-ref", "-d", name}, ggr.path, err)
+		}
+		return nil
+	}
+
+	ref := plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash))
+	if err := ggr.repo.Storer.SetReference(ref); err != nil {
+		return newGoGitError([]string{"update-ref", name, hash}, ggr.path, err)
+	}
+
+	return nil
+}
+
+// CreateBundle has no pure-Go equivalent: the git binary is used if available
+func (ggr *goGitRepository) CreateBundle(path string, exclude []string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return newGoGitError([]string{"bundle", "create", path}, ggr.path, ErrNotSupported)
+	}
+
+	return (&gitWrapperRepository{path: ggr.path}).CreateBundle(path, exclude)
+}
+
+// FetchBundle has no pure-Go equivalent: the git binary is used if available
+func (ggr *goGitRepository) FetchBundle(path string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return newGoGitError([]string{"fetch", path}, ggr.path, ErrNotSupported)
+	}
+
+	return (&gitWrapperRepository{path: ggr.path}).FetchBundle(path)
+}
+
+func (ggr *goGitRepository) Head() (string, error) {
+	ref, err := ggr.repo.Head()
+	if err != nil {
+		return "", newGoGitError([]string{"rev-parse", "--abbrev-ref", "HEAD"}, ggr.path, err)
+	}
+
+	// same behavior as `git rev-parse --abbrev-ref HEAD` on detached HEAD
+	if !ref.Name().IsBranch() {
+		return "HEAD", nil
+	}
+
+	return ref.Name().Short(), nil
+}
+
+func (ggr *goGitRepository) HeadCommit() (string, error) {
+	ref, err := ggr.repo.Head()
+	if err != nil {
+		return "", newGoGitError([]string{"rev-parse", "HEAD"}, ggr.path, err)
+	}
+
+	return ref.Hash().String(), nil
+}
+
+func (ggr *goGitRepository) IsDirty() (bool, error) {
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return false, newGoGitError([]string{"status", "--short"}, ggr.path, err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return false, newGoGitError([]string{"status", "--short"}, ggr.path, err)
+	}
+
+	return !status.IsClean(), nil
+}
+
+func (ggr *goGitRepository) IsIgnored(path string) (bool, error) {
+	worktree, err := ggr.repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	if filepath.IsAbs(path) {
+		root, err := filepath.Abs(ggr.path)
+		if err != nil {
+			return false, err
+		}
+
+		if path, err = filepath.Rel(root, path); err != nil {
+			return false, err
+		}
+	}
+
+	patterns, err := gitignore.ReadPatterns(worktree.Filesystem, nil)
+	if err != nil {
+		return false, newGoGitError([]string{"check-ignore", path}, ggr.path, err)
+	}
+
+	if globalPatterns, err := gitignore.LoadGlobalPatterns(worktree.Filesystem); err == nil {
+		patterns = append(globalPatterns, patterns...)
+	}
+
+	info, err := os.Stat(filepath.Join(ggr.path, path))
+	isDir := err == nil && info.IsDir()
+
+	return gitignore.NewMatcher(patterns).Match(strings.Split(filepath.ToSlash(path), "/"), isDir), nil
+}
+
+func (ggr *goGitRepository) setRemoteURL(name, url string) error {
+	cfg, err := ggr.repo.Config()
+	if err != nil {
+		return err
+	}
+
+	remote, exist := cfg.Remotes[name]
+	if !exist {
+		return git.ErrRemoteNotFound
+	}
+	remote.URLs = []string{url}
+
+	return ggr.repo.Storer.SetConfig(cfg)
+}
+
+// remote returns the remote of given name, its local URLs being served by the in-process transport
+func (ggr *goGitRepository) remote(name string) (*git.Remote, error) {
+	remote, err := ggr.repo.Remote(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := *remote.Config()
+	cfg.URLs = nil
+	for _, url := range remote.Config().URLs {
+		cfg.URLs = append(cfg.URLs, localURL(url))
+	}
+
+	return git.NewRemote(ggr.repo.Storer, &cfg), nil
+}
+
+// setHead point HEAD (or the branch it references) to given commit
+func (ggr *goGitRepository) setHead(hash plumbing.Hash) error {
+	head, err := ggr.repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return err
+	}
+
+	name := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		name = head.Target()
+	}
+
+	return ggr.repo.Storer.SetReference(plumbing.NewHashReference(name, hash))
+}
+
+// isAncestor returns true if the commit is an ancestor of (or is) the other one
+func (ggr *goGitRepository) isAncestor(hash, other plumbing.Hash) (bool, error) {
+	commit, err := ggr.repo.CommitObject(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherCommit, err := ggr.repo.CommitObject(other)
+	if err != nil {
+		return false, err
+	}
+
+	return commit.IsAncestor(otherCommit)
+}
+
+// localURL returns the URL of the local remote served by the in-process transport (other URLs are returned as is)
+// go-git resolves the transports from a global registry: the transport is registered under a scheme of its own
+// rather than replacing the go-git file transport, which keeps spawning git-upload-pack for the other users
+func localURL(url string) string {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil || ep.Protocol != "file" {
+		return url
+	}
+
+	path, err := filepath.Abs(ep.Path)
+	if err != nil {
+		return url
+	}
+
+	installLocalTransport.Do(func() {
+		client.InstallProtocol(localScheme, &localTransport{
+			Transport: server.NewClient(server.DefaultLoader),
+			loader:    server.DefaultLoader,
+		})
+	})
+
+	return localScheme + "://" + filepath.ToSlash(path)
+}
+
+// toRefSpec convert given branch name (or refspec) to a fully qualified refspec
+func toRefSpec(refspec string) config.RefSpec {
+	if strings.Contains(refspec, ":") {
+		return config.RefSpec(refspec)
+	}
+
+	ref := plumbing.NewBranchReferenceName(refspec)
+	return config.RefSpec(fmt.Sprintf("%s:%s", ref, ref))
+}
+
+// splitConfigKey split given key (section[.subsection].option) into its parts
+func splitConfigKey(key string) (string, string, string, error) {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 {
+		return "", "", "", fmt.Errorf("invalid config key %s", key)
+	}
+
+	section, option := parts[0], parts[len(parts)-1]
+	subsection := strings.Join(parts[1:len(parts)-1], ".")
+
+	return section, subsection, option, nil
+}
+
+// localTransport is the in-process transport of the local remotes
+// Unlike git, the go-git server fails if the client has objects unknown to the remote (diverged histories):
+// such objects are therefore filtered out from the upload-pack requests
+type localTransport struct {
+	transport.Transport
+	loader server.Loader
+}
+
+func (lt *localTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := lt.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	sto, err := lt.loader.Load(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localUploadPackSession{UploadPackSession: session, storer: sto}, nil
+}
+
+type localUploadPackSession struct {
+	transport.UploadPackSession
+	storer storer.EncodedObjectStorer
+}
+
+func (lups *localUploadPackSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	var haves []plumbing.Hash
+	for _, have := range req.Haves {
+		if lups.storer.HasEncodedObject(have) == nil {
+			haves = append(haves, have)
+		}
+	}
+	req.Haves = haves
+
+	return lups.UploadPackSession.UploadPack(ctx, req)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// inFiles returns true if path is one of the files, or is inside one of them (directory)
+func inFiles(path string, files []string) bool {
+	for _, file := range files {
+		if path == file || strings.HasPrefix(path, strings.TrimSuffix(file, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,20 +2,93 @@ package repository
 
 import (
 	"github.com/creekorful/srcode/internal/cmd"
+	"github.com/go-git/go-git/v5/config"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-// DefaultProvider is the default Git provider
-var DefaultProvider = &gitWrapperProvider{}
+const (
+	// BackendEnv is the environment variable used to select the Git backend
+	BackendEnv = "SRCODE_GIT_BACKEND"
+	// BackendConfig is the option of the global git configuration used to select the Git backend
+	// e.g `git config --global srcode.gitBackend go-git` (the environment variable takes precedence)
+	BackendConfig = "srcode.gitBackend"
+	// ExecBackend is the backend executing the git binary
+	ExecBackend = "exec"
+	// GoGitBackend is the pure-Go backend (doesn't require git to be installed)
+	GoGitBackend = "go-git"
+)
+
+// DefaultProvider is the default Git provider, selected using the BackendEnv environment variable
+// or the BackendConfig option
+var DefaultProvider = NewProvider(defaultBackend())
+
+// defaultBackend returns the backend selected by the user, if any
+func defaultBackend() string {
+	cfg, err := config.LoadConfig(config.GlobalScope)
+	if err != nil {
+		cfg = config.NewConfig()
+	}
+
+	return selectBackend(os.Getenv(BackendEnv), cfg)
+}
+
+// selectBackend returns the backend selected using the environment variable value, or else the configuration
+func selectBackend(env string, cfg *config.Config) string {
+	if env != "" {
+		return env
+	}
+
+	return cfg.Raw.Section("srcode").Option("gitBackend")
+}
+
+// NewProvider returns the provider of given backend
+// If no backend is given, the exec backend is used unless git is not installed
+func NewProvider(backend string) Provider {
+	if backend == "" {
+		if _, err := exec.LookPath("git"); err != nil {
+			backend = GoGitBackend
+		}
+	}
+
+	if backend == GoGitBackend {
+		return &goGitProvider{}
+	}
+
+	return &gitWrapperProvider{}
+}
 
 // Provider is something that allows to Init, Open, or Clone a Repository
 type Provider interface {
 	Init(path string) (Repository, error)
 	Open(path string) (Repository, error)
-	Clone(url, path string) (Repository, error)
+	Clone(url, path string, options CloneOptions) (Repository, error)
+	// Exists returns true if there is a repository at given path (the linked worktrees are not considered)
 	Exists(path string) bool
+	// Mirror create a bare mirror of the remote repository
+	Mirror(url, path string) (Repository, error)
+}
+
+// CloneOptions are the options used when cloning a Repository
+type CloneOptions struct {
+	// Depth create a shallow clone truncated to the number of commits (0 means full history)
+	Depth int
+	// Filter is the partial clone filter (e.g `blob:none`)
+	Filter string
+	// Branch is the branch to checkout instead of the remote HEAD
+	Branch       string
+	SingleBranch bool
+	// Submodules initialize and clone the submodules
+	Submodules bool
+	// SkipLFS prevent the LFS files from being downloaded
+	SkipLFS bool
+	// Reference is the path of a local repository used to borrow the objects from
+	// The clone does not depend on the reference repository afterward
+	Reference string
 }
 
 type gitWrapperProvider struct {
@@ -25,7 +98,7 @@ func (gwp *gitWrapperProvider) Init(path string) (Repository, error) {
 	command := exec.Command("git", "init", path)
 
 	if _, err := cmd.ExecWithOutput(command); err != nil {
-		return nil, err
+		return nil, newGitError(command.Args[1:], "", err)
 	}
 
 	return &gitWrapperRepository{path: path}, nil
@@ -39,17 +112,79 @@ func (gwp *gitWrapperProvider) Open(path string) (Repository, error) {
 	return &gitWrapperRepository{path: path}, nil
 }
 
-func (gwp *gitWrapperProvider) Clone(url, path string) (Repository, error) {
-	command := exec.Command("git", "clone", url, path)
+func (gwp *gitWrapperProvider) Clone(url, path string, options CloneOptions) (Repository, error) {
+	args := []string{"clone"}
+	if options.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(options.Depth))
+	}
+	if options.Filter != "" {
+		args = append(args, "--filter", options.Filter)
+	}
+	if options.Branch != "" {
+		args = append(args, "--branch", options.Branch)
+	}
+	if options.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if options.Submodules {
+		args = append(args, "--recurse-submodules")
+	}
+	if options.Reference != "" {
+		args = append(args, "--reference-if-able", options.Reference, "--dissociate")
+	}
+
+	command := exec.Command("git", append(args, url, path)...)
+	if options.SkipLFS {
+		command.Env = append(os.Environ(), "GIT_LFS_SKIP_SMUDGE=1")
+	}
 
 	if _, err := cmd.ExecWithOutput(command); err != nil {
-		return nil, err
+		return nil, newGitError(command.Args[1:], "", err)
 	}
 
-	return &gitWrapperRepository{path: path}, nil
+	repo := &gitWrapperRepository{path: path}
+
+	if options.SkipLFS {
+		if err := skipLFSSmudge(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	return repo, nil
 }
 
 func (gwp *gitWrapperProvider) Exists(path string) bool {
 	_, err := os.Stat(filepath.Join(path, ".git"))
-	return err == nil
+	return err == nil && !IsWorktree(path)
+}
+
+// IsWorktree returns true if given path is a linked worktree of a repository
+// i.e its .git is a file pointing to the worktrees directory of the main repository
+func IsWorktree(path string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(path, ".git"))
+	if err != nil {
+		return false
+	}
+
+	gitDir := strings.TrimSpace(strings.TrimPrefix(string(b), "gitdir:"))
+	return strings.HasPrefix(string(b), "gitdir:") && filepath.Base(filepath.Dir(gitDir)) == "worktrees"
+}
+
+// skipLFSSmudge configure the repository to not download the LFS files on checkout
+func skipLFSSmudge(repo Repository) error {
+	if err := repo.SetConfig("filter.lfs.smudge", "git-lfs smudge --skip -- %f"); err != nil {
+		return err
+	}
+
+	return repo.SetConfig("filter.lfs.process", "git-lfs filter-process --skip")
+}
+
+func (gwp *gitWrapperProvider) Mirror(url, path string) (Repository, error) {
+	command := exec.Command("git", "clone", "--mirror", url, path)
+
+	if _, err := cmd.ExecWithOutput(command); err != nil {
+		return nil, newGitError(command.Args[1:], "", err)
+	}
+
+	return &gitWrapperRepository{path: path}, nil
 }
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"fmt"
 	"github.com/creekorful/srcode/internal/cmd"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 )
 
 //go:generate mockgen -destination=../repository_mock/repository_mock.go -package=repository_mock . Repository,Provider
@@ -14,12 +17,57 @@ type Repository interface {
 	Push(repo, refspec string) error
 	Pull(repo, refspec string) error
 	AddRemote(name, url string) error
+	// RemoveRemote remove the remote and its remote branches
+	RemoveRemote(name string) error
 	Remote(name string) (string, error)
 	Config(key string) (string, error)
 	SetConfig(key, value string) error
-	RawCmd(args []string, writer io.Writer) error
+	// RawCmd execute the git command, env being added to the environment of the process
+	RawCmd(args []string, env []string, writer io.Writer) error
 	Head() (string, error)
+	HeadCommit() (string, error)
 	IsDirty() (bool, error)
+	IsIgnored(path string) (bool, error)
+	// Fetch update the references from the remote, and remove the ones deleted remotely
+	Fetch(repo string) error
+	// Checkout switch to given branch (creating it from the remote one if needed) or commit
+	Checkout(ref string) error
+	// Reset set the current branch to given ref, and discard the local changes (i.e git reset --hard)
+	Reset(ref string) error
+	// CreateBranch create a branch starting at HEAD and switch to it (the local changes are kept)
+	CreateBranch(name string) error
+	// Commits returns the commits reachable from to but not from from (i.e from..to), the most recent first
+	Commits(from, to string) ([]Commit, error)
+	// Worktrees returns the linked worktrees of the repository (the main one excluded)
+	Worktrees() ([]Worktree, error)
+	// AddWorktree create a worktree at given path with the branch checked out
+	AddWorktree(path, branch string) error
+	// RemoveWorktree remove the worktree at given path, force allow to remove it even if dirty
+	RemoveWorktree(path string, force bool) error
+	// Refs returns the hash of the references (branches, remote branches, tags...), indexed by their name
+	// the symbolic references (e.g HEAD) are excluded
+	Refs() (map[string]string, error)
+	// UpdateRef set the reference to given hash, or delete it if hash is empty
+	UpdateRef(name, hash string) error
+	// CreateBundle write a bundle containing all the references, without the objects reachable from exclude
+	// ErrEmptyBundle is returned if there is no new objects
+	CreateBundle(path string, exclude []string) error
+	// FetchBundle fetch the objects & the references of the bundle
+	FetchBundle(path string) error
+}
+
+// Commit is a git commit
+type Commit struct {
+	Hash    string
+	Subject string
+}
+
+// Worktree is a linked worktree of a repository
+type Worktree struct {
+	Path string
+	// Branch is the checked out branch (empty if HEAD is detached)
+	Branch string
+	Commit string
 }
 
 type gitWrapperRepository struct {
@@ -48,11 +96,157 @@ func (gwr *gitWrapperRepository) Pull(repo, refspec string) error {
 	return err
 }
 
+func (gwr *gitWrapperRepository) Fetch(repo string) error {
+	_, err := gwr.execWithOutput("fetch", "--prune", repo)
+	return err
+}
+
+func (gwr *gitWrapperRepository) Checkout(ref string) error {
+	_, err := gwr.execWithOutput("checkout", ref)
+	return err
+}
+
+func (gwr *gitWrapperRepository) Reset(ref string) error {
+	_, err := gwr.execWithOutput("reset", "--hard", ref)
+	return err
+}
+
+func (gwr *gitWrapperRepository) CreateBranch(name string) error {
+	_, err := gwr.execWithOutput("checkout", "-b", name)
+	return err
+}
+
+func (gwr *gitWrapperRepository) Commits(from, to string) ([]Commit, error) {
+	out, err := gwr.execWithOutput("log", "--format=%H%x09%s", fmt.Sprintf("%s..%s", from, to))
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []Commit
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 2)
+		commit := Commit{Hash: parts[0]}
+		if len(parts) == 2 {
+			commit.Subject = parts[1]
+		}
+		commits = append(commits, commit)
+	}
+
+	return commits, nil
+}
+
+func (gwr *gitWrapperRepository) Worktrees() ([]Worktree, error) {
+	out, err := gwr.execWithOutput("worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	// the worktrees are separated by an empty line, the first one being the main worktree
+	var worktrees []Worktree
+	for i, block := range strings.Split(out, "\n\n") {
+		if i == 0 {
+			continue
+		}
+
+		var worktree Worktree
+		for _, line := range strings.Split(block, "\n") {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			switch parts[0] {
+			case "worktree":
+				worktree.Path = parts[1]
+			case "HEAD":
+				worktree.Commit = parts[1]
+			case "branch":
+				worktree.Branch = strings.TrimPrefix(parts[1], "refs/heads/")
+			}
+		}
+
+		if worktree.Path != "" {
+			worktrees = append(worktrees, worktree)
+		}
+	}
+
+	return worktrees, nil
+}
+
+func (gwr *gitWrapperRepository) AddWorktree(path, branch string) error {
+	_, err := gwr.execWithOutput("worktree", "add", path, branch)
+	return err
+}
+
+func (gwr *gitWrapperRepository) RemoveWorktree(path string, force bool) error {
+	args := []string{"worktree", "remove"}
+	if force {
+		args = append(args, "--force")
+	}
+
+	_, err := gwr.execWithOutput(append(args, path)...)
+	return err
+}
+
+func (gwr *gitWrapperRepository) Refs() (map[string]string, error) {
+	out, err := gwr.execWithOutput("for-each-ref", "--format=%(objectname)%09%(refname)%09%(symref)")
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) != 3 || parts[2] != "" {
+			continue
+		}
+
+		refs[parts[1]] = parts[0]
+	}
+
+	return refs, nil
+}
+
+func (gwr *gitWrapperRepository) UpdateRef(name, hash string) error {
+	args := []string{"update-ref", name, hash}
+	if hash == "" {
+		args = []string{"update-ref", "-d", name}
+	}
+
+	_, err := gwr.execWithOutput(args...)
+	return err
+}
+
+func (gwr *gitWrapperRepository) CreateBundle(path string, exclude []string) error {
+	args := []string{"bundle", "create", path, "--all"}
+	if len(exclude) > 0 {
+		args = append(append(args, "--not"), exclude...)
+	}
+
+	_, err := gwr.execWithOutput(args...)
+	return err
+}
+
+func (gwr *gitWrapperRepository) FetchBundle(path string) error {
+	// the current branch may be updated since the references are restored as is
+	_, err := gwr.execWithOutput("fetch", "--update-head-ok", path, "+refs/*:refs/*")
+	return err
+}
+
 func (gwr *gitWrapperRepository) AddRemote(name, url string) error {
 	_, err := gwr.execWithOutput("remote", "add", name, url)
 	return err
 }
 
+func (gwr *gitWrapperRepository) RemoveRemote(name string) error {
+	_, err := gwr.execWithOutput("remote", "remove", name)
+	return err
+}
+
 func (gwr *gitWrapperRepository) Remote(name string) (string, error) {
 	return gwr.execWithOutput("remote", "get-url", name)
 }
@@ -66,9 +260,12 @@ func (gwr *gitWrapperRepository) SetConfig(key, value string) error {
 	return err
 }
 
-func (gwr *gitWrapperRepository) RawCmd(args []string, writer io.Writer) error {
+func (gwr *gitWrapperRepository) RawCmd(args []string, env []string, writer io.Writer) error {
 	command := exec.Command("git", args...)
 	command.Dir = gwr.path
+	if len(env) > 0 {
+		command.Env = append(os.Environ(), env...)
+	}
 	command.Stdout = writer
 	command.Stderr = writer
 
@@ -79,6 +276,10 @@ func (gwr *gitWrapperRepository) Head() (string, error) {
 	return gwr.execWithOutput("rev-parse", "--abbrev-ref", "HEAD")
 }
 
+func (gwr *gitWrapperRepository) HeadCommit() (string, error) {
+	return gwr.execWithOutput("rev-parse", "HEAD")
+}
+
 func (gwr *gitWrapperRepository) IsDirty() (bool, error) {
 	res, err := gwr.execWithOutput("status", "--short")
 	if err != nil {
@@ -88,9 +289,30 @@ func (gwr *gitWrapperRepository) IsDirty() (bool, error) {
 	return res != "", nil
 }
 
+func (gwr *gitWrapperRepository) IsIgnored(path string) (bool, error) {
+	command := exec.Command("git", "check-ignore", "--quiet", path)
+	command.Dir = gwr.path
+
+	// check-ignore exit with status code 1 if the path is not ignored
+	err := command.Run()
+	if err == nil {
+		return true, nil
+	}
+	if cmd.GetExitCode(err) == 1 {
+		return false, nil
+	}
+
+	return false, newGitError(command.Args[1:], gwr.path, err)
+}
+
 func (gwr *gitWrapperRepository) execWithOutput(args ...string) (string, error) {
 	command := exec.Command("git", args...)
 	command.Dir = gwr.path
 
-	return cmd.ExecWithOutput(command)
+	res, err := cmd.ExecWithOutput(command)
+	if err != nil {
+		return "", newGitError(args, gwr.path, err)
+	}
+
+	return res, nil
 }
@@ -0,0 +1,633 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitWrapperRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	testRepository(t, &gitWrapperProvider{})
+}
+
+func TestGoGitRepository(t *testing.T) {
+	testRepository(t, &goGitProvider{})
+
+	// the local remotes are served without replacing the go-git file transport
+	if _, ok := client.Protocols["file"].(*localTransport); ok {
+		t.Error("the go-git file transport should not be replaced")
+	}
+}
+
+func TestGoGitRepository_PullDiverged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	provider := &goGitProvider{}
+
+	remotePath := filepath.Join(dir, "remote.git")
+	if _, err := git.PlainInit(remotePath, true); err != nil {
+		t.Fatal(err)
+	}
+	remoteURL := "file://" + filepath.ToSlash(remotePath)
+
+	var repos []Repository
+	for _, name := range []string{"first", "second"} {
+		repo, err := provider.Clone(remoteURL, filepath.Join(dir, name), CloneOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		setIdentity(t, repo)
+		repos = append(repos, repo)
+	}
+
+	writeFile(t, filepath.Join(dir, "first", "README.md"), "# srcode")
+	if err := repos[0].CommitFiles("Add README.md", "README.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos[0].Push("origin", "master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos[1].Pull("origin", "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the histories diverge without conflicting: the local commits are rebased using the git binary
+	writeFile(t, filepath.Join(dir, "first", "main.go"), "package main")
+	if err := repos[0].CommitFiles("Add main.go", "main.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos[0].Push("origin", "master"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "second", "go.mod"), "module srcode")
+	if err := repos[1].CommitFiles("Add go.mod", "go.mod"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repos[1].Pull("origin", "master"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"README.md", "main.go", "go.mod"} {
+		if _, err := os.Stat(filepath.Join(dir, "second", file)); err != nil {
+			t.Errorf("%s should exist after the pull: %s", file, err)
+		}
+	}
+	if dirty, err := repos[1].IsDirty(); err != nil || dirty {
+		t.Errorf("the repository should be clean (%v)", err)
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	cfg := config.NewConfig()
+	if backend := selectBackend("", cfg); backend != "" {
+		t.Errorf("got %s want no backend", backend)
+	}
+
+	cfg.Raw.Section("srcode").SetOption("gitBackend", GoGitBackend)
+	if backend := selectBackend("", cfg); backend != GoGitBackend {
+		t.Errorf("got %s want %s", backend, GoGitBackend)
+	}
+
+	// the environment variable takes precedence
+	if backend := selectBackend(ExecBackend, cfg); backend != ExecBackend {
+		t.Errorf("got %s want %s", backend, ExecBackend)
+	}
+}
+
+// testRepository is the behavioural test suite every backend must pass
+func testRepository(t *testing.T, provider Provider) {
+	dir := t.TempDir()
+
+	// the bare remote is created using go-git to not depend on the backend
+	remotePath := filepath.Join(dir, "remote.git")
+	remote, err := git.PlainInit(remotePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteURL := "file://" + filepath.ToSlash(remotePath)
+
+	// Init
+	localPath := filepath.Join(dir, "local")
+	local, err := provider.Init(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !provider.Exists(localPath) {
+		t.Error("local repository should exist")
+	}
+	if provider.Exists(dir) {
+		t.Error("directory should not be a repository")
+	}
+	setIdentity(t, local)
+
+	// Config
+	if err := local.SetConfig("srcode.remote.test", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := local.Config("srcode.remote.test"); err != nil || val != "value" {
+		t.Errorf("wrong config value: %s (%v)", val, err)
+	}
+	if _, err := local.Config("srcode.missing"); err == nil {
+		t.Error("missing config should return an error")
+	}
+
+	// CommitFiles & IsDirty
+	writeFile(t, filepath.Join(localPath, "README.md"), "hello")
+	if dirty, err := local.IsDirty(); err != nil || !dirty {
+		t.Errorf("repository should be dirty (%v)", err)
+	}
+	if err := local.CommitFiles("Initial commit", "README.md"); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err := local.IsDirty(); err != nil || dirty {
+		t.Errorf("repository should not be dirty (%v)", err)
+	}
+
+	branch, err := local.Head()
+	if err != nil || branch == "" || branch == "HEAD" {
+		t.Fatalf("wrong head: %s (%v)", branch, err)
+	}
+
+	// the default branch depends on the git configuration
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+	if err := remote.Storer.SetReference(head); err != nil {
+		t.Fatal(err)
+	}
+
+	headCommit, err := local.HeadCommit()
+	if err != nil || len(headCommit) != 40 {
+		t.Fatalf("wrong head commit: %s (%v)", headCommit, err)
+	}
+
+	// AddRemote, Remote & Push
+	if err := local.AddRemote("origin", remoteURL); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := local.Remote("origin"); err != nil || url != remoteURL {
+		t.Errorf("wrong remote: %s (%v)", url, err)
+	}
+	if _, err := local.Remote("upstream"); err == nil {
+		t.Error("missing remote should return an error")
+	}
+	if err := local.SetConfig("remote.origin.url", "file:///tmp/moved.git"); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := local.Remote("origin"); err != nil || url != "file:///tmp/moved.git" {
+		t.Errorf("wrong remote after config update: %s (%v)", url, err)
+	}
+	if err := local.SetConfig("remote.origin.url", remoteURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Push("origin", branch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clone
+	clonePath := filepath.Join(dir, "clone")
+	clone, err := provider.Clone(remoteURL, clonePath, CloneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setIdentity(t, clone)
+
+	if val, err := clone.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong clone head commit: %s (%v)", val, err)
+	}
+	if val, err := clone.Head(); err != nil || val != branch {
+		t.Errorf("wrong clone head: %s (%v)", val, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(clonePath, "README.md")); err != nil || string(b) != "hello" {
+		t.Errorf("wrong clone content: %s (%v)", b, err)
+	}
+
+	// Pull
+	initialCommit := headCommit
+	writeFile(t, filepath.Join(localPath, "main.go"), "package main")
+	if err := local.CommitFiles("Add main.go", "main.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Push("origin", branch); err != nil {
+		t.Fatal(err)
+	}
+	if headCommit, err = local.HeadCommit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clone.Pull("origin", branch); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := clone.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong clone head commit after pull: %s (%v)", val, err)
+	}
+	if err := clone.Pull("origin", branch); err != nil {
+		t.Errorf("pulling an up-to-date repository should not fail: %v", err)
+	}
+
+	// Checkout
+	if err := local.Push("origin", fmt.Sprintf("refs/heads/%s:refs/heads/develop", branch)); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Checkout("develop"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := clone.Head(); err != nil || val != "develop" {
+		t.Errorf("wrong head after checkout: %s (%v)", val, err)
+	}
+	if val, err := clone.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong head commit after checkout: %s (%v)", val, err)
+	}
+	if err := clone.Checkout(branch); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := clone.Head(); err != nil || val != branch {
+		t.Errorf("wrong head after checkout: %s (%v)", val, err)
+	}
+	if err := clone.Checkout("missing"); err == nil {
+		t.Error("checking out a missing branch should return an error")
+	}
+	if err := clone.Checkout(initialCommit); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := clone.HeadCommit(); err != nil || val != initialCommit {
+		t.Errorf("wrong head commit after commit checkout: %s (%v)", val, err)
+	}
+	if val, err := clone.Head(); err != nil || val != "HEAD" {
+		t.Errorf("wrong head after commit checkout: %s (%v)", val, err)
+	}
+	if err := clone.Checkout(branch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reset
+	writeFile(t, filepath.Join(clonePath, "main.go"), "package dirty")
+	if err := clone.Reset("HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err := clone.IsDirty(); err != nil || dirty {
+		t.Errorf("the local changes should be discarded (%v)", err)
+	}
+	if err := clone.Reset("missing"); err == nil {
+		t.Error("resetting to an unknown revision should return an error")
+	}
+
+	// CreateBranch
+	writeFile(t, filepath.Join(clonePath, "README.md"), "work in progress")
+	if err := clone.CreateBranch("feature/topic"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := clone.Head(); err != nil || val != "feature/topic" {
+		t.Errorf("wrong head after branch creation: %s (%v)", val, err)
+	}
+	if dirty, err := clone.IsDirty(); err != nil || !dirty {
+		t.Errorf("the local changes should be kept (%v)", err)
+	}
+	if err := clone.CreateBranch("feature/topic"); err == nil {
+		t.Error("creating an existing branch should return an error")
+	}
+	writeFile(t, filepath.Join(clonePath, "README.md"), "hello")
+	if err := clone.Checkout(branch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commits
+	commits, err := clone.Commits(initialCommit, headCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0] != (Commit{Hash: headCommit, Subject: "Add main.go"}) {
+		t.Errorf("wrong commits: %v", commits)
+	}
+	if commits, err := clone.Commits(headCommit, initialCommit); err != nil || len(commits) != 0 {
+		t.Errorf("wrong commits: %v (%v)", commits, err)
+	}
+	if _, err := clone.Commits(initialCommit, strings.Repeat("a", 40)); err == nil {
+		t.Error("listing the commits of an unknown revision should return an error")
+	}
+
+	// Clone with options
+	shallowPath := filepath.Join(dir, "shallow")
+	shallow, err := provider.Clone(remoteURL, shallowPath, CloneOptions{Depth: 1, Branch: branch, SingleBranch: true, SkipLFS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err := shallow.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong shallow clone head commit: %s (%v)", val, err)
+	}
+	if val, err := shallow.Config("filter.lfs.process"); err != nil || val != "git-lfs filter-process --skip" {
+		t.Errorf("wrong lfs configuration: %s (%v)", val, err)
+	}
+
+	// Mirror
+	mirrorPath := filepath.Join(dir, "mirror.git")
+	mirror, err := provider.Mirror(remoteURL, mirrorPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err := mirror.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong mirror head commit: %s (%v)", val, err)
+	}
+
+	// IsIgnored
+	writeFile(t, filepath.Join(clonePath, ".gitignore"), "*.log\nbuild/\n")
+	if err := os.Mkdir(filepath.Join(clonePath, "build"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"app.log":                           true,
+		"app.go":                            false,
+		"build":                             true,
+		filepath.Join(clonePath, "build"):   true,
+		filepath.Join(clonePath, "main.go"): false,
+	}
+	for path, expected := range cases {
+		if ignored, err := clone.IsIgnored(path); err != nil || ignored != expected {
+			t.Errorf("wrong ignored status for %s: %v (%v)", path, ignored, err)
+		}
+	}
+
+	// RawCmd
+	if _, err := exec.LookPath("git"); err == nil {
+		var buf bytes.Buffer
+		if err := clone.RawCmd([]string{"log", "--format=%s"}, nil, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if val := strings.TrimSpace(buf.String()); val != "Add main.go\nInitial commit" {
+			t.Errorf("wrong raw command output: %s", val)
+		}
+
+		// the environment is added to the one of the process
+		buf.Reset()
+		if err := clone.RawCmd([]string{"var", "GIT_AUTHOR_IDENT"}, []string{"GIT_AUTHOR_NAME=srcode-env"}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if val := buf.String(); !strings.HasPrefix(val, "srcode-env <") {
+			t.Errorf("wrong raw command output: %s", val)
+		}
+	}
+
+	// Worktrees
+	if worktrees, err := clone.Worktrees(); err != nil || len(worktrees) != 0 {
+		t.Errorf("wrong worktrees: %v (%v)", worktrees, err)
+	}
+	if _, err := exec.LookPath("git"); err == nil {
+		worktreePath := filepath.Join(dir, "clone@develop")
+		if err := clone.AddWorktree(worktreePath, "develop"); err != nil {
+			t.Fatal(err)
+		}
+
+		worktrees, err := clone.Worktrees()
+		if err != nil || len(worktrees) != 1 {
+			t.Fatalf("wrong worktrees: %v (%v)", worktrees, err)
+		}
+		if path, _ := filepath.EvalSymlinks(worktrees[0].Path); path != mustEvalSymlinks(t, worktreePath) {
+			t.Errorf("wrong worktree path: %s", worktrees[0].Path)
+		}
+		if worktrees[0].Branch != "develop" || worktrees[0].Commit != headCommit {
+			t.Errorf("wrong worktree: %v", worktrees[0])
+		}
+
+		if !IsWorktree(worktreePath) || IsWorktree(clonePath) {
+			t.Error("only the linked worktree should be detected as worktree")
+		}
+		if provider.Exists(worktreePath) || !provider.Exists(clonePath) {
+			t.Error("a linked worktree should not be considered as a repository")
+		}
+
+		writeFile(t, filepath.Join(worktreePath, "README.md"), "review in progress")
+		if err := clone.RemoveWorktree(worktreePath, false); err == nil {
+			t.Error("removing a dirty worktree should return an error")
+		}
+		if err := clone.RemoveWorktree(worktreePath, true); err != nil {
+			t.Fatal(err)
+		}
+		if worktrees, err := clone.Worktrees(); err != nil || len(worktrees) != 0 {
+			t.Errorf("wrong worktrees after removal: %v (%v)", worktrees, err)
+		}
+	}
+
+	// Refs
+	refs, err := clone.Refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs["refs/heads/"+branch] != headCommit || refs["refs/remotes/origin/develop"] != headCommit {
+		t.Errorf("wrong refs: %v", refs)
+	}
+	if _, exist := refs["refs/remotes/origin/HEAD"]; exist {
+		t.Errorf("the symbolic refs should be excluded: %v", refs)
+	}
+
+	// Bundles
+	if _, err := exec.LookPath("git"); err == nil {
+		bundlePath := filepath.Join(dir, "clone.bundle")
+		if err := clone.CreateBundle(bundlePath, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertGitError(t, clone.CreateBundle(filepath.Join(dir, "empty.bundle"), []string{headCommit}), ErrEmptyBundle)
+
+		restored, err := provider.Init(filepath.Join(dir, "restored"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.FetchBundle(bundlePath); err != nil {
+			t.Fatal(err)
+		}
+
+		restoredRefs, err := restored.Refs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restoredRefs["refs/heads/"+branch] != headCommit || restoredRefs["refs/remotes/origin/develop"] != headCommit {
+			t.Errorf("wrong restored refs: %v", restoredRefs)
+		}
+		if err := restored.Checkout("develop"); err != nil {
+			t.Fatal(err)
+		}
+		if val, err := restored.HeadCommit(); err != nil || val != headCommit {
+			t.Errorf("wrong restored head commit: %s (%v)", val, err)
+		}
+		if dirty, err := restored.IsDirty(); err != nil || dirty {
+			t.Errorf("the restored repository should be clean (%v)", err)
+		}
+	}
+
+	if err := clone.UpdateRef("refs/heads/backup", initialCommit); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/heads/backup"] != initialCommit {
+		t.Errorf("wrong refs after update: %v (%v)", refs, err)
+	}
+	if err := clone.UpdateRef("refs/heads/backup", ""); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/heads/backup"] != "" {
+		t.Errorf("wrong refs after deletion: %v (%v)", refs, err)
+	}
+
+	// Fetch remove the branches deleted remotely
+	if err := remote.Storer.RemoveReference(plumbing.NewBranchReferenceName("develop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/remotes/origin/develop"] != "" || refs["refs/remotes/origin/"+branch] != headCommit {
+		t.Errorf("wrong refs after fetch: %v (%v)", refs, err)
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/heads/develop"] != headCommit {
+		t.Errorf("the local branch should be kept: %v (%v)", refs, err)
+	}
+
+	// RemoveRemote
+	if err := clone.AddRemote("upstream", remoteURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Fetch("upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/remotes/upstream/"+branch] != headCommit {
+		t.Errorf("wrong refs after fetch: %v (%v)", refs, err)
+	}
+	if err := clone.RemoveRemote("upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.Remote("upstream"); err == nil {
+		t.Error("removed remote should return an error")
+	}
+	if refs, err := clone.Refs(); err != nil || refs["refs/remotes/upstream/"+branch] != "" || refs["refs/remotes/origin/"+branch] != headCommit {
+		t.Errorf("wrong refs after remote removal: %v (%v)", refs, err)
+	}
+	assertGitError(t, clone.RemoveRemote("upstream"), ErrRemoteNotFound)
+
+	// Errors
+	repo, err := provider.Open(dir)
+	if err == nil {
+		_, err = repo.Head()
+	}
+	assertGitError(t, err, ErrNotARepository)
+
+	_, err = provider.Clone("file://"+filepath.ToSlash(filepath.Join(dir, "missing.git")), filepath.Join(dir, "missing"), CloneOptions{})
+	assertGitError(t, err, ErrRemoteNotFound)
+	assertGitError(t, local.Push("upstream", branch), ErrRemoteNotFound)
+	assertGitError(t, clone.Pull("origin", "missing"), ErrNoUpstream)
+
+	// make the histories diverge
+	writeFile(t, filepath.Join(clonePath, "main.go"), "package clone")
+	if err := clone.CommitFiles("Update main.go", "main.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Push("origin", branch); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(localPath, "main.go"), "package local")
+	if err := local.CommitFiles("Update main.go", "main.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertGitError(t, local.Push("origin", branch), ErrConflict)
+
+	// the go-git backend requires the git binary to rebase the local commits
+	if _, isGoGit := provider.(*goGitProvider); isGoGit && !gitInstalled() {
+		assertGitError(t, local.Pull("origin", branch), ErrNotSupported)
+	} else {
+		assertGitError(t, local.Pull("origin", branch), ErrConflict)
+	}
+
+	// Fetch & Clone using a reference repository
+	if headCommit, err = clone.HeadCommit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := mirror.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong mirror head commit after fetch: %s (%v)", val, err)
+	}
+
+	referencePath := filepath.Join(dir, "reference")
+	reference, err := provider.Clone(remoteURL, referencePath, CloneOptions{Reference: mirrorPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err := reference.HeadCommit(); err != nil || val != headCommit {
+		t.Errorf("wrong reference clone head commit: %s (%v)", val, err)
+	}
+	if url, err := reference.Remote("origin"); err != nil || url != remoteURL {
+		t.Errorf("wrong reference clone remote: %s (%v)", url, err)
+	}
+	if _, err := os.Stat(filepath.Join(referencePath, ".git", "objects", "info", "alternates")); err == nil {
+		t.Error("the clone should not depend on the reference repository")
+	}
+
+	// the reference is optional
+	_, err = provider.Clone(remoteURL, filepath.Join(dir, "no-reference"), CloneOptions{Reference: filepath.Join(dir, "missing.git")})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func assertGitError(t *testing.T, err error, expected error) {
+	t.Helper()
+
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		t.Errorf("expected git error, got: %v", err)
+		return
+	}
+	if len(gitErr.Args) == 0 {
+		t.Errorf("missing git error arguments: %v", err)
+	}
+	if !errors.Is(err, expected) {
+		t.Errorf("expected %v, got: %v", expected, err)
+	}
+}
+
+func gitInstalled() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+func setIdentity(t *testing.T, repo Repository) {
+	if err := repo.SetConfig("user.name", "srcode"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetConfig("user.email", "srcode@example.org"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustEvalSymlinks(t *testing.T, path string) string {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,135 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrKeyNotFound is returned when the key to decrypt the secrets is not available
+	ErrKeyNotFound = errors.New("secret key not found")
+)
+
+// Keyring is where the keys used to decrypt the secrets are stored
+// The keys are age X25519 identities, stored in a file named after their public key (recipient)
+type Keyring struct {
+	Dir string
+}
+
+// DefaultKeyring returns the keyring located in the user configuration directory
+// The location can be overridden using the SRCODE_KEYS_DIR environment variable
+func DefaultKeyring() Keyring {
+	if dir := os.Getenv("SRCODE_KEYS_DIR"); dir != "" {
+		return Keyring{Dir: dir}
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return Keyring{}
+	}
+
+	return Keyring{Dir: filepath.Join(configDir, "srcode", "keys")}
+}
+
+// Generate a new key and store it in the keyring
+// the public key is returned
+func (k Keyring) Generate() (string, error) {
+	if k.Dir == "" {
+		return "", fmt.Errorf("unable to store secret key: no keyring directory")
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(k.Dir, 0700); err != nil {
+		return "", err
+	}
+
+	recipient := identity.Recipient().String()
+	if err := ioutil.WriteFile(k.Path(recipient), []byte(identity.String()+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	return recipient, nil
+}
+
+// Path returns the path of the key file for given public key
+func (k Keyring) Path(publicKey string) string {
+	return filepath.Join(k.Dir, publicKey)
+}
+
+// Encrypt given value using given public key
+// The result is base64 encoded
+func Encrypt(publicKey, value string) (string, error) {
+	recipient, err := age.ParseX25519Recipient(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	b := &bytes.Buffer{}
+	w, err := age.Encrypt(b, recipient)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := w.Write([]byte(value)); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// Decrypt given (base64 encoded) value using the private key matching given public key
+func (k Keyring) Decrypt(publicKey, value string) (string, error) {
+	identity, err := k.identity(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(b), identity)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(res), nil
+}
+
+func (k Keyring) identity(publicKey string) (*age.X25519Identity, error) {
+	if k.Dir == "" || publicKey == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	b, err := ioutil.ReadFile(k.Path(publicKey))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w (expected at %s)", ErrKeyNotFound, k.Path(publicKey))
+		}
+
+		return nil, err
+	}
+
+	return age.ParseX25519Identity(strings.TrimSpace(string(b)))
+}
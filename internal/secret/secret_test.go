@@ -0,0 +1,72 @@
+package secret
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring := Keyring{Dir: t.TempDir()}
+
+	publicKey, err := keyring.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make sure the private key is stored & protected
+	info, err := os.Stat(keyring.Path(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("wrong key permissions: %s", info.Mode().Perm())
+	}
+
+	val, err := Encrypt(publicKey, "my-api-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val == "my-api-token" {
+		t.Fail()
+	}
+
+	res, err := keyring.Decrypt(publicKey, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "my-api-token" {
+		t.Errorf("got %s want my-api-token", res)
+	}
+
+	// Decrypt with unknown key
+	otherKeyring := Keyring{Dir: t.TempDir()}
+	if _, err := otherKeyring.Decrypt(publicKey, val); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("got %v want %v", err, ErrKeyNotFound)
+	}
+
+	// Decrypt with wrong key
+	otherPublicKey, err := otherKeyring.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherKeyring.Decrypt(otherPublicKey, val); err == nil {
+		t.Fail()
+	}
+
+	// Encrypt with invalid key
+	if _, err := Encrypt("invalid", "test"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestDefaultKeyring(t *testing.T) {
+	if err := os.Setenv("SRCODE_KEYS_DIR", "/tmp/keys"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("SRCODE_KEYS_DIR")
+
+	if keyring := DefaultKeyring(); keyring.Dir != "/tmp/keys" {
+		t.Errorf("got %s want /tmp/keys", keyring.Dir)
+	}
+}
@@ -0,0 +1,386 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveMarker is the file (inside the project directory) containing the checksum of the extracted archive
+const archiveMarker = ".srcode-archive"
+
+var (
+	// ErrMissingChecksum is returned when the archive has no checksum
+	ErrMissingChecksum = errors.New("the archive checksum is required")
+	// ErrUnsupportedArchive is returned when the archive format (or its location) is not supported
+	ErrUnsupportedArchive = errors.New("unsupported archive")
+)
+
+// archiveSource is an archive (tar, tar.gz or zip) downloaded and extracted in the project directory
+// If the archive contains a single directory, its content is extracted
+type archiveSource struct {
+	client *http.Client
+}
+
+func (s *archiveSource) Sync(src manifest.Source, path string) (bool, error) {
+	if src.Checksum == "" {
+		return false, fmt.Errorf("unable to sync archive %s: %w", src.URL, ErrMissingChecksum)
+	}
+
+	// the directory is only replaced if it has been extracted by srcode
+	if _, err := os.Lstat(path); err == nil {
+		checksum, err := ioutil.ReadFile(filepath.Join(path, archiveMarker))
+		if err != nil {
+			return false, fmt.Errorf("unable to extract archive at %s: %w", path, ErrPathExist)
+		}
+
+		if strings.EqualFold(strings.TrimSpace(string(checksum)), src.Checksum) {
+			return false, nil
+		}
+	}
+
+	file, err := s.download(src)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file)
+
+	// extract in a temporary directory next to the project, then swap them
+	tmpDir := path + ".srcode-tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extract(file, src.URL, tmpDir); err != nil {
+		return false, fmt.Errorf("unable to extract archive %s: %w", src.URL, err)
+	}
+
+	root, err := archiveRoot(tmpDir)
+	if err != nil {
+		return false, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, archiveMarker), []byte(strings.ToLower(src.Checksum)+"\n"), 0640); err != nil {
+		return false, err
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(root, path); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// download the archive into a temporary file, and verify its checksum
+func (s *archiveSource) download(src manifest.Source) (string, error) {
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		return "", err
+	}
+
+	var reader io.ReadCloser
+	switch u.Scheme {
+	case "file":
+		if reader, err = os.Open(filepath.FromSlash(u.Path)); err != nil {
+			return "", err
+		}
+	case "http", "https":
+		res, err := s.client.Get(src.URL)
+		if err != nil {
+			return "", err
+		}
+		if res.StatusCode != http.StatusOK {
+			_ = res.Body.Close()
+			return "", fmt.Errorf("error while downloading %s: %s", src.URL, res.Status)
+		}
+		reader = res.Body
+	default:
+		return "", fmt.Errorf("unable to download %s: %w", src.URL, ErrUnsupportedArchive)
+	}
+	defer reader.Close()
+
+	file, err := ioutil.TempFile("", "srcode-archive-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), reader); err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(checksum, src.Checksum) {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("invalid archive %s (got %s): %w", src.URL, checksum, ErrChecksumMismatch)
+	}
+
+	return file.Name(), nil
+}
+
+// extract the archive into dir, the format is deduced from the archive name
+func extract(file, name, dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(file, dir)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		return extractTar(gz, dir)
+	case strings.HasSuffix(name, ".tar"):
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return extractTar(f, dir)
+	default:
+		return ErrUnsupportedArchive
+	}
+}
+
+func extractTar(reader io.Reader, dir string) error {
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archivePath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// prevent the links from escaping the directory
+			if filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("invalid link %s: %w", header.Name, ErrUnsupportedArchive)
+			}
+			if _, err := archivePath(dir, filepath.Join(filepath.Dir(header.Name), header.Linkname)); err != nil {
+				return err
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+			// the link may still escape through the previous links (e.g. c -> a/b/.. with a/b -> ..)
+			if err := checkResolvedPath(dir, filepath.Join(filepath.Dir(target), header.Linkname)); err != nil {
+				_ = os.Remove(target)
+				return fmt.Errorf("invalid link %s: %w", header.Name, ErrUnsupportedArchive)
+			}
+		}
+	}
+}
+
+func extractZip(file, dir string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target, err := archivePath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0750); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reader, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(target, reader, f.Mode().Perm())
+		_ = reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archivePath returns the path of the archive entry, making sure it is located inside dir
+// once the links extracted so far are resolved
+func archivePath(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	if !isInside(dir, target) {
+		return "", fmt.Errorf("invalid path %s: %w", name, ErrUnsupportedArchive)
+	}
+
+	if err := checkResolvedPath(dir, target); err != nil {
+		return "", fmt.Errorf("invalid path %s: %w", name, err)
+	}
+
+	return target, nil
+}
+
+// checkResolvedPath make sure path is located inside dir once the links are resolved
+func checkResolvedPath(dir, path string) error {
+	root, err := resolvePath(dir)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	if !isInside(root, resolved) {
+		return ErrUnsupportedArchive
+	}
+
+	return nil
+}
+
+// resolvePath returns the absolute path once the links are resolved
+// Unlike filepath.EvalSymlinks the path (or the link target) doesn't have to exist
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	volume := filepath.VolumeName(path)
+	resolved := volume + string(os.PathSeparator)
+	remaining := strings.Split(path[len(volume):], string(os.PathSeparator))
+
+	for links := 0; len(remaining) > 0; {
+		part := remaining[0]
+		remaining = remaining[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+
+		info, err := os.Lstat(next)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many links in %s", path)
+		}
+
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = filepath.VolumeName(link) + string(os.PathSeparator)
+			link = link[len(filepath.VolumeName(link)):]
+		}
+
+		remaining = append(strings.Split(link, string(os.PathSeparator)), remaining...)
+	}
+
+	return resolved, nil
+}
+
+// isInside returns true if path is dir or is located inside it
+func isInside(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+func writeFile(path string, reader io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, reader); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// archiveRoot returns the directory containing the archive files
+// i.e the single directory of the archive if any
+func archiveRoot(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 1 && files[0].IsDir() {
+		return filepath.Join(dir, files[0].Name()), nil
+	}
+
+	return dir, nil
+}
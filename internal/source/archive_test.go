@@ -0,0 +1,258 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/creekorful/srcode/internal/manifest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveSource_Sync(t *testing.T) {
+	dir := t.TempDir()
+	source := &archiveSource{client: http.DefaultClient}
+
+	// tar.gz archive with a single top-level directory
+	archive := writeArchive(t, dir, "sdk-1.0.tar.gz", tarGz(t, map[string]string{
+		"sdk-1.0/README.md":   "v1",
+		"sdk-1.0/bin/tool.sh": "echo v1",
+	}))
+	src := manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+	path := filepath.Join(dir, "Vendor", "sdk")
+
+	if _, err := source.Sync(manifest.Source{URL: src.URL}, path); !errors.Is(err, ErrMissingChecksum) {
+		t.Errorf("got %v", err)
+	}
+
+	if _, err := source.Sync(manifest.Source{URL: src.URL, Checksum: "abcd"}, path); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v", err)
+	}
+
+	if updated, err := source.Sync(src, path); err != nil || !updated {
+		t.Fatalf("got %v %v", updated, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(path, "bin", "tool.sh")); err != nil || string(b) != "echo v1" {
+		t.Errorf("got %s %v", b, err)
+	}
+
+	// already up-to-date
+	if updated, err := source.Sync(src, path); err != nil || updated {
+		t.Errorf("got %v %v", updated, err)
+	}
+
+	// new version of the archive (served over http)
+	archive = writeArchive(t, dir, "sdk-2.0.zip", zipArchive(t, map[string]string{
+		"README.md": "v2",
+		"LICENSE":   "MIT",
+	}))
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	src = manifest.Source{Type: manifest.SourceArchive, URL: server.URL + "/sdk-2.0.zip", Checksum: checksum(t, archive)}
+	if updated, err := source.Sync(src, path); err != nil || !updated {
+		t.Fatalf("got %v %v", updated, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(path, "README.md")); err != nil || string(b) != "v2" {
+		t.Errorf("got %s %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "bin")); !os.IsNotExist(err) {
+		t.Errorf("previous version should be removed")
+	}
+
+	src.URL = server.URL + "/missing.zip"
+	if _, err := source.Sync(src, filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("download should fail")
+	}
+
+	// directory not managed by srcode
+	unmanaged := filepath.Join(dir, "unmanaged")
+	if err := os.MkdirAll(unmanaged, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Sync(src, unmanaged); !errors.Is(err, ErrPathExist) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestArchiveSource_Sync_InvalidPath(t *testing.T) {
+	dir := t.TempDir()
+	source := &archiveSource{client: http.DefaultClient}
+
+	archive := writeArchive(t, dir, "evil.tar.gz", tarGz(t, map[string]string{
+		"../../evil.sh": "rm -rf /",
+	}))
+	src := manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+
+	if _, err := source.Sync(src, filepath.Join(dir, "a", "b")); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.sh")); !os.IsNotExist(err) {
+		t.Errorf("file should not be extracted outside the project")
+	}
+
+	// the links are resolved using the ones extracted before (a/b -> .. makes c -> a/b/.. escape)
+	archive = writeArchive(t, dir, "chain.tar.gz", tarGzEntries(t, []tarEntry{
+		{name: "a/", dir: true},
+		{name: "a/b", link: ".."},
+		{name: "c", link: "a/b/.."},
+		{name: "c/escaped.txt", content: "escaped"},
+	}))
+	src = manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+
+	if _, err := source.Sync(src, filepath.Join(dir, "chain", "project")); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chain", "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("file should not be extracted outside the project")
+	}
+
+	// same thing when the escaping link is dangling at extraction time
+	archive = writeArchive(t, dir, "dangling.tar.gz", tarGzEntries(t, []tarEntry{
+		{name: "a/b", link: ".."},
+		{name: "c", link: "a/b/../escaped.txt"},
+		{name: "c", content: "escaped"},
+	}))
+	src = manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+
+	if _, err := source.Sync(src, filepath.Join(dir, "dangling", "project")); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dangling", "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("file should not be extracted outside the project")
+	}
+
+	// the links staying inside the project are extracted
+	archive = writeArchive(t, dir, "links.tar.gz", tarGzEntries(t, []tarEntry{
+		{name: "lib/v1/api.h", content: "api"},
+		{name: "lib/current", link: "v1"},
+		{name: "include", link: "lib/current/../current"},
+		{name: "include/extra.h", content: "extra"},
+	}))
+	src = manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+
+	if _, err := source.Sync(src, filepath.Join(dir, "links")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "links", "lib", "v1", "extra.h")); err != nil || string(b) != "extra" {
+		t.Errorf("got %s (%v)", b, err)
+	}
+
+	archive = writeArchive(t, dir, "sdk.rar", []byte("rar"))
+	src = manifest.Source{Type: manifest.SourceArchive, URL: "file://" + archive, Checksum: checksum(t, archive)}
+	if _, err := source.Sync(src, filepath.Join(dir, "sdk")); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func writeArchive(t *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func checksum(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256(b)
+	return hex.EncodeToString(hash[:])
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0640, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+type tarEntry struct {
+	name    string
+	content string
+	link    string
+	dir     bool
+}
+
+// tarGzEntries is like tarGz but keeps the entries order and supports the directories & the links
+func tarGzEntries(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0640, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		switch {
+		case entry.dir:
+			header = &tar.Header{Name: entry.name, Mode: 0750, Typeflag: tar.TypeDir}
+		case entry.link != "":
+			header = &tar.Header{Name: entry.name, Linkname: entry.link, Mode: 0777, Typeflag: tar.TypeSymlink}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
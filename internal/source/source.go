@@ -0,0 +1,55 @@
+package source
+
+//go:generate mockgen -destination=../source_mock/source_mock.go -package=source_mock . Source,Provider
+
+import (
+	"errors"
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"net/http"
+)
+
+var (
+	// ErrUnknownSource is returned when no source exist for the type
+	ErrUnknownSource = errors.New("unknown source type")
+	// ErrChecksumMismatch is returned when the checksum of the archive doesn't match the expected one
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrPathExist is returned when the path is already used by something not managed by the source
+	ErrPathExist = errors.New("a file already exist at the project path")
+
+	// DefaultProvider is the default source provider, supporting the archive & symlink sources
+	DefaultProvider = NewProvider(map[string]Source{
+		manifest.SourceArchive: &archiveSource{client: http.DefaultClient},
+		manifest.SourceSymlink: &symlinkSource{},
+	})
+)
+
+// Source materialise a project which is not a git repository
+type Source interface {
+	// Sync materialise the source at given path, or update it if the source has changed
+	// It returns false if the path was already up-to-date
+	Sync(src manifest.Source, path string) (bool, error)
+}
+
+// Provider returns the Source of a given type
+type Provider interface {
+	Source(sourceType string) (Source, error)
+}
+
+type provider struct {
+	sources map[string]Source
+}
+
+// NewProvider returns a provider using given sources, indexed by their type
+func NewProvider(sources map[string]Source) Provider {
+	return &provider{sources: sources}
+}
+
+func (p *provider) Source(sourceType string) (Source, error) {
+	source, exist := p.sources[sourceType]
+	if !exist {
+		return nil, fmt.Errorf("unable to find source %s: %w", sourceType, ErrUnknownSource)
+	}
+
+	return source, nil
+}
@@ -0,0 +1,61 @@
+package source
+
+import (
+	"fmt"
+	"github.com/creekorful/srcode/internal/manifest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symlinkSource is a symbolic link to a local directory (the target may start with ~/)
+// the target is not required to exist
+type symlinkSource struct{}
+
+func (s *symlinkSource) Sync(src manifest.Source, path string) (bool, error) {
+	target := expandHome(src.URL)
+
+	info, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if err == nil {
+		// only the symbolic links may be replaced
+		if info.Mode()&os.ModeSymlink == 0 {
+			return false, fmt.Errorf("unable to create symlink at %s: %w", path, ErrPathExist)
+		}
+
+		if current, err := os.Readlink(path); err == nil && current == target {
+			return false, nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return false, err
+	}
+
+	if err := os.Symlink(target, path); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// expandHome replace the leading ~/ by the user home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[2:])
+}
@@ -0,0 +1,70 @@
+package source
+
+import (
+	"errors"
+	"github.com/creekorful/srcode/internal/manifest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkSource_Sync(t *testing.T) {
+	dir := t.TempDir()
+	source := &symlinkSource{}
+
+	path := filepath.Join(dir, "Personal", "notes")
+	src := manifest.Source{Type: manifest.SourceSymlink, URL: filepath.Join(dir, "notes")}
+
+	if updated, err := source.Sync(src, path); err != nil || !updated {
+		t.Fatalf("got %v %v", updated, err)
+	}
+	if target, err := os.Readlink(path); err != nil || target != src.URL {
+		t.Errorf("got %s %v", target, err)
+	}
+
+	// already up-to-date
+	if updated, err := source.Sync(src, path); err != nil || updated {
+		t.Errorf("got %v %v", updated, err)
+	}
+
+	// the link is updated when the target change
+	src.URL = filepath.Join(dir, "other-notes")
+	if updated, err := source.Sync(src, path); err != nil || !updated {
+		t.Errorf("got %v %v", updated, err)
+	}
+	if target, err := os.Readlink(path); err != nil || target != src.URL {
+		t.Errorf("got %s %v", target, err)
+	}
+
+	// only the links are replaced
+	if err := os.MkdirAll(filepath.Join(dir, "Work"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Sync(src, filepath.Join(dir, "Work")); !errors.Is(err, ErrPathExist) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+
+	if val := expandHome("~/notes"); val != filepath.Join(home, "notes") {
+		t.Errorf("got %s", val)
+	}
+	if val := expandHome("/tmp/notes"); val != "/tmp/notes" {
+		t.Errorf("got %s", val)
+	}
+}
+
+func TestProvider_Source(t *testing.T) {
+	if _, err := DefaultProvider.Source(manifest.SourceSymlink); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := DefaultProvider.Source(manifest.SourceGit); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("got %v", err)
+	}
+}